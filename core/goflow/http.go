@@ -8,6 +8,7 @@ import (
 
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/tracing"
 )
 
 var httpInit sync.Once
@@ -28,8 +29,9 @@ func HTTP(cfg *runtime.Config) (*http.Client, *httpx.RetryConfig, *httpx.AccessC
 			Renegotiation: tls.RenegotiateOnceAsClient, // support single TLS renegotiation
 		}
 
+		// trace all calls made by the engine and its services
 		httpClient = &http.Client{
-			Transport: t,
+			Transport: tracing.NewTransport(t),
 			Timeout:   time.Duration(cfg.WebhooksTimeout) * time.Millisecond,
 		}
 
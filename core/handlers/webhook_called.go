@@ -10,9 +10,15 @@ import (
 	"github.com/nyaruka/mailroom/core/hooks"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/metrics"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	webhookCalls    = metrics.NewCounter("mailroom_webhook_calls_total", "the number of webhook calls made by flows by outcome", "status")
+	webhookDuration = metrics.NewHistogram("mailroom_webhook_duration_seconds", "the time taken by webhook calls made by flows", metrics.DefaultBuckets, "status")
+)
+
 func init() {
 	models.RegisterEventHandler(events.TypeWebhookCalled, handleWebhookCalled)
 }
@@ -30,6 +36,9 @@ func handleWebhookCalled(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx,
 		"extraction":   event.Extraction,
 	}).Debug("webhook called")
 
+	webhookCalls.Inc(string(event.Status))
+	webhookDuration.ObserveDuration(time.Millisecond*time.Duration(event.ElapsedMS), string(event.Status))
+
 	// if this was a resthook and the status was 410, that means we should remove it
 	if event.Status == flows.CallStatusSubscriberGone {
 		unsub := &models.ResthookUnsubscribe{
@@ -47,6 +47,17 @@ func (h *insertTicketsHook) Apply(ctx context.Context, rt *runtime.Runtime, tx *
 		return errors.Wrapf(err, "error inserting ticket opened events")
 	}
 
+	// automatically assign any unassigned tickets if the org has an assignment strategy
+	assignedEvents, err := models.TicketsAutoAssign(ctx, rt, tx, oa, tickets)
+	if err != nil {
+		return errors.Wrapf(err, "error auto assigning tickets")
+	}
+
+	// tickets which were auto assigned will have notified their assignee so don't notify all assignable users
+	for ticket := range assignedEvents {
+		delete(eventsByTicket, ticket)
+	}
+
 	// and insert logs/notifications for those
 	err = models.NotificationsFromTicketEvents(ctx, tx, oa, eventsByTicket)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -35,6 +36,8 @@ const (
 	ChannelConfigCallbackDomain      = "callback_domain"
 	ChannelConfigMaxConcurrentEvents = "max_concurrent_events"
 	ChannelConfigFCMID               = "FCM_ID"
+	ChannelConfigSendRate            = "send_rate"
+	ChannelConfigSendBurst           = "send_burst"
 )
 
 // Channel is the mailroom struct that represents channels
@@ -118,6 +121,22 @@ func (c *Channel) ConfigValue(key string, def string) string {
 	return def
 }
 
+// SendRate returns the maximum sustained rate in messages per second at which this channel should be sent to, and
+// the burst of messages it can tolerate above that rate, or a zero rate if it isn't throttled
+func (c *Channel) SendRate() (int, int) {
+	rate, _ := strconv.Atoi(c.ConfigValue(ChannelConfigSendRate, "0"))
+	if rate <= 0 {
+		return 0, 0
+	}
+
+	// burst defaults to a single second's worth of messages
+	burst, _ := strconv.Atoi(c.ConfigValue(ChannelConfigSendBurst, "0"))
+	if burst < rate {
+		burst = rate
+	}
+	return rate, burst
+}
+
 // ChannelReference return a channel reference for this channel
 func (c *Channel) ChannelReference() *assets.ChannelReference {
 	return assets.NewChannelReference(c.UUID(), c.Name())
@@ -18,7 +18,10 @@ func TestChannels(t *testing.T) {
 	defer testsuite.Reset(testsuite.ResetAll)
 
 	// add some tel specific config to channel 2
-	db.MustExec(`UPDATE channels_channel SET config = '{"matching_prefixes": ["250", "251"], "allow_international": true}' WHERE id = $1`, testdata.VonageChannel.ID)
+	db.MustExec(`UPDATE channels_channel SET config = '{"matching_prefixes": ["250", "251"], "allow_international": true, "send_rate": 5}' WHERE id = $1`, testdata.VonageChannel.ID)
+
+	// and give twitter channel a send rate with a burst
+	db.MustExec(`UPDATE channels_channel SET config = '{"send_rate": 10, "send_burst": 100}' WHERE id = $1`, testdata.TwitterChannel.ID)
 
 	// make twitter channel have a parent of twilio channel
 	db.MustExec(`UPDATE channels_channel SET parent_id = $1 WHERE id = $2`, testdata.TwilioChannel.ID, testdata.TwitterChannel.ID)
@@ -39,6 +42,8 @@ func TestChannels(t *testing.T) {
 		Prefixes           []string
 		AllowInternational bool
 		Parent             *assets.ChannelReference
+		SendRate           int
+		SendBurst          int
 	}{
 		{
 			testdata.TwilioChannel.ID,
@@ -50,6 +55,8 @@ func TestChannels(t *testing.T) {
 			nil,
 			false,
 			nil,
+			0,
+			0,
 		},
 		{
 			testdata.VonageChannel.ID,
@@ -61,6 +68,8 @@ func TestChannels(t *testing.T) {
 			[]string{"250", "251"},
 			true,
 			nil,
+			5,
+			5,
 		},
 		{
 			testdata.TwitterChannel.ID,
@@ -72,6 +81,8 @@ func TestChannels(t *testing.T) {
 			nil,
 			false,
 			assets.NewChannelReference(testdata.TwilioChannel.UUID, "Twilio"),
+			10,
+			100,
 		},
 	}
 
@@ -87,5 +98,9 @@ func TestChannels(t *testing.T) {
 		assert.Equal(t, tc.Prefixes, channel.MatchPrefixes())
 		assert.Equal(t, tc.AllowInternational, channel.AllowInternational())
 		assert.Equal(t, tc.Parent, channel.Parent())
+
+		rate, burst := channel.SendRate()
+		assert.Equal(t, tc.SendRate, rate)
+		assert.Equal(t, tc.SendBurst, burst)
 	}
 }
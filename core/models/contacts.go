@@ -922,6 +922,38 @@ func URNForID(ctx context.Context, db Queryer, oa *OrgAssets, urnID URNID) (urns
 	return urn.AsURN(oa)
 }
 
+const sqlCountContactURNSchemes = `
+  SELECT scheme, count(DISTINCT contact_id)
+    FROM contacts_contacturn
+   WHERE contact_id = ANY($1)
+GROUP BY scheme`
+
+// CountContactURNSchemes counts how many of the passed in contacts have a URN of each scheme, i.e. how many of them
+// could be sent to by a channel of that scheme
+func CountContactURNSchemes(ctx context.Context, db Queryer, contactIDs []ContactID) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(contactIDs) == 0 {
+		return counts, nil
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlCountContactURNSchemes, pq.Array(contactIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "error counting contact URN schemes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var scheme string
+		var count int
+		if err := rows.Scan(&scheme, &count); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact URN scheme count")
+		}
+		counts[scheme] = count
+	}
+
+	return counts, rows.Err()
+}
+
 // CalculateDynamicGroups recalculates all the dynamic groups for the passed in contact, recalculating
 // campaigns as necessary based on those group changes.
 func CalculateDynamicGroups(ctx context.Context, db Queryer, oa *OrgAssets, contacts []*flows.Contact) error {
@@ -449,6 +449,23 @@ func TestGetContactIDsFromReferences(t *testing.T) {
 	assert.ElementsMatch(t, []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID}, ids)
 }
 
+func TestCountContactURNSchemes(t *testing.T) {
+	ctx, _, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Bob, "whatsapp:250788373373", 999)
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Bob, "tel:+250788373373", 10)
+
+	counts, err := models.CountContactURNSchemes(ctx, db, []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"tel": 2, "whatsapp": 1}, counts)
+
+	counts, err = models.CountContactURNSchemes(ctx, db, []models.ContactID{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{}, counts)
+}
+
 func TestStopContact(t *testing.T) {
 	ctx, _, db, _ := testsuite.Get()
 
@@ -2,12 +2,17 @@ package models
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Scene represents the context that events are occurring in
@@ -148,7 +153,7 @@ func ApplyEventPreCommitHooks(ctx context.Context, rt *runtime.Runtime, tx *sqlx
 
 	// now fire each of our hooks
 	for hook, args := range preHooks {
-		err := hook.Apply(ctx, rt, tx, oa, args)
+		err := applyHook(ctx, rt, tx, oa, hook, args, "pre")
 		if err != nil {
 			return errors.Wrapf(err, "error applying pre commit hook: %T", hook)
 		}
@@ -174,7 +179,7 @@ func ApplyEventPostCommitHooks(ctx context.Context, rt *runtime.Runtime, tx *sql
 
 	// now fire each of our hooks
 	for hook, args := range postHooks {
-		err := hook.Apply(ctx, rt, tx, oa, args)
+		err := applyHook(ctx, rt, tx, oa, hook, args, "post")
 		if err != nil {
 			return errors.Wrapf(err, "error applying post commit hook: %v", hook)
 		}
@@ -183,6 +188,18 @@ func ApplyEventPostCommitHooks(ctx context.Context, rt *runtime.Runtime, tx *sql
 	return nil
 }
 
+// applies a single commit hook within its own span
+func applyHook(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *OrgAssets, hook EventCommitHook, args map[*Scene][]interface{}, phase string) error {
+	ctx, span := tracing.Tracer().Start(ctx, fmt.Sprintf("%s commit hook %s", phase, strings.TrimPrefix(fmt.Sprintf("%T", hook), "*")),
+		trace.WithAttributes(attribute.Int("scenes", len(args)), attribute.Int64("org_id", int64(oa.OrgID()))),
+	)
+
+	err := hook.Apply(ctx, rt, tx, oa, args)
+
+	tracing.EndSpan(span, err)
+	return err
+}
+
 // HandleAndCommitEvents takes a set of contacts and events, handles the events and applies any hooks, and commits everything
 func HandleAndCommitEvents(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, contactEvents map[*flows.Contact][]flows.Event) error {
 	// create scenes for each contact
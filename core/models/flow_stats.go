@@ -102,3 +102,42 @@ func getNodeUIType(flow flows.Flow, node flows.Node, cache map[flows.NodeUUID]st
 	cache[node.UUID()] = value
 	return value
 }
+
+// RunStats are aggregate statistics for a set of flow runs, by status and by the categories of their results
+type RunStats struct {
+	Total       int                       `json:"total"`
+	Active      int                       `json:"active"`
+	Completed   int                       `json:"completed"`
+	Interrupted int                       `json:"interrupted"`
+	Expired     int                       `json:"expired"`
+	Failed      int                       `json:"failed"`
+	Results     map[string]map[string]int `json:"results"`
+}
+
+func newRunStats() *RunStats {
+	return &RunStats{Results: make(map[string]map[string]int)}
+}
+
+func (s *RunStats) addStatus(status RunStatus, count int) {
+	s.Total += count
+
+	switch status {
+	case RunStatusActive, RunStatusWaiting:
+		s.Active += count
+	case RunStatusCompleted:
+		s.Completed += count
+	case RunStatusInterrupted:
+		s.Interrupted += count
+	case RunStatusExpired:
+		s.Expired += count
+	case RunStatusFailed:
+		s.Failed += count
+	}
+}
+
+func (s *RunStats) addResult(key, category string, count int) {
+	if s.Results[key] == nil {
+		s.Results[key] = make(map[string]int)
+	}
+	s.Results[key][category] += count
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/phonenumbers"
+	"github.com/pkg/errors"
+)
+
+// NextLocalDelivery returns the next time at or after now when it will be the given time of day in the given timezone
+func NextLocalDelivery(deliverAt dates.TimeOfDay, tz *time.Location, now time.Time) time.Time {
+	local := now.In(tz)
+	deliverOn := deliverAt.Combine(dates.ExtractDate(local), tz)
+
+	// if that time has already passed today, then it's tomorrow
+	if deliverOn.Before(local) {
+		deliverOn = deliverAt.Combine(dates.ExtractDate(local.AddDate(0, 0, 1)), tz)
+	}
+	return deliverOn
+}
+
+const selectContactTimezoneSourcesSQL = `
+SELECT
+	c.id AS contact_id,
+	COALESCE(c.fields->$2->>'text', '') AS field_value,
+	COALESCE(u.path, '') AS tel
+FROM
+	contacts_contact c
+LEFT JOIN LATERAL (
+	SELECT path FROM contacts_contacturn WHERE contact_id = c.id AND scheme = 'tel' ORDER BY priority DESC, id LIMIT 1
+) u ON TRUE
+WHERE
+	c.id = ANY($1)
+`
+
+// LoadContactTimezones determines the local timezone of each of the given contacts. This is read from the contact field
+// with the given key if it's set and contains a valid timezone name, otherwise it's derived from the prefix of their
+// highest priority tel URN. Contacts whose timezone can't be determined get the org's timezone.
+func LoadContactTimezones(ctx context.Context, db Queryer, oa *OrgAssets, contactIDs []ContactID, fieldKey string) (map[ContactID]*time.Location, error) {
+	fieldUUID := ""
+	if fieldKey != "" {
+		field := oa.FieldByKey(fieldKey)
+		if field == nil {
+			return nil, errors.Errorf("no such contact field with key: %s", fieldKey)
+		}
+		fieldUUID = string(field.UUID())
+	}
+
+	rows, err := db.QueryxContext(ctx, selectContactTimezoneSourcesSQL, pq.Array(contactIDs), fieldUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contact timezones")
+	}
+	defer rows.Close()
+
+	timezones := make(map[ContactID]*time.Location, len(contactIDs))
+	locations := make(map[string]*time.Location)
+
+	// loading locations isn't free so we cache them by name
+	loadLocation := func(name string) *time.Location {
+		tz, seen := locations[name]
+		if !seen {
+			tz, _ = time.LoadLocation(name)
+			locations[name] = tz
+		}
+		return tz
+	}
+
+	for rows.Next() {
+		var contactID ContactID
+		var fieldValue, tel string
+		if err := rows.Scan(&contactID, &fieldValue, &tel); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact timezone")
+		}
+
+		var tz *time.Location
+		if fieldValue != "" {
+			tz = loadLocation(fieldValue)
+		}
+		if tz == nil && tel != "" {
+			if name := timezoneForTel(tel); name != "" {
+				tz = loadLocation(name)
+			}
+		}
+		if tz == nil {
+			tz = oa.Env().Timezone()
+		}
+
+		timezones[contactID] = tz
+	}
+
+	return timezones, errors.Wrap(rows.Err(), "error reading contact timezones")
+}
+
+// derives a timezone name from the prefix of the given phone number, returning empty string if it can't be determined
+func timezoneForTel(tel string) string {
+	number, err := phonenumbers.Parse(tel, "")
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return ""
+	}
+
+	// the lookup reads the longest prefix in its map from the number, so numbers need to be at least that long
+	if len(phonenumbers.Format(number, phonenumbers.E164)) < 9 {
+		return ""
+	}
+
+	tzs, err := phonenumbers.GetTimezonesForNumber(number)
+	if err != nil || len(tzs) == 0 || tzs[0] == phonenumbers.UNKNOWN_TIMEZONE {
+		return ""
+	}
+	return tzs[0]
+}
@@ -0,0 +1,95 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextLocalDelivery(t *testing.T) {
+	kgl, _ := time.LoadLocation("Africa/Kigali")
+	nine := dates.NewTimeOfDay(9, 0, 0, 0)
+
+	tcs := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		{time.Date(2021, 11, 10, 6, 0, 0, 0, time.UTC), time.Date(2021, 11, 10, 9, 0, 0, 0, kgl)},  // 08:00 local
+		{time.Date(2021, 11, 10, 7, 0, 0, 0, time.UTC), time.Date(2021, 11, 10, 9, 0, 0, 0, kgl)},  // 09:00 local
+		{time.Date(2021, 11, 10, 7, 1, 0, 0, time.UTC), time.Date(2021, 11, 11, 9, 0, 0, 0, kgl)},  // 09:01 local
+		{time.Date(2021, 11, 10, 23, 0, 0, 0, time.UTC), time.Date(2021, 11, 11, 9, 0, 0, 0, kgl)}, // 01:00 local next day
+		{time.Date(2021, 12, 31, 12, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 9, 0, 0, 0, kgl)},   // 14:00 local on NYE
+	}
+
+	for _, tc := range tcs {
+		actual := models.NextLocalDelivery(nine, kgl, tc.now)
+		assert.True(t, tc.expected.Equal(actual), "delivery mismatch for %s, expected %s, got %s", tc.now, tc.expected, actual)
+	}
+}
+
+func TestLoadContactTimezones(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// contact with a valid timezone in their gender field
+	contact1 := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID("a393abc0-283d-4c9b-a1b3-641a035c34bf"), "Ann", "eng", models.ContactStatusActive)
+	db.MustExec(`UPDATE contacts_contact SET fields = jsonb_build_object($2::text, jsonb_build_object('text', 'Africa/Kigali')) WHERE id = $1`, contact1.ID, testdata.GenderField.UUID)
+
+	// contact with an invalid timezone in their gender field but a Rwandan number
+	contact2 := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID("b393abc0-283d-4c9b-a1b3-641a035c34bf"), "Bea", "eng", models.ContactStatusActive)
+	db.MustExec(`UPDATE contacts_contact SET fields = jsonb_build_object($2::text, jsonb_build_object('text', 'Mars/Olympus')) WHERE id = $1`, contact2.ID, testdata.GenderField.UUID)
+	testdata.InsertContactURN(db, testdata.Org1, contact2, urns.URN("tel:+250788123123"), 1000)
+
+	// contact with an Ecuadorian number
+	contact3 := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID("c393abc0-283d-4c9b-a1b3-641a035c34bf"), "Cat", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(db, testdata.Org1, contact3, urns.URN("tel:+593979111111"), 1000)
+
+	// contact with no tel URN
+	contact4 := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID("d393abc0-283d-4c9b-a1b3-641a035c34bf"), "Dan", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(db, testdata.Org1, contact4, urns.URN("twitter:dan"), 1000)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	contactIDs := []models.ContactID{contact1.ID, contact2.ID, contact3.ID, contact4.ID}
+
+	timezones, err := models.LoadContactTimezones(ctx, db, oa, contactIDs, "gender")
+	require.NoError(t, err)
+	assert.Equal(t, map[models.ContactID]string{
+		contact1.ID: "Africa/Kigali",
+		contact2.ID: "Africa/Kigali",
+		contact3.ID: "America/Guayaquil",
+		contact4.ID: "America/Los_Angeles", // org timezone
+	}, timezoneNames(timezones))
+
+	// without a field, only URNs are used
+	timezones, err = models.LoadContactTimezones(ctx, db, oa, contactIDs, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[models.ContactID]string{
+		contact1.ID: "America/Los_Angeles",
+		contact2.ID: "Africa/Kigali",
+		contact3.ID: "America/Guayaquil",
+		contact4.ID: "America/Los_Angeles",
+	}, timezoneNames(timezones))
+
+	_, err = models.LoadContactTimezones(ctx, db, oa, contactIDs, "xxx")
+	assert.EqualError(t, err, "no such contact field with key: xxx")
+}
+
+func timezoneNames(timezones map[models.ContactID]*time.Location) map[models.ContactID]string {
+	names := make(map[models.ContactID]string, len(timezones))
+	for id, tz := range timezones {
+		names[id] = tz.String()
+	}
+	return names
+}
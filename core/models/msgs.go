@@ -186,6 +186,11 @@ func (m *Msg) ContactID() ContactID             { return m.m.ContactID }
 func (m *Msg) ContactURNID() *URNID             { return m.m.ContactURNID }
 func (m *Msg) IsResend() bool                   { return m.m.IsResend }
 
+// IsHeld returns whether this message is being held until its next attempt, e.g. because of quiet hours
+func (m *Msg) IsHeld() bool {
+	return m.m.Status == MsgStatusPending && m.m.NextAttempt != nil && m.m.NextAttempt.After(dates.Now())
+}
+
 func (m *Msg) SetTopup(topupID TopupID) { m.m.TopupID = topupID }
 
 func (m *Msg) SetLabels(labels []*assets.LabelReference) { m.labels = labels }
@@ -409,6 +414,16 @@ func newOutgoingMsg(rt *runtime.Runtime, org *Org, channel *Channel, contact *fl
 		}
 	}
 
+	// messages which would be sent during quiet hours are held as pending until the window ends
+	if m.Status == MsgStatusQueued {
+		isReply := session != nil && session.IncomingMsgID() != NilMsgID
+
+		if releaseOn := quietHoursReleaseOn(org, channel, contact, isReply); releaseOn != nil {
+			m.Status = MsgStatusPending
+			m.NextAttempt = releaseOn
+		}
+	}
+
 	// if we have attachments, add them
 	if len(out.Attachments()) > 0 {
 		for _, a := range out.Attachments() {
@@ -544,6 +559,49 @@ func GetMessagesForRetry(ctx context.Context, db Queryer) ([]*Msg, error) {
 	return loadMessages(ctx, db, loadMessagesForRetrySQL)
 }
 
+var loadHeldMessagesForReleaseSQL = `
+SELECT 
+	m.id,
+	m.broadcast_id,
+	m.uuid,
+	m.text,
+	m.created_on,
+	m.direction,
+	m.status,
+	m.visibility,
+	m.msg_count,
+	m.error_count,
+	m.next_attempt,
+	m.failed_reason,
+	m.high_priority,
+	m.external_id,
+	m.attachments,
+	m.metadata,
+	m.channel_id,
+	m.contact_id,
+	m.contact_urn_id,
+	m.org_id,
+	m.topup_id,
+	u.identity AS "urn_urn",
+	u.auth AS "urn_auth"
+FROM
+	msgs_msg m
+INNER JOIN 
+	contacts_contacturn u ON u.id = m.contact_urn_id
+WHERE
+	m.direction = 'O' AND
+	m.status = 'P' AND
+	m.next_attempt <= NOW()
+ORDER BY
+    m.next_attempt ASC, m.created_on ASC
+LIMIT $1`
+
+// GetHeldMessagesForRelease gets up to limit outgoing messages which were held (e.g. by quiet hours) and are now due
+// to be sent
+func GetHeldMessagesForRelease(ctx context.Context, db Queryer, limit int) ([]*Msg, error) {
+	return loadMessages(ctx, db, loadHeldMessagesForReleaseSQL, limit)
+}
+
 func loadMessages(ctx context.Context, db Queryer, sql string, params ...interface{}) ([]*Msg, error) {
 	rows, err := db.QueryxContext(ctx, sql, params...)
 	if err != nil {
@@ -714,6 +772,10 @@ type Broadcast struct {
 		CreatedByID   UserID                                  `json:"created_by_id,omitempty" db:"created_by_id"`
 		ParentID      BroadcastID                             `json:"parent_id,omitempty"     db:"parent_id"`
 		TicketID      TicketID                                `json:"ticket_id,omitempty"     db:"ticket_id"`
+		DeliverAt     string                                  `json:"deliver_at,omitempty"`
+		TimezoneField string                                  `json:"timezone_field,omitempty"`
+		Timezone      string                                  `json:"timezone,omitempty"`
+		Variants      []*Variant                              `json:"variants,omitempty"`
 	}
 }
 
@@ -727,6 +789,39 @@ func (b *Broadcast) BaseLanguage() envs.Language                           { ret
 func (b *Broadcast) Translations() map[envs.Language]*BroadcastTranslation { return b.b.Translations }
 func (b *Broadcast) TemplateState() TemplateState                          { return b.b.TemplateState }
 func (b *Broadcast) TicketID() TicketID                                    { return b.b.TicketID }
+func (b *Broadcast) DeliverAt() string                                     { return b.b.DeliverAt }
+func (b *Broadcast) TimezoneField() string                                 { return b.b.TimezoneField }
+func (b *Broadcast) Timezone() string                                      { return b.b.Timezone }
+func (b *Broadcast) Variants() []*Variant                                  { return b.b.Variants }
+
+// WithLocalDelivery sets this broadcast to be delivered at the given time of day (e.g. 09:00) in each contact's local
+// timezone, which is read from the contact field with the given key if there is one, or derived from their URN
+func (b *Broadcast) WithLocalDelivery(deliverAt string, timezoneField string) *Broadcast {
+	b.b.DeliverAt = deliverAt
+	b.b.TimezoneField = timezoneField
+	return b
+}
+
+// ForTimezone creates a copy of this broadcast which sends only to the given contacts and URNs in the given timezone
+func (b *Broadcast) ForTimezone(tz string, contactIDs []ContactID, urns []urns.URN) *Broadcast {
+	bcast := NewBroadcast(b.b.OrgID, b.b.BroadcastID, b.b.Translations, b.b.TemplateState, b.b.BaseLanguage, urns, contactIDs, nil, b.b.TicketID, b.b.CreatedByID)
+	bcast.b.Timezone = tz
+	bcast.b.Variants = b.b.Variants
+	return bcast
+}
+
+// WithVariants sets this broadcast to be split between the given variants
+func (b *Broadcast) WithVariants(variants []*Variant) *Broadcast {
+	b.b.Variants = variants
+	return b
+}
+
+// ForVariant creates a copy of this broadcast which sends the translations of the given variant
+func (b *Broadcast) ForVariant(v *Variant) *Broadcast {
+	bcast := NewBroadcast(b.b.OrgID, b.b.BroadcastID, v.Translations, b.b.TemplateState, b.b.BaseLanguage, nil, nil, nil, b.b.TicketID, b.b.CreatedByID)
+	bcast.b.Timezone = b.b.Timezone
+	return bcast
+}
 
 func (b *Broadcast) MarshalJSON() ([]byte, error)    { return json.Marshal(b.b) }
 func (b *Broadcast) UnmarshalJSON(data []byte) error { return json.Unmarshal(data, &b.b) }
@@ -914,6 +1009,7 @@ func (b *Broadcast) CreateBatch(contactIDs []ContactID) *BroadcastBatch {
 		OrgID:         b.b.OrgID,
 		CreatedByID:   b.b.CreatedByID,
 		TicketID:      b.b.TicketID,
+		Timezone:      b.b.Timezone,
 		ContactIDs:    contactIDs,
 	}
 }
@@ -930,6 +1026,7 @@ type BroadcastBatch struct {
 	OrgID         OrgID                                   `json:"org_id"`
 	CreatedByID   UserID                                  `json:"created_by_id"`
 	TicketID      TicketID                                `json:"ticket_id"`
+	Timezone      string                                  `json:"timezone,omitempty"`
 }
 
 func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets) ([]*Msg, error) {
@@ -1274,6 +1371,30 @@ func ResendMessages(ctx context.Context, db Queryer, rp *redis.Pool, oa *OrgAsse
 	return resent, nil
 }
 
+// BroadcastStatus is the type for the status of a broadcast
+type BroadcastStatus string
+
+// broadcast status constants
+const (
+	BroadcastStatusPending = BroadcastStatus("P")
+	BroadcastStatusQueued  = BroadcastStatus("Q")
+	BroadcastStatusSent    = BroadcastStatus("S")
+	BroadcastStatusFailed  = BroadcastStatus("F")
+)
+
+// GetBroadcastStatus gets the status of the given broadcast, returning an empty status if it doesn't exist
+func GetBroadcastStatus(ctx context.Context, db Queryer, orgID OrgID, id BroadcastID) (BroadcastStatus, error) {
+	var status BroadcastStatus
+	err := db.GetContext(ctx, &status, `SELECT status FROM msgs_broadcast WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error loading status of broadcast with id %d", id)
+	}
+	return status, nil
+}
+
 // MarkBroadcastSent marks the passed in broadcast as sent
 func MarkBroadcastSent(ctx context.Context, db Queryer, id BroadcastID) error {
 	// noop if it is a nil id
@@ -1281,13 +1402,23 @@ func MarkBroadcastSent(ctx context.Context, db Queryer, id BroadcastID) error {
 		return nil
 	}
 
-	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1`, id)
+	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1 AND status != 'F'`, id)
 	if err != nil {
 		return errors.Wrapf(err, "error setting broadcast with id %d as sent", id)
 	}
 	return nil
 }
 
+// MarkBroadcastCancelled marks the passed in broadcast as failed if it hasn't already been sent. Broadcasts use message
+// statuses so there is no cancelled status, and failed is the only other status that RapidPro treats as final.
+func MarkBroadcastCancelled(ctx context.Context, db Queryer, id BroadcastID) error {
+	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'F', modified_on = now() WHERE id = $1 AND status IN ('P', 'Q')`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error setting broadcast with id %d as cancelled", id)
+	}
+	return nil
+}
+
 // NilID implementations
 
 // MarshalJSON marshals into JSON. 0 values will become null
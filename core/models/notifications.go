@@ -58,6 +58,19 @@ func NotifyImportFinished(ctx context.Context, db Queryer, imp *ContactImport) e
 	return insertNotifications(ctx, db, []*Notification{n})
 }
 
+// NotifyExportFinished notifies the user who requested an export that it has finished. Exports which don't have a record
+// in the database are identified by their UUID as the scope, which is the longest scope that will fit.
+func NotifyExportFinished(ctx context.Context, db Queryer, orgID OrgID, userID UserID, exportUUID string) error {
+	n := &Notification{
+		OrgID:  orgID,
+		Type:   NotificationTypeExportFinished,
+		Scope:  exportUUID,
+		UserID: userID,
+	}
+
+	return insertNotifications(ctx, db, []*Notification{n})
+}
+
 // NotifyIncidentStarted notifies administrators that an incident has started
 func NotifyIncidentStarted(ctx context.Context, db Queryer, oa *OrgAssets, incident *Incident) error {
 	admins := usersWithRoles(oa, []UserRole{UserRoleAdministrator})
@@ -108,6 +121,15 @@ func NotificationsFromTicketEvents(ctx context.Context, db Queryer, oa *OrgAsset
 			if ticket.AssigneeID() != NilUserID && ticket.AssigneeID() != evt.CreatedByID() {
 				notifyTicketsActivity[ticket.AssigneeID()] = true
 			}
+		case TicketEventTypeSLABreached:
+			// notify ticket assignee or if ticket is unassigned, all possible assignees
+			if ticket.AssigneeID() != NilUserID {
+				notifyTicketsActivity[ticket.AssigneeID()] = true
+			} else {
+				for _, user := range assignableUsers {
+					notifyTicketsActivity[user.ID()] = true
+				}
+			}
 		}
 	}
 
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const configQuietHours = "quiet_hours"
+
+// QuietHours is a window of local time during which outgoing messages are held rather than sent. Policies can be set in
+// the org config and in channel config, where the channel policy takes precedence. Windows can span midnight, e.g.
+//
+//   "quiet_hours": {
+//     "start": "21:00",
+//     "end": "08:00",
+//     "exempt_replies": true
+//   }
+//
+type QuietHours struct {
+	Start         dates.TimeOfDay
+	End           dates.TimeOfDay
+	ExemptReplies bool
+}
+
+// ReadQuietHours reads a quiet hours policy from the given config value, returning nil if there is no policy
+func ReadQuietHours(raw interface{}) (*QuietHours, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	config, isMap := raw.(map[string]interface{})
+	if !isMap {
+		return nil, errors.New("quiet hours config must be an object")
+	}
+
+	start, err := parseQuietHoursTime(config, "start")
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseQuietHoursTime(config, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	exemptReplies, _ := config["exempt_replies"].(bool)
+
+	return &QuietHours{Start: start, End: end, ExemptReplies: exemptReplies}, nil
+}
+
+func parseQuietHoursTime(config map[string]interface{}, key string) (dates.TimeOfDay, error) {
+	value, _ := config[key].(string)
+	if value == "" {
+		return dates.ZeroTimeOfDay, errors.Errorf("quiet hours config missing %s", key)
+	}
+
+	tod, err := dates.ParseTimeOfDay("tt:mm", value)
+	if err != nil {
+		return dates.ZeroTimeOfDay, errors.Wrapf(err, "invalid quiet hours %s '%s'", key, value)
+	}
+	return tod, nil
+}
+
+// ReleaseOn returns when messages held at the given time should be released, in the given timezone, or nil if the
+// given time isn't within the window
+func (q *QuietHours) ReleaseOn(now time.Time, tz *time.Location) *time.Time {
+	// a window that starts when it ends is empty
+	if q.Start.Equal(q.End) {
+		return nil
+	}
+
+	local := now.In(tz)
+	tod := dates.ExtractTimeOfDay(local)
+	today := dates.ExtractDate(local)
+	var releaseOn time.Time
+
+	if q.Start.Compare(q.End) < 0 {
+		// window is within a single day, e.g. 12:00 - 14:00
+		if tod.Compare(q.Start) < 0 || tod.Compare(q.End) >= 0 {
+			return nil
+		}
+		releaseOn = q.End.Combine(today, tz)
+	} else {
+		// window spans midnight, e.g. 21:00 - 08:00
+		if tod.Compare(q.Start) >= 0 {
+			releaseOn = q.End.Combine(dates.ExtractDate(local.AddDate(0, 0, 1)), tz)
+		} else if tod.Compare(q.End) < 0 {
+			releaseOn = q.End.Combine(today, tz)
+		} else {
+			return nil
+		}
+	}
+
+	return &releaseOn
+}
+
+// QuietHours returns the quiet hours policy for this org, if it has one
+func (o *Org) QuietHours() (*QuietHours, error) {
+	return ReadQuietHours(o.o.Config.Get(configQuietHours, nil))
+}
+
+// QuietHours returns the quiet hours policy for this channel, if it has one
+func (c *Channel) QuietHours() (*QuietHours, error) {
+	return ReadQuietHours(c.c.Config[configQuietHours])
+}
+
+// determines whether a message being created now should be held by quiet hours, and if so, when it should be released
+func quietHoursReleaseOn(org *Org, channel *Channel, contact *flows.Contact, isReply bool) *time.Time {
+	policy, err := channel.QuietHours()
+	if err == nil && policy == nil {
+		policy, err = org.QuietHours()
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("org_id", org.ID()).WithField("channel_uuid", channel.UUID()).Error("invalid quiet hours config, ignoring")
+		return nil
+	}
+	if policy == nil || (isReply && policy.ExemptReplies) {
+		return nil
+	}
+
+	// window is in the contact's timezone if they have one, otherwise the org's
+	tz := org.Timezone()
+	if contact.Timezone() != nil {
+		tz = contact.Timezone()
+	}
+
+	return policy.ReleaseOn(dates.Now(), tz)
+}
@@ -0,0 +1,136 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/null"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadQuietHours(t *testing.T) {
+	policy, err := models.ReadQuietHours(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	policy, err = models.ReadQuietHours(map[string]interface{}{"start": "21:00", "end": "08:30", "exempt_replies": true})
+	assert.NoError(t, err)
+	assert.Equal(t, &models.QuietHours{Start: dates.NewTimeOfDay(21, 0, 0, 0), End: dates.NewTimeOfDay(8, 30, 0, 0), ExemptReplies: true}, policy)
+
+	_, err = models.ReadQuietHours("21:00-08:00")
+	assert.EqualError(t, err, "quiet hours config must be an object")
+
+	_, err = models.ReadQuietHours(map[string]interface{}{"start": "21:00"})
+	assert.EqualError(t, err, "quiet hours config missing end")
+
+	_, err = models.ReadQuietHours(map[string]interface{}{"start": "21:00", "end": "xx"})
+	assert.EqualError(t, err, "invalid quiet hours end 'xx': cannot parse 'xx' as 't'")
+}
+
+func TestQuietHoursReleaseOn(t *testing.T) {
+	kgl, _ := time.LoadLocation("Africa/Kigali")
+	overnight := &models.QuietHours{Start: dates.NewTimeOfDay(21, 0, 0, 0), End: dates.NewTimeOfDay(8, 0, 0, 0)}
+	lunch := &models.QuietHours{Start: dates.NewTimeOfDay(12, 0, 0, 0), End: dates.NewTimeOfDay(14, 0, 0, 0)}
+	empty := &models.QuietHours{Start: dates.NewTimeOfDay(9, 0, 0, 0), End: dates.NewTimeOfDay(9, 0, 0, 0)}
+
+	tcs := []struct {
+		policy   *models.QuietHours
+		now      time.Time
+		expected *time.Time
+	}{
+		{overnight, time.Date(2021, 11, 10, 18, 0, 0, 0, time.UTC), nil},                                                // 20:00 local
+		{overnight, time.Date(2021, 11, 10, 19, 0, 0, 0, time.UTC), timeRef(time.Date(2021, 11, 11, 8, 0, 0, 0, kgl))},  // 21:00 local
+		{overnight, time.Date(2021, 11, 10, 23, 30, 0, 0, time.UTC), timeRef(time.Date(2021, 11, 11, 8, 0, 0, 0, kgl))}, // 01:30 local
+		{overnight, time.Date(2021, 11, 11, 5, 59, 0, 0, time.UTC), timeRef(time.Date(2021, 11, 11, 8, 0, 0, 0, kgl))},  // 07:59 local
+		{overnight, time.Date(2021, 11, 11, 6, 0, 0, 0, time.UTC), nil},                                                 // 08:00 local
+		{overnight, time.Date(2021, 12, 31, 20, 0, 0, 0, time.UTC), timeRef(time.Date(2022, 1, 1, 8, 0, 0, 0, kgl))},    // 22:00 local on NYE
+		{lunch, time.Date(2021, 11, 10, 9, 59, 0, 0, time.UTC), nil},                                                    // 11:59 local
+		{lunch, time.Date(2021, 11, 10, 10, 0, 0, 0, time.UTC), timeRef(time.Date(2021, 11, 10, 14, 0, 0, 0, kgl))},     // 12:00 local
+		{lunch, time.Date(2021, 11, 10, 12, 0, 0, 0, time.UTC), nil},                                                    // 14:00 local
+		{empty, time.Date(2021, 11, 10, 7, 0, 0, 0, time.UTC), nil},                                                     // 09:00 local
+	}
+
+	for _, tc := range tcs {
+		actual := tc.policy.ReleaseOn(tc.now, kgl)
+		if tc.expected == nil {
+			assert.Nil(t, actual, "expected no release time for %s", tc.now)
+		} else if assert.NotNil(t, actual, "expected release time for %s", tc.now) {
+			assert.True(t, tc.expected.Equal(*actual), "release time mismatch for %s, expected %s, got %s", tc.now, tc.expected, actual)
+		}
+	}
+}
+
+func TestQuietHoursOutgoingMsgs(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	// org is in America/Los_Angeles, so this is 23:30 local time
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2021, 11, 11, 7, 30, 0, 0, time.UTC)))
+	la, _ := time.LoadLocation("America/Los_Angeles")
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"quiet_hours": {"start": "21:00", "end": "08:00", "exempt_replies": true}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg|models.RefreshChannels)
+	require.NoError(t, err)
+
+	flow, _ := oa.FlowByID(testdata.Favorites.ID)
+	session := insertTestSession(t, ctx, rt, testdata.Org1, testdata.Cathy, testdata.Favorites)
+
+	newOutgoing := func(oa *models.OrgAssets, session *models.Session) *models.Msg {
+		channel := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+		flowMsg := flows.NewMsgOut(urns.URN(fmt.Sprintf("tel:+250700000001?id=%d", testdata.Cathy.URNID)), assets.NewChannelReference(testdata.TwilioChannel.UUID, "Twilio"), "Hi there", nil, nil, nil, flows.NilMsgTopic)
+		msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), channel, session, flow, flowMsg, dates.Now())
+		require.NoError(t, err)
+		return msg
+	}
+
+	// message is held until the quiet hours end
+	msg := newOutgoing(oa, session)
+	assert.Equal(t, models.MsgStatusPending, msg.Status())
+	assert.True(t, time.Date(2021, 11, 11, 8, 0, 0, 0, la).Equal(*msg.NextAttempt()))
+	assert.True(t, msg.IsHeld())
+
+	// unless it's a reply to an incoming message
+	session.SetIncomingMsg(flows.MsgID(123425), null.NullString)
+
+	msg = newOutgoing(oa, session)
+	assert.Equal(t, models.MsgStatusQueued, msg.Status())
+	assert.Nil(t, msg.NextAttempt())
+	assert.False(t, msg.IsHeld())
+
+	// channels can have their own policy which overrides the org's, e.g. to disable quiet hours
+	db.MustExec(`UPDATE channels_channel SET config = '{"quiet_hours": {"start": "00:00", "end": "00:00"}}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	session = insertTestSession(t, ctx, rt, testdata.Org1, testdata.Cathy, testdata.Favorites)
+
+	msg = newOutgoing(oa, session)
+	assert.Equal(t, models.MsgStatusQueued, msg.Status())
+
+	// invalid policies are ignored
+	db.MustExec(`UPDATE channels_channel SET config = '{"quiet_hours": {"start": "21:00"}}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	msg = newOutgoing(oa, session)
+	assert.Equal(t, models.MsgStatusQueued, msg.Status())
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
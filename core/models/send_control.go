@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	broadcastControlKey = "send_control:broadcast:%d"
+	startControlKey     = "send_control:start:%d"
+	pausedBatchesKey    = "%s:paused"
+
+	// the flag of a cancelled send only needs to outlive any of its batches which are still queued
+	cancelledSendExpiry = 7 * 24 * time.Hour
+)
+
+// SendControl is a flag which can be set on a broadcast or flow start to stop its remaining batches from being handled
+type SendControl string
+
+// send control constants
+const (
+	SendControlNone   = SendControl("")
+	SendControlPause  = SendControl("pause")
+	SendControlCancel = SendControl("cancel")
+)
+
+// BroadcastControlKey returns the redis key used to pause or cancel the given broadcast
+func BroadcastControlKey(id BroadcastID) string { return fmt.Sprintf(broadcastControlKey, id) }
+
+// StartControlKey returns the redis key used to pause or cancel the given flow start
+func StartControlKey(id StartID) string { return fmt.Sprintf(startControlKey, id) }
+
+// SetSendControl pauses or cancels the send with the given control key. A paused send stays paused until it's resumed
+// or cancelled, and cancelling a send discards any batches which were parked while it was paused.
+func SetSendControl(rc redis.Conn, key string, control SendControl) error {
+	var err error
+	switch control {
+	case SendControlPause:
+		_, err = rc.Do("SET", key, string(control))
+	case SendControlCancel:
+		rc.Send("MULTI")
+		rc.Send("SET", key, string(control), "EX", int(cancelledSendExpiry/time.Second))
+		rc.Send("DEL", fmt.Sprintf(pausedBatchesKey, key))
+		_, err = rc.Do("EXEC")
+	default:
+		return errors.Errorf("invalid send control: %s", control)
+	}
+	return errors.Wrap(err, "error setting send control")
+}
+
+// GetSendControl gets the control flag with the given key
+func GetSendControl(rc redis.Conn, key string) (SendControl, error) {
+	control, err := redis.String(rc.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		return SendControlNone, errors.Wrap(err, "error reading send control")
+	}
+	return SendControl(control), nil
+}
+
+var parkPausedBatch = redis.NewScript(2, `
+-- KEYS: [ControlKey, PausedKey] ARGV: [Batch]
+local control = redis.call("GET", KEYS[1])
+if control == "pause" then
+  redis.call("SADD", KEYS[2], ARGV[1])
+end
+return control or ""
+`)
+
+// ParkPausedBatch gets the control flag with the given key and if the send is paused, parks the given batch so that it
+// can be re-queued when the send is resumed. This is atomic so a batch can't be parked after its send is resumed.
+func ParkPausedBatch(rc redis.Conn, key string, batch []byte) (SendControl, error) {
+	control, err := redis.String(parkPausedBatch.Do(rc, key, fmt.Sprintf(pausedBatchesKey, key), batch))
+	if err != nil {
+		return SendControlNone, errors.Wrap(err, "error checking send control")
+	}
+	return SendControl(control), nil
+}
+
+var resumeSend = redis.NewScript(2, `
+-- KEYS: [ControlKey, PausedKey]
+if redis.call("GET", KEYS[1]) ~= "pause" then
+  return {}
+end
+local batches = redis.call("SMEMBERS", KEYS[2])
+redis.call("DEL", KEYS[1], KEYS[2])
+return batches
+`)
+
+// ResumeSend clears the pause of the send with the given control key, returning the batches which were parked while it
+// was paused so that they can be re-queued. Sends which aren't paused are left as they are.
+func ResumeSend(rc redis.Conn, key string) ([][]byte, error) {
+	batches, err := redis.ByteSlices(resumeSend.Do(rc, key, fmt.Sprintf(pausedBatchesKey, key)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error resuming send")
+	}
+	return batches, nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/pkg/errors"
+)
+
+const (
+	broadcastProgressKey = "send_progress:broadcast:%d"
+	startProgressKey     = "send_progress:start:%d"
+	sendProgressExpiry   = 7 * 24 * time.Hour
+)
+
+// SendProgress is the progress of a broadcast or flow start as its batches are queued and handled. Because batches
+// can be throttled to respect channel send rates, this lets callers see how far along a large send is and when its
+// last queued batch will run.
+type SendProgress struct {
+	Queued           int                         `json:"queued"`
+	Handled          int                         `json:"handled"`
+	ThrottledUntil   *time.Time                  `json:"throttled_until,omitempty"`
+	PendingTimezones map[string]*PendingTimezone `json:"pending_timezones,omitempty"`
+}
+
+// PendingTimezone is the part of a broadcast being delivered at a local time which is yet to be delivered in a
+// particular timezone
+type PendingTimezone struct {
+	Contacts  int       `json:"contacts"`
+	DeliverOn time.Time `json:"deliver_on"`
+}
+
+// BroadcastProgressKey returns the redis key used to track the progress of the given broadcast
+func BroadcastProgressKey(id BroadcastID) string { return fmt.Sprintf(broadcastProgressKey, id) }
+
+// StartProgressKey returns the redis key used to track the progress of the given flow start
+func StartProgressKey(id StartID) string { return fmt.Sprintf(startProgressKey, id) }
+
+// RecordBatchQueued records that a batch of the given number of contacts has been queued to run at the given time
+func RecordBatchQueued(rc redis.Conn, key string, count int, runAt time.Time) error {
+	rc.Send("MULTI")
+	rc.Send("HINCRBY", key, "queued", count)
+	rc.Send("HSET", key, "run_at", runAt.UnixNano())
+	rc.Send("EXPIRE", key, int(sendProgressExpiry/time.Second))
+	_, err := rc.Do("EXEC")
+	return errors.Wrap(err, "error recording queued batch")
+}
+
+// RecordBatchHandled records that a batch of the given number of contacts has been handled
+func RecordBatchHandled(rc redis.Conn, key string, count int) error {
+	_, err := rc.Do("HINCRBY", key, "handled", count)
+	return errors.Wrap(err, "error recording handled batch")
+}
+
+// RecordTimezoneScheduled records that the given number of contacts in the given timezone are scheduled to be sent to
+func RecordTimezoneScheduled(rc redis.Conn, key string, tz string, contacts int, deliverOn time.Time) error {
+	encoded, err := json.Marshal(&PendingTimezone{Contacts: contacts, DeliverOn: deliverOn})
+	if err != nil {
+		return err
+	}
+
+	rc.Send("MULTI")
+	rc.Send("HSET", key+":timezones", tz, encoded)
+	rc.Send("EXPIRE", key+":timezones", int(sendProgressExpiry/time.Second))
+	_, err = rc.Do("EXEC")
+	return errors.Wrap(err, "error recording scheduled timezone")
+}
+
+// RecordTimezoneDelivered records that the contacts in the given timezone have been sent to, returning the number of
+// timezones which are still pending
+func RecordTimezoneDelivered(rc redis.Conn, key string, tz string) (int, error) {
+	rc.Send("MULTI")
+	rc.Send("HDEL", key+":timezones", tz)
+	rc.Send("HLEN", key+":timezones")
+	replies, err := redis.Ints(rc.Do("EXEC"))
+	if err != nil {
+		return 0, errors.Wrap(err, "error recording delivered timezone")
+	}
+	return replies[1], nil
+}
+
+// GetSendProgress gets the progress of the broadcast or flow start with the given key, or nil if it isn't being tracked
+func GetSendProgress(rc redis.Conn, key string) (*SendProgress, error) {
+	values, err := redis.Int64Map(rc.Do("HGETALL", key))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading send progress")
+	}
+	timezones, err := redis.StringMap(rc.Do("HGETALL", key+":timezones"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading send progress timezones")
+	}
+	if len(values) == 0 && len(timezones) == 0 {
+		return nil, nil
+	}
+
+	progress := &SendProgress{Queued: int(values["queued"]), Handled: int(values["handled"])}
+
+	if len(timezones) > 0 {
+		progress.PendingTimezones = make(map[string]*PendingTimezone, len(timezones))
+		for tz, encoded := range timezones {
+			pending := &PendingTimezone{}
+			if err := json.Unmarshal([]byte(encoded), pending); err != nil {
+				return nil, errors.Wrap(err, "error unmarshalling pending timezone")
+			}
+			progress.PendingTimezones[tz] = pending
+		}
+	}
+
+	// if the last queued batch doesn't run until later, then we're being throttled
+	if runAt := time.Unix(0, values["run_at"]).UTC(); values["run_at"] > 0 && runAt.After(dates.Now()) {
+		progress.ThrottledUntil = &runAt
+	}
+
+	return progress, nil
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/mailroom/core/goflow"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/compress"
 	"github.com/nyaruka/null"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -126,10 +127,15 @@ func (s *Session) StoragePath(cfg *runtime.Config) string {
 		"c",
 		string(s.ContactUUID()[:4]),
 		string(s.ContactUUID()),
-		fmt.Sprintf("%s_session_%s_%s.json", ts, s.UUID(), s.OutputMD5()),
+		fmt.Sprintf("%s_session_%s_%s.json%s", ts, s.UUID(), s.OutputMD5(), compress.Type(cfg.SessionStorageCompression).Extension()),
 	)
 }
 
+// whether session output should be written to session storage rather than the database
+func outputToStorage(cfg *runtime.Config) bool {
+	return cfg.SessionStorage == "s3" || cfg.SessionStorage == "fs"
+}
+
 // ContactUUID returns the UUID of our contact
 func (s *Session) ContactUUID() flows.ContactUUID {
 	return s.contact.UUID()
@@ -371,11 +377,11 @@ func (s *Session) Update(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx,
 	// the SQL statement we'll use to update this session
 	updateSQL := sqlUpdateSession
 
-	// if writing to S3, do so
-	if rt.Config.SessionStorage == "s3" {
+	// if writing to storage, do so
+	if outputToStorage(rt.Config) {
 		err := WriteSessionOutputsToStorage(ctx, rt, []*Session{s})
 		if err != nil {
-			logrus.WithError(err).Error("error writing session to s3")
+			logrus.WithError(err).Error("error writing session to storage")
 		}
 
 		// don't write output in our SQL
@@ -644,12 +650,12 @@ func InsertSessions(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *O
 	insertCompleteSQL := sqlInsertCompleteSession
 	insertIncompleteSQL := sqlInsertIncompleteSession
 
-	// if writing our sessions to S3, do so
-	if rt.Config.SessionStorage == "s3" {
+	// if writing our sessions to storage, do so
+	if outputToStorage(rt.Config) {
 		err := WriteSessionOutputsToStorage(ctx, rt, sessions)
 		if err != nil {
 			// for now, continue on for errors, we are still reading from the DB
-			logrus.WithError(err).Error("error writing sessions to s3")
+			logrus.WithError(err).Error("error writing sessions to storage")
 		}
 
 		insertCompleteSQL = sqlInsertCompleteSessionNoOutput
@@ -793,6 +799,12 @@ func FindWaitingSessionForContact(ctx context.Context, db *sqlx.DB, st storage.S
 			return nil, errors.Wrapf(err, "error reading session from storage: %s", session.OutputURL())
 		}
 
+		// output may have been compressed when written
+		output, err = compress.Decompress(output)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decompressing session from storage: %s", session.OutputURL())
+		}
+
 		logrus.WithField("elapsed", time.Since(start)).WithField("output_url", session.OutputURL()).Debug("loaded session from storage")
 		session.s.Output = null.String(output)
 	}
@@ -800,17 +812,23 @@ func FindWaitingSessionForContact(ctx context.Context, db *sqlx.DB, st storage.S
 	return session, nil
 }
 
-// WriteSessionsToStorage writes the outputs of the passed in sessions to our storage (S3), updating the
-// output_url for each on success. Failure of any will cause all to fail.
+// WriteSessionOutputsToStorage writes the outputs of the passed in sessions to our session storage, compressing them if
+// configured to do so, and updating the output_url for each on success. Failure of any will cause all to fail.
 func WriteSessionOutputsToStorage(ctx context.Context, rt *runtime.Runtime, sessions []*Session) error {
 	start := time.Now()
+	compression := compress.Type(rt.Config.SessionStorageCompression)
 
 	uploads := make([]*storage.Upload, len(sessions))
 	for i, s := range sessions {
+		body, err := compress.Compress(compression, []byte(s.Output()))
+		if err != nil {
+			return errors.Wrapf(err, "error compressing session output")
+		}
+
 		uploads[i] = &storage.Upload{
 			Path:        s.StoragePath(rt.Config),
-			Body:        []byte(s.Output()),
-			ContentType: "application/json",
+			Body:        body,
+			ContentType: compression.ContentType("application/json"),
 			ACL:         s3.ObjectCannedACLPrivate,
 		}
 	}
@@ -821,10 +839,15 @@ func WriteSessionOutputsToStorage(ctx context.Context, rt *runtime.Runtime, sess
 	}
 
 	for i, s := range sessions {
-		s.s.OutputURL = null.String(uploads[i].URL)
+		// file system storage returns paths which include its base directory, so record the path within storage instead
+		if rt.Config.SessionStorage == "fs" {
+			s.s.OutputURL = null.String(uploads[i].Path)
+		} else {
+			s.s.OutputURL = null.String(uploads[i].URL)
+		}
 	}
 
-	logrus.WithField("elapsed", time.Since(start)).WithField("count", len(sessions)).Debug("wrote sessions to s3")
+	logrus.WithField("elapsed", time.Since(start)).WithField("count", len(sessions)).Debug("wrote sessions to storage")
 	return nil
 }
 
@@ -186,6 +186,56 @@ func TestSingleSprintSession(t *testing.T) {
 		Columns(map[string]interface{}{"status": "C", "session_type": "M", "current_flow_id": nil, "responded": false})
 }
 
+func TestSessionOutputStorage(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
+	defer func() {
+		rt.Config.SessionStorage = "db"
+		rt.Config.SessionStorageCompression = "none"
+	}()
+
+	assetsJSON, err := os.ReadFile("testdata/session_test_flows.json")
+	require.NoError(t, err)
+
+	flowJSON, _, _, err := jsonparser.Get(assetsJSON, "flows", "[0]")
+	require.NoError(t, err)
+	testdata.InsertFlow(db, testdata.Org1, flowJSON)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshFlows)
+	require.NoError(t, err)
+
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		rt.Config.SessionStorage = "fs"
+		rt.Config.SessionStorageCompression = compression
+
+		modelContact, flowContact := testdata.Bob.Load(db, oa)
+
+		flowSession, sprint1 := test.NewSessionBuilder().WithAssets(assetsJSON).WithFlow("c49daa28-cf70-407a-a767-a4c1360f4b01").
+			WithContact(testdata.Bob.UUID, flows.ContactID(testdata.Bob.ID), "Bob", "eng", "").MustBuild()
+
+		tx := db.MustBegin()
+
+		modelSessions, err := models.InsertSessions(ctx, rt, tx, oa, []flows.Session{flowSession}, []flows.Sprint{sprint1}, []*models.Contact{modelContact}, nil)
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		session := modelSessions[0]
+
+		// output should have been written to storage rather than the db
+		assert.Equal(t, session.StoragePath(rt.Config), session.OutputURL(), "output URL mismatch for %s", compression)
+		assertdb.Query(t, db, `SELECT count(*) FROM flows_flowsession WHERE id = $1 AND output IS NULL AND output_url IS NOT NULL`, session.ID()).Returns(1)
+
+		// and should be read back transparently regardless of compression
+		waiting, err := models.FindWaitingSessionForContact(ctx, db, rt.SessionStorage, oa, models.FlowTypeMessaging, flowContact)
+		require.NoError(t, err)
+		assert.Equal(t, session.ID(), waiting.ID())
+		assert.JSONEq(t, session.Output(), waiting.Output(), "output mismatch for %s", compression)
+
+		require.NoError(t, models.ExitSessions(ctx, db, []models.SessionID{session.ID()}, models.SessionStatusInterrupted))
+	}
+}
+
 func TestSessionWithSubflows(t *testing.T) {
 	ctx, rt, db, _ := testsuite.Get()
 
@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 
@@ -36,15 +37,29 @@ type StartStatus string
 
 // start status constants
 const (
-	StartStatusPending  = StartStatus("P")
-	StartStatusStarting = StartStatus("S")
-	StartStatusComplete = StartStatus("C")
-	StartStatusFailed   = StartStatus("F")
+	StartStatusPending     = StartStatus("P")
+	StartStatusStarting    = StartStatus("S")
+	StartStatusComplete    = StartStatus("C")
+	StartStatusFailed      = StartStatus("F")
+	StartStatusInterrupted = StartStatus("I")
 )
 
+// GetStartStatus gets the status of the given flow start, returning an empty status if it doesn't exist
+func GetStartStatus(ctx context.Context, db Queryer, orgID OrgID, startID StartID) (StartStatus, error) {
+	var status StartStatus
+	err := db.GetContext(ctx, &status, `SELECT status FROM flows_flowstart WHERE org_id = $1 AND id = $2`, orgID, startID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error loading status of start with id %d", startID)
+	}
+	return status, nil
+}
+
 // MarkStartComplete sets the status for the passed in flow start
 func MarkStartComplete(ctx context.Context, db Queryer, startID StartID) error {
-	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'C', modified_on = NOW() WHERE id = $1", startID)
+	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'C', modified_on = NOW() WHERE id = $1 AND status != 'I'", startID)
 	if err != nil {
 		return errors.Wrapf(err, "error setting start as complete")
 	}
@@ -53,7 +68,7 @@ func MarkStartComplete(ctx context.Context, db Queryer, startID StartID) error {
 
 // MarkStartStarted sets the status for the passed in flow start to S and updates the contact count on it
 func MarkStartStarted(ctx context.Context, db Queryer, startID StartID, contactCount int, createdContactIDs []ContactID) error {
-	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'S', contact_count = $2, modified_on = NOW() WHERE id = $1", startID, contactCount)
+	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'S', contact_count = $2, modified_on = NOW() WHERE id = $1 AND status != 'I'", startID, contactCount)
 	if err != nil {
 		return errors.Wrapf(err, "error setting start as started")
 	}
@@ -87,6 +102,15 @@ func MarkStartFailed(ctx context.Context, db Queryer, startID StartID) error {
 	return nil
 }
 
+// MarkStartInterrupted sets the status for the passed in flow start to I if it hasn't already completed or failed
+func MarkStartInterrupted(ctx context.Context, db Queryer, startID StartID) error {
+	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'I', modified_on = NOW() WHERE id = $1 AND status IN ('P', 'S')", startID)
+	if err != nil {
+		return errors.Wrapf(err, "error setting start as interrupted")
+	}
+	return nil
+}
+
 // FlowStartBatch represents a single flow batch that needs to be started
 type FlowStartBatch struct {
 	b struct {
@@ -154,6 +178,8 @@ type FlowStart struct {
 		Extra          null.JSON `json:"extra,omitempty"           db:"extra"`
 		ParentSummary  null.JSON `json:"parent_summary,omitempty"  db:"parent_summary"`
 		SessionHistory null.JSON `json:"session_history,omitempty" db:"session_history"`
+
+		Variants []*Variant `json:"variants,omitempty"`
 	}
 }
 
@@ -229,6 +255,20 @@ func (s *FlowStart) WithExtra(extra json.RawMessage) *FlowStart {
 	return s
 }
 
+func (s *FlowStart) Variants() []*Variant { return s.s.Variants }
+func (s *FlowStart) WithVariants(variants []*Variant) *FlowStart {
+	s.s.Variants = variants
+	return s
+}
+
+// ForVariant creates a copy of this start which starts contacts in the flow of the given variant
+func (s *FlowStart) ForVariant(v *Variant) *FlowStart {
+	start := &FlowStart{s: s.s}
+	start.s.FlowID = v.FlowID
+	start.s.Variants = nil
+	return start
+}
+
 func (s *FlowStart) MarshalJSON() ([]byte, error)    { return json.Marshal(s.s) }
 func (s *FlowStart) UnmarshalJSON(data []byte) error { return json.Unmarshal(data, &s.s) }
 
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// TicketAssignmentStrategy is how tickets without an assignee are automatically assigned
+type TicketAssignmentStrategy string
+
+const (
+	TicketAssignmentNone         TicketAssignmentStrategy = ""
+	TicketAssignmentRoundRobin   TicketAssignmentStrategy = "round_robin"
+	TicketAssignmentLoadBalanced TicketAssignmentStrategy = "load_balanced"
+
+	configTicketAssignment = "ticket_assignment"
+
+	ticketAssignmentCursorKey      = "ticket_assignment:%d:%d:cursor"
+	ticketAssignmentUnavailableKey = "ticket_assignment:%d:unavailable"
+)
+
+// TicketAssignment returns the strategy used by this org to automatically assign tickets
+func (o *Org) TicketAssignment() TicketAssignmentStrategy {
+	return TicketAssignmentStrategy(o.ConfigValue(configTicketAssignment, string(TicketAssignmentNone)))
+}
+
+// SetUserAvailable marks the given user as available or unavailable for automatic ticket assignment
+func SetUserAvailable(rc redis.Conn, orgID OrgID, userID UserID, available bool) error {
+	key := fmt.Sprintf(ticketAssignmentUnavailableKey, orgID)
+	var err error
+	if available {
+		_, err = rc.Do("SREM", key, int64(userID))
+	} else {
+		_, err = rc.Do("SADD", key, int64(userID))
+	}
+	return err
+}
+
+// GetUnavailableUsers gets the ids of the users in the given org who are unavailable for automatic ticket assignment
+func GetUnavailableUsers(rc redis.Conn, orgID OrgID) (map[UserID]bool, error) {
+	ids, err := redis.Ints(rc.Do("SMEMBERS", fmt.Sprintf(ticketAssignmentUnavailableKey, orgID)))
+	if err != nil {
+		return nil, err
+	}
+
+	unavailable := make(map[UserID]bool, len(ids))
+	for _, id := range ids {
+		unavailable[UserID(id)] = true
+	}
+	return unavailable, nil
+}
+
+// TicketsAutoAssign assigns any of the given tickets which don't have an assignee to a user from the teams of each
+// ticket's topic, according to the org's assignment strategy. Users who are marked as unavailable are skipped.
+func TicketsAutoAssign(ctx context.Context, rt *runtime.Runtime, db Queryer, oa *OrgAssets, tickets []*Ticket) (map[*Ticket]*TicketEvent, error) {
+	strategy := oa.Org().TicketAssignment()
+	if strategy == TicketAssignmentNone {
+		return nil, nil
+	}
+
+	// group unassigned tickets by topic
+	byTopic := make(map[*Topic][]*Ticket)
+	for _, ticket := range tickets {
+		if ticket.AssigneeID() == NilUserID {
+			topic := oa.TopicByID(ticket.TopicID())
+			if topic != nil && len(topic.TeamIDs()) > 0 {
+				byTopic[topic] = append(byTopic[topic], ticket)
+			}
+		}
+	}
+	if len(byTopic) == 0 {
+		return nil, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	unavailable, err := GetUnavailableUsers(rc, oa.OrgID())
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting unavailable users")
+	}
+
+	byAssignee := make(map[UserID][]*Ticket)
+
+	for topic, topicTickets := range byTopic {
+		candidates := ticketAssignmentCandidates(oa, topic, unavailable)
+		if len(candidates) == 0 {
+			continue
+		}
+
+		var assignees []*User
+		switch strategy {
+		case TicketAssignmentRoundRobin:
+			assignees, err = pickRoundRobin(rc, oa, topic, candidates, len(topicTickets))
+		case TicketAssignmentLoadBalanced:
+			assignees, err = pickLoadBalanced(ctx, db, oa, candidates, len(topicTickets))
+		default:
+			return nil, errors.Errorf("unknown ticket assignment strategy: %s", strategy)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error picking assignees for topic %s", topic.UUID())
+		}
+
+		for i, ticket := range topicTickets {
+			byAssignee[assignees[i].ID()] = append(byAssignee[assignees[i].ID()], ticket)
+		}
+	}
+
+	eventsByTicket := make(map[*Ticket]*TicketEvent)
+
+	for assigneeID, assigneeTickets := range byAssignee {
+		evts, err := TicketsAssign(ctx, db, oa, NilUserID, assigneeTickets, assigneeID, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "error assigning tickets")
+		}
+		for t, e := range evts {
+			eventsByTicket[t] = e
+		}
+	}
+
+	return eventsByTicket, nil
+}
+
+// gets the available users who can be assigned tickets with the given topic, i.e. users who can be assigned tickets
+// and belong to one of the topic's teams
+func ticketAssignmentCandidates(oa *OrgAssets, topic *Topic, unavailable map[UserID]bool) []*User {
+	teams := make(map[TeamID]bool, len(topic.TeamIDs()))
+	for _, id := range topic.TeamIDs() {
+		teams[id] = true
+	}
+
+	candidates := make([]*User, 0, 5)
+	for _, user := range usersWithRoles(oa, ticketAssignableToles) {
+		if user.Team() != nil && teams[user.Team().ID] && !unavailable[user.ID()] {
+			candidates = append(candidates, user)
+		}
+	}
+	return candidates
+}
+
+// picks the next n assignees by cycling through the candidates, with our position in the cycle stored in redis
+func pickRoundRobin(rc redis.Conn, oa *OrgAssets, topic *Topic, candidates []*User, n int) ([]*User, error) {
+	key := fmt.Sprintf(ticketAssignmentCursorKey, oa.OrgID(), topic.ID())
+
+	end, err := redis.Int(rc.Do("INCRBY", key, n))
+	if err != nil {
+		return nil, err
+	}
+
+	assignees := make([]*User, n)
+	for i := range assignees {
+		assignees[i] = candidates[(end-n+i)%len(candidates)]
+	}
+	return assignees, nil
+}
+
+const sqlSelectOpenTicketCountsByAssignee = `
+  SELECT assignee_id, COUNT(*)
+    FROM tickets_ticket
+   WHERE org_id = $1 AND status = 'O' AND assignee_id = ANY($2)
+GROUP BY assignee_id`
+
+// picks the next n assignees by giving each ticket to whichever candidate has the fewest open tickets
+func pickLoadBalanced(ctx context.Context, db Queryer, oa *OrgAssets, candidates []*User, n int) ([]*User, error) {
+	ids := make([]UserID, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.ID()
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlSelectOpenTicketCountsByAssignee, oa.OrgID(), pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying open ticket counts")
+	}
+	defer rows.Close()
+
+	counts := make(map[UserID]int, len(candidates))
+	for rows.Next() {
+		var userID UserID
+		var count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, errors.Wrap(err, "error scanning open ticket count")
+		}
+		counts[userID] = count
+	}
+
+	assignees := make([]*User, n)
+	for i := range assignees {
+		var least *User
+		for _, c := range candidates {
+			if least == nil || counts[c.ID()] < counts[least.ID()] {
+				least = c
+			}
+		}
+		assignees[i] = least
+		counts[least.ID()]++
+	}
+	return assignees, nil
+}
@@ -0,0 +1,112 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketsAutoAssign(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// support tickets are handled by the office team (admin and editor users)
+	db.MustExec(`INSERT INTO tickets_team_topics(team_id, topic_id) VALUES($1, $2)`, testdata.Office.ID, testdata.SupportTopic.ID)
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_assignment": "round_robin"}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.TicketAssignmentRoundRobin, oa.Org().TicketAssignment())
+	assert.Equal(t, []models.TeamID{testdata.Office.ID}, oa.TopicByID(testdata.SupportTopic.ID).TeamIDs())
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", time.Now(), nil).Load(db)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", time.Now(), nil).Load(db)
+	ticket3 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SupportTopic, "Where my hat", "", time.Now(), nil).Load(db)
+	ticket4 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SalesTopic, "Where my socks", "", time.Now(), nil).Load(db)
+	ticket5 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my belt", "", time.Now(), testdata.Agent).Load(db)
+
+	evts, err := models.TicketsAutoAssign(ctx, rt, db, oa, []*models.Ticket{ticket1, ticket2, ticket3, ticket4, ticket5})
+	require.NoError(t, err)
+
+	// sales topic has no teams and ticket #5 already had an assignee
+	assert.Equal(t, 3, len(evts))
+	assert.Equal(t, models.TicketEventTypeAssigned, evts[ticket1].EventType())
+
+	assert.Equal(t, testdata.Admin.ID, ticket1.AssigneeID())
+	assert.Equal(t, testdata.Editor.ID, ticket2.AssigneeID())
+	assert.Equal(t, testdata.Admin.ID, ticket3.AssigneeID())
+	assert.Equal(t, models.NilUserID, ticket4.AssigneeID())
+	assert.Equal(t, testdata.Agent.ID, ticket5.AssigneeID())
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE assignee_id = $1`, testdata.Admin.ID).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE event_type = 'A' AND created_by_id IS NULL`).Returns(3)
+	assertdb.Query(t, db, `SELECT count(*) FROM notifications_notification WHERE notification_type = 'tickets:activity'`).Returns(2)
+
+	// round robin continues from where it left off
+	ticket6 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my gloves", "", time.Now(), nil).Load(db)
+
+	_, err = models.TicketsAutoAssign(ctx, rt, db, oa, []*models.Ticket{ticket6})
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Editor.ID, ticket6.AssigneeID())
+
+	// unavailable users are skipped
+	rc := rp.Get()
+	defer rc.Close()
+
+	require.NoError(t, models.SetUserAvailable(rc, testdata.Org1.ID, testdata.Editor.ID, false))
+
+	unavailable, err := models.GetUnavailableUsers(rc, testdata.Org1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[models.UserID]bool{testdata.Editor.ID: true}, unavailable)
+
+	ticket7 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my scarf", "", time.Now(), nil).Load(db)
+	ticket8 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SupportTopic, "Where my coat", "", time.Now(), nil).Load(db)
+
+	_, err = models.TicketsAutoAssign(ctx, rt, db, oa, []*models.Ticket{ticket7, ticket8})
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Admin.ID, ticket7.AssigneeID())
+	assert.Equal(t, testdata.Admin.ID, ticket8.AssigneeID())
+
+	// switch to load balancing with both users available.. admin has 4 open tickets and editor has 2
+	require.NoError(t, models.SetUserAvailable(rc, testdata.Org1.ID, testdata.Editor.ID, true))
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_assignment": "load_balanced"}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	oa, err = models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	ticket9 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my tie", "", time.Now(), nil).Load(db)
+	ticket10 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my boots", "", time.Now(), nil).Load(db)
+	ticket11 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SupportTopic, "Where my cap", "", time.Now(), nil).Load(db)
+
+	_, err = models.TicketsAutoAssign(ctx, rt, db, oa, []*models.Ticket{ticket9, ticket10, ticket11})
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Editor.ID, ticket9.AssigneeID())
+	assert.Equal(t, testdata.Editor.ID, ticket10.AssigneeID())
+	assert.Equal(t, testdata.Admin.ID, ticket11.AssigneeID())
+
+	// no assignment if org has no strategy
+	db.MustExec(`UPDATE orgs_org SET config = '{}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	oa, err = models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	ticket12 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my keys", "", time.Now(), nil).Load(db)
+
+	evts, err = models.TicketsAutoAssign(ctx, rt, db, oa, []*models.Ticket{ticket12})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(evts))
+	assert.Equal(t, models.NilUserID, ticket12.AssigneeID())
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/pkg/errors"
+)
+
+const configTicketAutoClose = "ticket_auto_close"
+
+// TicketAutoClosePolicy is the policy for closing tickets which have been inactive for too long. Policies are read
+// from the org config with timeouts in seconds, and a timeout of zero for a topic disables auto-closing for that
+// topic, e.g.
+//
+//   "ticket_auto_close": {
+//     "timeout": 604800,
+//     "topics": {
+//       "ffc903f7-8cbb-443f-9627-87106842d1aa": 86400,
+//       "472a7a73-96cb-4736-b567-056d987cc5b4": 0
+//     },
+//     "message": "This ticket has been closed due to inactivity."
+//   }
+//
+type TicketAutoClosePolicy struct {
+	Timeout int                      `json:"timeout" validate:"min=0"`
+	Topics  map[assets.TopicUUID]int `json:"topics"`
+	Message string                   `json:"message"`
+}
+
+// TimeoutFor returns how long tickets with the given topic can be inactive before being closed, or zero if they
+// shouldn't be closed
+func (p *TicketAutoClosePolicy) TimeoutFor(topicUUID assets.TopicUUID) time.Duration {
+	secs, hasTopic := p.Topics[topicUUID]
+	if !hasTopic {
+		secs = p.Timeout
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// TicketAutoClosePolicy returns the ticket auto-close policy for this org if it has one
+func (o *Org) TicketAutoClosePolicy() (*TicketAutoClosePolicy, error) {
+	raw := o.o.Config.Get(configTicketAutoClose, nil)
+	if raw == nil {
+		return nil, nil
+	}
+
+	// config has already been unmarshalled as a generic map so round trip via JSON to get our type
+	marshaled, err := json.Marshal(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling ticket auto-close config")
+	}
+
+	policy := &TicketAutoClosePolicy{}
+	if err := json.Unmarshal(marshaled, policy); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling ticket auto-close config")
+	}
+
+	if err := utils.Validate(policy); err != nil {
+		return nil, errors.Wrap(err, "invalid ticket auto-close policy")
+	}
+	for topicUUID, secs := range policy.Topics {
+		if secs < 0 {
+			return nil, errors.Errorf("invalid ticket auto-close policy: negative timeout for topic %s", topicUUID)
+		}
+	}
+
+	return policy, nil
+}
+
+const sqlSelectInactiveTickets = `
+SELECT
+  t.id AS id,
+  t.uuid AS uuid,
+  t.org_id AS org_id,
+  t.contact_id AS contact_id,
+  t.ticketer_id AS ticketer_id,
+  t.external_id AS external_id,
+  t.status AS status,
+  t.topic_id AS topic_id,
+  t.body AS body,
+  t.assignee_id AS assignee_id,
+  t.config AS config,
+  t.opened_on AS opened_on,
+  t.replied_on,
+  t.modified_on AS modified_on,
+  t.closed_on AS closed_on,
+  t.last_activity_on AS last_activity_on
+FROM
+  tickets_ticket t
+WHERE
+  t.org_id = $1 AND
+  t.topic_id = $2 AND
+  t.status = 'O' AND
+  t.last_activity_on < $3
+ORDER BY
+  t.last_activity_on
+`
+
+// LoadInactiveTickets loads the open tickets in the given org and topic with no activity since the given time
+func LoadInactiveTickets(ctx context.Context, db Queryer, orgID OrgID, topicID TopicID, since time.Time) ([]*Ticket, error) {
+	return loadTickets(ctx, db, sqlSelectInactiveTickets, orgID, topicID, since)
+}
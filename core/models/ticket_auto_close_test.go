@@ -0,0 +1,69 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketAutoClosePolicy(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	policy, err := oa.Org().TicketAutoClosePolicy()
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_auto_close": {"timeout": 86400, "topics": {"0a8f2e00-fef6-402c-bd79-d789446ec0e0": 3600, "9ef2ff21-064a-41f1-8560-ccc990b4f937": 0}, "message": "Bye"}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	policy, err = oa.Org().TicketAutoClosePolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, "Bye", policy.Message)
+	assert.Equal(t, 24*time.Hour, policy.TimeoutFor(testdata.DefaultTopic.UUID))
+	assert.Equal(t, time.Hour, policy.TimeoutFor(testdata.SupportTopic.UUID))
+	assert.Equal(t, time.Duration(0), policy.TimeoutFor(testdata.SalesTopic.UUID))
+
+	// negative timeouts aren't valid
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_auto_close": {"topics": {"0a8f2e00-fef6-402c-bd79-d789446ec0e0": -1}}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	_, err = oa.Org().TicketAutoClosePolicy()
+	assert.EqualError(t, err, "invalid ticket auto-close policy: negative timeout for topic 0a8f2e00-fef6-402c-bd79-d789446ec0e0")
+}
+
+func TestLoadInactiveTickets(t *testing.T) {
+	ctx, _, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	now := time.Now()
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-3*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-2*time.Hour))
+
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", now.Add(-3*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket2.ID, now.Add(-10*time.Minute))
+
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Where my hat", "", now.Add(-3*time.Hour), nil)
+	testdata.InsertClosedTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SupportTopic, "Where my coat", "", nil)
+
+	tickets, err := models.LoadInactiveTickets(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tickets))
+	assert.Equal(t, ticket1.ID, tickets[0].ID())
+}
@@ -19,6 +19,7 @@ const (
 	TicketEventTypeTopicChanged TicketEventType = "T"
 	TicketEventTypeClosed       TicketEventType = "C"
 	TicketEventTypeReopened     TicketEventType = "R"
+	TicketEventTypeSLABreached  TicketEventType = "B"
 )
 
 type TicketEvent struct {
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dbutil"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// TicketExportRow is a single ticket as it appears in an export, with its topic, assignee and event history
+type TicketExportRow struct {
+	UUID           flows.TicketUUID     `json:"uuid"`
+	ContactUUID    flows.ContactUUID    `json:"contact_uuid"`
+	ContactName    string               `json:"contact_name"`
+	Status         TicketStatus         `json:"status"`
+	Topic          string               `json:"topic"`
+	Assignee       string               `json:"assignee"`
+	Ticketer       string               `json:"ticketer"`
+	Body           string               `json:"body"`
+	OpenedOn       time.Time            `json:"opened_on"`
+	RepliedOn      *time.Time           `json:"replied_on"`
+	ClosedOn       *time.Time           `json:"closed_on"`
+	LastActivityOn time.Time            `json:"last_activity_on"`
+	Events         []*TicketExportEvent `json:"events"`
+}
+
+// FirstResponse returns the time taken for the first reply to the ticket, or nil if it hasn't been replied to
+func (r *TicketExportRow) FirstResponse() *time.Duration {
+	if r.RepliedOn == nil {
+		return nil
+	}
+	d := r.RepliedOn.Sub(r.OpenedOn)
+	return &d
+}
+
+// TicketExportEvent is a single event in the history of an exported ticket
+type TicketExportEvent struct {
+	Type      TicketEventType `json:"type"`
+	CreatedBy string          `json:"created_by"`
+	Assignee  string          `json:"assignee"`
+	Topic     string          `json:"topic"`
+	Note      string          `json:"note"`
+	CreatedOn time.Time       `json:"created_on"`
+}
+
+const sqlSelectTicketExportRows = `
+SELECT ROW_TO_JSON(r) FROM (
+SELECT
+	t.uuid,
+	c.uuid AS contact_uuid,
+	COALESCE(c.name, '') AS contact_name,
+	t.status,
+	COALESCE(tp.name, '') AS topic,
+	COALESCE(u.email, '') AS assignee,
+	tk.name AS ticketer,
+	t.body,
+	t.opened_on,
+	t.replied_on,
+	t.closed_on,
+	t.last_activity_on,
+	(
+		SELECT COALESCE(
+			json_agg(
+				json_build_object(
+					'type', e.event_type,
+					'created_by', COALESCE(cb.email, ''),
+					'assignee', COALESCE(a.email, ''),
+					'topic', COALESCE(et.name, ''),
+					'note', COALESCE(e.note, ''),
+					'created_on', e.created_on
+				) ORDER BY e.created_on ASC, e.id ASC
+			), '[]'
+		)
+		FROM tickets_ticketevent e
+		LEFT JOIN auth_user cb ON cb.id = e.created_by_id
+		LEFT JOIN auth_user a ON a.id = e.assignee_id
+		LEFT JOIN tickets_topic et ON et.id = e.topic_id
+		WHERE e.ticket_id = t.id
+	) AS events
+FROM
+	tickets_ticket t
+JOIN
+	contacts_contact c ON c.id = t.contact_id
+JOIN
+	tickets_ticketer tk ON tk.id = t.ticketer_id
+LEFT JOIN
+	tickets_topic tp ON tp.id = t.topic_id
+LEFT JOIN
+	auth_user u ON u.id = t.assignee_id
+WHERE
+	t.id = ANY($1)
+ORDER BY
+	t.id ASC
+) r;
+`
+
+// LoadTicketExportRows loads the given tickets as export rows, in order of id
+func LoadTicketExportRows(ctx context.Context, db Queryer, ids []TicketID) ([]*TicketExportRow, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectTicketExportRows, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying ticket export rows")
+	}
+	defer rows.Close()
+
+	exportRows := make([]*TicketExportRow, 0, len(ids))
+	for rows.Next() {
+		row := &TicketExportRow{}
+		if err := dbutil.ScanJSON(rows, row); err != nil {
+			return nil, errors.Wrap(err, "error scanning ticket export row")
+		}
+		exportRows = append(exportRows, row)
+	}
+
+	return exportRows, rows.Err()
+}
@@ -0,0 +1,70 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTicketExportRows(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	openedOn := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Zendesk, testdata.SupportTopic, "Where my pants", "", openedOn, nil)
+	ticket2 := testdata.InsertClosedTicket(db, testdata.Org1, testdata.Bob, testdata.Zendesk, testdata.SalesTopic, "Where my shoes", "", testdata.Agent)
+	db.MustExec(`UPDATE tickets_ticket SET replied_on = $2 WHERE id = $1`, ticket1.ID, openedOn.Add(90*time.Second))
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	modelTicket := ticket1.Load(db)
+	_, err = models.TicketsAssign(ctx, db, oa, testdata.Admin.ID, []*models.Ticket{modelTicket}, testdata.Agent.ID, "Please handle")
+	require.NoError(t, err)
+	_, err = models.TicketsAddNote(ctx, db, oa, testdata.Agent.ID, []*models.Ticket{modelTicket}, "Looking into it")
+	require.NoError(t, err)
+
+	rows, err := models.LoadTicketExportRows(ctx, db, []models.TicketID{ticket2.ID, ticket1.ID})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rows))
+
+	// rows are ordered by ticket id
+	row := rows[0]
+	assert.Equal(t, ticket1.UUID, row.UUID)
+	assert.Equal(t, testdata.Cathy.UUID, row.ContactUUID)
+	assert.Equal(t, "Cathy", row.ContactName)
+	assert.Equal(t, models.TicketStatusOpen, row.Status)
+	assert.Equal(t, "Support", row.Topic)
+	assert.Equal(t, "agent1@nyaruka.com", row.Assignee)
+	assert.Equal(t, "Zendesk (Nyaruka)", row.Ticketer)
+	assert.Equal(t, "Where my pants", row.Body)
+	assert.True(t, openedOn.Equal(row.OpenedOn))
+	assert.Nil(t, row.ClosedOn)
+	assert.Equal(t, 90*time.Second, *row.FirstResponse())
+
+	require.Equal(t, 2, len(row.Events))
+	assert.Equal(t, models.TicketEventTypeAssigned, row.Events[0].Type)
+	assert.Equal(t, "admin1@nyaruka.com", row.Events[0].CreatedBy)
+	assert.Equal(t, "agent1@nyaruka.com", row.Events[0].Assignee)
+	assert.Equal(t, "Please handle", row.Events[0].Note)
+	assert.Equal(t, models.TicketEventTypeNoteAdded, row.Events[1].Type)
+	assert.Equal(t, "agent1@nyaruka.com", row.Events[1].CreatedBy)
+	assert.Equal(t, "", row.Events[1].Assignee)
+	assert.Equal(t, "Looking into it", row.Events[1].Note)
+
+	row = rows[1]
+	assert.Equal(t, ticket2.UUID, row.UUID)
+	assert.Equal(t, models.TicketStatusClosed, row.Status)
+	assert.Equal(t, "Sales", row.Topic)
+	assert.NotNil(t, row.ClosedOn)
+	assert.Nil(t, row.FirstResponse())
+	assert.Equal(t, 0, len(row.Events))
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// TicketFilter selects tickets for bulk operations by their properties rather than by explicit ids. All conditions
+// which are set must be matched.
+//
+//   {
+//     "status": "O",
+//     "topic_id": 12,
+//     "assignee_id": 234,
+//     "ticketer_id": 3,
+//     "last_activity_before": "2021-09-01T00:00:00Z",
+//     "contact_query": "age > 18"
+//   }
+//
+type TicketFilter struct {
+	Status             TicketStatus `json:"status,omitempty"               validate:"omitempty,eq=O|eq=C"`
+	TopicID            TopicID      `json:"topic_id,omitempty"`
+	AssigneeID         UserID       `json:"assignee_id,omitempty"`
+	Unassigned         bool         `json:"unassigned,omitempty"`
+	TicketerID         TicketerID   `json:"ticketer_id,omitempty"`
+	OpenedAfter        *time.Time   `json:"opened_after,omitempty"`
+	OpenedBefore       *time.Time   `json:"opened_before,omitempty"`
+	LastActivityAfter  *time.Time   `json:"last_activity_after,omitempty"`
+	LastActivityBefore *time.Time   `json:"last_activity_before,omitempty"`
+	ContactQuery       string       `json:"contact_query,omitempty"`
+}
+
+// IsEmpty returns whether this filter sets no conditions and so matches every ticket in the org
+func (f *TicketFilter) IsEmpty() bool {
+	return f.Status == "" && f.TopicID == NilTopicID && f.AssigneeID == NilUserID && !f.Unassigned && f.TicketerID == TicketerID(0) &&
+		f.OpenedAfter == nil && f.OpenedBefore == nil && f.LastActivityAfter == nil && f.LastActivityBefore == nil && f.ContactQuery == ""
+}
+
+// SelectTicketIDsByFilter selects the ids of the tickets in the given org which match the given filter. Because contact
+// queries are evaluated by elastic, the caller must resolve the filter's contact query to contactIDs, and a nil value
+// means no restriction on contacts. Note that an empty filter selects every ticket in the org, so callers which modify
+// the selected tickets should reject empty filters.
+func SelectTicketIDsByFilter(ctx context.Context, db Queryer, orgID OrgID, filter *TicketFilter, contactIDs []ContactID) ([]TicketID, error) {
+	conditions := []string{"t.org_id = $1"}
+	params := []interface{}{orgID}
+
+	addCondition := func(cond string, value interface{}) {
+		params = append(params, value)
+		conditions = append(conditions, fmt.Sprintf(cond, len(params)))
+	}
+
+	if filter.Status != "" {
+		addCondition("t.status = $%d", filter.Status)
+	}
+	if filter.TopicID != NilTopicID {
+		addCondition("t.topic_id = $%d", filter.TopicID)
+	}
+	if filter.Unassigned {
+		conditions = append(conditions, "t.assignee_id IS NULL")
+	} else if filter.AssigneeID != NilUserID {
+		addCondition("t.assignee_id = $%d", filter.AssigneeID)
+	}
+	if filter.TicketerID != TicketerID(0) {
+		addCondition("t.ticketer_id = $%d", filter.TicketerID)
+	}
+	if filter.OpenedAfter != nil {
+		addCondition("t.opened_on >= $%d", *filter.OpenedAfter)
+	}
+	if filter.OpenedBefore != nil {
+		addCondition("t.opened_on < $%d", *filter.OpenedBefore)
+	}
+	if filter.LastActivityAfter != nil {
+		addCondition("t.last_activity_on >= $%d", *filter.LastActivityAfter)
+	}
+	if filter.LastActivityBefore != nil {
+		addCondition("t.last_activity_on < $%d", *filter.LastActivityBefore)
+	}
+	if contactIDs != nil {
+		addCondition("t.contact_id = ANY($%d)", pq.Array(contactIDs))
+	}
+
+	query := fmt.Sprintf(`SELECT t.id FROM tickets_ticket t WHERE %s ORDER BY t.id`, strings.Join(conditions, " AND "))
+
+	rows, err := db.QueryxContext(ctx, query, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error selecting tickets by filter")
+	}
+	defer rows.Close()
+
+	ids := make([]TicketID, 0, 100)
+	for rows.Next() {
+		var id TicketID
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "error scanning ticket id")
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
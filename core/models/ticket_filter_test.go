@@ -0,0 +1,61 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketFilterIsEmpty(t *testing.T) {
+	now := time.Now()
+
+	assert.True(t, (&models.TicketFilter{}).IsEmpty())
+	assert.False(t, (&models.TicketFilter{Status: models.TicketStatusOpen}).IsEmpty())
+	assert.False(t, (&models.TicketFilter{Unassigned: true}).IsEmpty())
+	assert.False(t, (&models.TicketFilter{OpenedAfter: &now}).IsEmpty())
+	assert.False(t, (&models.TicketFilter{ContactQuery: "age > 18"}).IsEmpty())
+}
+
+func TestSelectTicketIDsByFilter(t *testing.T) {
+	ctx, _, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	now := time.Now()
+	dayAgo := now.Add(-24 * time.Hour)
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-48*time.Hour), testdata.Admin)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Mailgun, testdata.SupportTopic, "Where my shoes", "", now, nil)
+	ticket3 := testdata.InsertClosedTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Where my hat", "", testdata.Admin)
+
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-48*time.Hour))
+
+	tcs := []struct {
+		filter     *models.TicketFilter
+		contactIDs []models.ContactID
+		expected   []models.TicketID
+	}{
+		{&models.TicketFilter{}, nil, []models.TicketID{ticket1.ID, ticket2.ID, ticket3.ID}},
+		{&models.TicketFilter{Status: models.TicketStatusOpen}, nil, []models.TicketID{ticket1.ID, ticket2.ID}},
+		{&models.TicketFilter{TopicID: testdata.SalesTopic.ID}, nil, []models.TicketID{ticket3.ID}},
+		{&models.TicketFilter{AssigneeID: testdata.Admin.ID}, nil, []models.TicketID{ticket1.ID, ticket3.ID}},
+		{&models.TicketFilter{Unassigned: true}, nil, []models.TicketID{ticket2.ID}},
+		{&models.TicketFilter{TicketerID: testdata.Mailgun.ID}, nil, []models.TicketID{ticket2.ID}},
+		{&models.TicketFilter{OpenedBefore: &dayAgo}, nil, []models.TicketID{ticket1.ID}},
+		{&models.TicketFilter{LastActivityAfter: &dayAgo}, nil, []models.TicketID{ticket2.ID, ticket3.ID}},
+		{&models.TicketFilter{Status: models.TicketStatusOpen}, []models.ContactID{testdata.Bob.ID, testdata.Alexandria.ID}, []models.TicketID{ticket2.ID}},
+		{&models.TicketFilter{}, []models.ContactID{}, []models.TicketID{}},
+	}
+
+	for i, tc := range tcs {
+		ids, err := models.SelectTicketIDsByFilter(ctx, db, testdata.Org1.ID, tc.filter, tc.contactIDs)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, ids, "%d: ticket ids mismatch", i)
+	}
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TicketEventTypeMerged is the type of the event recorded on a ticket when it's merged into another ticket
+const TicketEventTypeMerged TicketEventType = "M"
+
+// the ticket config key which records the UUID of the ticket a merged ticket was merged into
+const ticketConfigMergedInto = "merged-into"
+
+// NewTicketMergedEvent creates a new event for a ticket being merged into the given primary ticket
+func NewTicketMergedEvent(t *Ticket, userID UserID, primary *Ticket) *TicketEvent {
+	return newTicketEvent(t, userID, TicketEventTypeMerged, fmt.Sprintf("Merged into %s", primary.UUID()), NilTopicID, NilUserID)
+}
+
+// MergedInto returns the UUID of the ticket this ticket was merged into, if any
+func (t *Ticket) MergedInto() flows.TicketUUID {
+	return flows.TicketUUID(t.Config(ticketConfigMergedInto))
+}
+
+const sqlMoveTicketEvents = `
+UPDATE tickets_ticketevent
+   SET ticket_id = $2
+ WHERE ticket_id = ANY($1)`
+
+const sqlMergeTickets = `
+UPDATE tickets_ticket
+   SET status = 'C', modified_on = $3, closed_on = $3, last_activity_on = $3, config = COALESCE(config, '{}'::jsonb) || jsonb_build_object('merged-into', $2::text)
+ WHERE id = ANY($1)`
+
+// ValidateTicketsMerge checks that the given tickets can be merged into the primary ticket
+func ValidateTicketsMerge(primary *Ticket, tickets []*Ticket) error {
+	if primary.MergedInto() != "" {
+		return errors.Errorf("can't merge into ticket %s which has been merged", primary.UUID())
+	}
+	if len(tickets) == 0 {
+		return errors.New("no tickets to merge")
+	}
+
+	for _, ticket := range tickets {
+		if ticket.ID() == primary.ID() {
+			return errors.Errorf("can't merge ticket %s into itself", ticket.UUID())
+		}
+		if ticket.OrgID() != primary.OrgID() || ticket.ContactID() != primary.ContactID() {
+			return errors.Errorf("can't merge ticket %s which belongs to a different contact", ticket.UUID())
+		}
+		if ticket.MergedInto() != "" {
+			return errors.Errorf("can't merge ticket %s which has already been merged", ticket.UUID())
+		}
+	}
+	return nil
+}
+
+// TicketsMerge merges the given tickets into the primary ticket. The notes and events of the merged tickets are moved
+// to the primary ticket, and then each merged ticket is closed with a merged event. These changes are made in a single
+// transaction, after which any open merged tickets are closed on their ticketers. All tickets must belong to the same
+// contact as the primary ticket.
+func TicketsMerge(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, primary *Ticket, tickets []*Ticket, logger *HTTPLogger) (map[*Ticket]*TicketEvent, error) {
+	if err := ValidateTicketsMerge(primary, tickets); err != nil {
+		return nil, err
+	}
+
+	byTicketer := make(map[TicketerID][]*Ticket)
+	ids := make([]TicketID, len(tickets))
+	now := dates.Now()
+
+	for i, ticket := range tickets {
+		ids[i] = ticket.ID()
+	}
+
+	events := make([]*TicketEvent, 0, len(tickets))
+	eventsByTicket := make(map[*Ticket]*TicketEvent, len(tickets))
+	contactIDs := map[ContactID]bool{primary.ContactID(): true}
+
+	for _, ticket := range tickets {
+		// only open tickets need closing on their ticketers
+		if ticket.Status() != TicketStatusClosed {
+			byTicketer[ticket.TicketerID()] = append(byTicketer[ticket.TicketerID()], ticket)
+		}
+
+		e := NewTicketMergedEvent(ticket, userID, primary)
+		events = append(events, e)
+		eventsByTicket[ticket] = e
+	}
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error beginning transaction")
+	}
+
+	if err := mergeTickets(ctx, tx, primary, ids, events, now); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "error committing transaction")
+	}
+
+	for _, ticket := range tickets {
+		t := &ticket.t
+		t.Status = TicketStatusClosed
+		t.ModifiedOn = now
+		t.ClosedOn = &now
+		t.LastActivityOn = now
+		t.Config.Map()[ticketConfigMergedInto] = string(primary.UUID())
+	}
+
+	// the tickets are now merged, so failing to close them on a ticketer only leaves that ticketer out of date
+	for ticketerID, ticketerTickets := range byTicketer {
+		ticketer := oa.TicketerByID(ticketerID)
+		if ticketer != nil {
+			service, err := ticketer.AsService(rt.Config, flows.NewTicketer(ticketer))
+			if err == nil {
+				err = service.Close(ticketerTickets, logger.Ticketer(ticketer))
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("ticketer_uuid", ticketer.UUID()).Error("error closing merged tickets on ticketer")
+			}
+		}
+	}
+
+	if err := recalcGroupsForTicketChanges(ctx, rt.DB, oa, contactIDs); err != nil {
+		return nil, errors.Wrap(err, "error recalculting groups")
+	}
+
+	return eventsByTicket, nil
+}
+
+// moves the events of the given tickets onto the primary ticket, marks them as closed and merged, and records their
+// merged events
+func mergeTickets(ctx context.Context, tx Queryer, primary *Ticket, ids []TicketID, events []*TicketEvent, now time.Time) error {
+	// move existing notes and events onto the primary ticket
+	if err := Exec(ctx, "move ticket events", tx, sqlMoveTicketEvents, pq.Array(ids), primary.ID()); err != nil {
+		return errors.Wrap(err, "error moving ticket events")
+	}
+
+	if err := Exec(ctx, "merge tickets", tx, sqlMergeTickets, pq.Array(ids), primary.UUID(), now); err != nil {
+		return errors.Wrap(err, "error updating tickets")
+	}
+
+	if err := InsertTicketEvents(ctx, tx, events); err != nil {
+		return errors.Wrap(err, "error inserting ticket events")
+	}
+
+	if err := UpdateTicketLastActivity(ctx, tx, []*Ticket{primary}); err != nil {
+		return errors.Wrap(err, "error updating ticket activity")
+	}
+
+	return nil
+}
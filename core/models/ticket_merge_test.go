@@ -0,0 +1,90 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketsMerge(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://api.mailgun.net/v3/tickets.rapidpro.io/messages": {
+			httpx.NewMockResponse(200, nil, `{
+				"id": "<20200426161758.1.590432020254B2BF@tickets.rapidpro.io>",
+				"message": "Queued. Thank you."
+			}`),
+		},
+	}))
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshTicketers)
+	require.NoError(t, err)
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.DefaultTopic, "Where my shoes", "", time.Now(), nil)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Mailgun, testdata.SupportTopic, "Where my pants", "123", time.Now(), nil)
+	ticket3 := testdata.InsertClosedTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SalesTopic, "Where my hat", "", nil)
+	ticket4 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.DefaultTopic, "Where my socks", "", time.Now(), nil)
+
+	primary := ticket1.Load(db)
+	modelTicket2 := ticket2.Load(db)
+	modelTicket3 := ticket3.Load(db)
+
+	_, err = models.TicketsAddNote(ctx, db, oa, testdata.Agent.ID, []*models.Ticket{modelTicket2}, "Cathy's pants are missing")
+	require.NoError(t, err)
+
+	// can't merge a ticket into itself or tickets for other contacts
+	err = models.ValidateTicketsMerge(primary, []*models.Ticket{primary})
+	assert.EqualError(t, err, "can't merge ticket "+string(ticket1.UUID)+" into itself")
+
+	err = models.ValidateTicketsMerge(primary, []*models.Ticket{ticket4.Load(db)})
+	assert.EqualError(t, err, "can't merge ticket "+string(ticket4.UUID)+" which belongs to a different contact")
+
+	logger := &models.HTTPLogger{}
+	evts, err := models.TicketsMerge(ctx, rt, oa, testdata.Admin.ID, primary, []*models.Ticket{modelTicket2, modelTicket3}, logger)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(evts))
+	assert.Equal(t, models.TicketEventTypeMerged, evts[modelTicket2].EventType())
+	assert.Equal(t, models.TicketEventTypeMerged, evts[modelTicket3].EventType())
+
+	// merged tickets are closed and record what they were merged into
+	assert.Equal(t, models.TicketStatusClosed, modelTicket2.Status())
+	assert.Equal(t, ticket1.UUID, modelTicket2.MergedInto())
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE id = ANY(ARRAY[$1, $2]::int[]) AND status = 'C' AND config->>'merged-into' = $3`, ticket2.ID, ticket3.ID, ticket1.UUID).Returns(2)
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("O")
+
+	// the note was moved to the primary ticket and each merged ticket has a merged event
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'N'`, ticket1.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1`, ticket2.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'M' AND note = $2`, ticket3.ID, "Merged into "+string(ticket1.UUID)).Returns(1)
+
+	// only the open mailgun ticket was closed on its ticketer
+	require.NoError(t, logger.Insert(ctx, db))
+	assertdb.Query(t, db, `SELECT count(*) FROM request_logs_httplog WHERE ticketer_id = $1`, testdata.Mailgun.ID).Returns(1)
+
+	// merged tickets can't be merged again
+	err = models.ValidateTicketsMerge(primary, []*models.Ticket{modelTicket2})
+	assert.EqualError(t, err, "can't merge ticket "+string(ticket2.UUID)+" which has already been merged")
+
+	// or reopened
+	evts, err = models.ReopenTickets(ctx, rt, oa, testdata.Admin.ID, []*models.Ticket{modelTicket2, modelTicket3}, false, logger)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(evts))
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE id = ANY(ARRAY[$1, $2]::int[]) AND status = 'C'`, ticket2.ID, ticket3.ID).Returns(2)
+
+	// and don't have incoming messages forwarded to them
+	err = modelTicket2.ForwardIncoming(ctx, rt, oa, "59d74b86-3e2f-4a93-aece-b05d2fdcde0c", "Hello", nil)
+	assert.NoError(t, err)
+	assertdb.Query(t, db, `SELECT count(*) FROM request_logs_httplog WHERE ticketer_id = $1`, testdata.Mailgun.ID).Returns(1)
+}
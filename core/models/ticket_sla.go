@@ -0,0 +1,246 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/pkg/errors"
+)
+
+// TicketSLAAction is the action taken when tickets breach an SLA policy
+type TicketSLAAction string
+
+// TicketSLABreach is the type of SLA breach
+type TicketSLABreach string
+
+const (
+	TicketSLAActionAssign TicketSLAAction = "assign"
+	TicketSLAActionNote   TicketSLAAction = "note"
+	TicketSLAActionNotify TicketSLAAction = "notify"
+
+	TicketSLABreachFirstResponse TicketSLABreach = "first_response"
+	TicketSLABreachIdle          TicketSLABreach = "idle"
+
+	configTicketSLA = "ticket_sla"
+
+	// ticket config key used to record the start of the window in which a ticket last breached each type of SLA
+	ticketConfigSLABreached = "sla-breached-%s"
+)
+
+// TicketSLAPolicy is the SLA policy for tickets with a given topic. Policies are read from the org config as a map of
+// topic UUIDs to policies, with limits in seconds, e.g.
+//
+//   "ticket_sla": {
+//     "ffc903f7-8cbb-443f-9627-87106842d1aa": {
+//       "first_response": 3600,
+//       "idle": 86400,
+//       "action": "assign",
+//       "assignee": "supervisor@nyaruka.com"
+//     }
+//   }
+//
+type TicketSLAPolicy struct {
+	FirstResponse int             `json:"first_response" validate:"min=0"`
+	Idle          int             `json:"idle"           validate:"min=0"`
+	Action        TicketSLAAction `json:"action"         validate:"required,eq=assign|eq=note|eq=notify"`
+	Assignee      string          `json:"assignee"       validate:"omitempty,email"`
+}
+
+// Limit returns the time allowed before the given type of breach, or zero if there is no limit
+func (p *TicketSLAPolicy) Limit(breach TicketSLABreach) time.Duration {
+	switch breach {
+	case TicketSLABreachFirstResponse:
+		return time.Duration(p.FirstResponse) * time.Second
+	case TicketSLABreachIdle:
+		return time.Duration(p.Idle) * time.Second
+	}
+	return 0
+}
+
+// TicketSLAPolicies returns the ticket SLA policies for this org by topic UUID
+func (o *Org) TicketSLAPolicies() (map[assets.TopicUUID]*TicketSLAPolicy, error) {
+	raw := o.o.Config.Get(configTicketSLA, nil)
+	if raw == nil {
+		return nil, nil
+	}
+
+	// config has already been unmarshalled as a generic map so round trip via JSON to get our types
+	marshaled, err := json.Marshal(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling ticket SLA config")
+	}
+
+	policies := make(map[assets.TopicUUID]*TicketSLAPolicy)
+	if err := json.Unmarshal(marshaled, &policies); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling ticket SLA config")
+	}
+
+	for topicUUID, policy := range policies {
+		if err := utils.Validate(policy); err != nil {
+			return nil, errors.Wrapf(err, "invalid ticket SLA policy for topic %s", topicUUID)
+		}
+		if policy.Action == TicketSLAActionAssign && policy.Assignee == "" {
+			return nil, errors.Errorf("invalid ticket SLA policy for topic %s: assign action requires an assignee", topicUUID)
+		}
+	}
+
+	return policies, nil
+}
+
+// NewTicketSLABreachedEvent creates a new event recording that the given ticket has breached an SLA policy
+func NewTicketSLABreachedEvent(t *Ticket, breach TicketSLABreach, limit time.Duration) *TicketEvent {
+	var note string
+	switch breach {
+	case TicketSLABreachFirstResponse:
+		note = fmt.Sprintf("No response within SLA of %s", limit)
+	case TicketSLABreachIdle:
+		note = fmt.Sprintf("No activity within SLA of %s", limit)
+	}
+
+	return newTicketEvent(t, NilUserID, TicketEventTypeSLABreached, note, NilTopicID, NilUserID)
+}
+
+const sqlSelectOrgsWithOpenTickets = `
+SELECT DISTINCT org_id FROM tickets_ticket WHERE status = 'O' ORDER BY org_id`
+
+// GetOrgIDsWithOpenTickets gets the ids of all orgs which have open tickets
+func GetOrgIDsWithOpenTickets(ctx context.Context, db Queryer) ([]OrgID, error) {
+	orgIDs := make([]OrgID, 0, 10)
+
+	rows, err := db.QueryxContext(ctx, sqlSelectOrgsWithOpenTickets)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying orgs with open tickets")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orgID OrgID
+		if err := rows.Scan(&orgID); err != nil {
+			return nil, errors.Wrap(err, "error scanning org id")
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+
+	return orgIDs, nil
+}
+
+// a ticket can breach its first response SLA if it has never been replied to and hasn't already breached in its window,
+// which starts when the ticket was opened
+const sqlSelectTicketsBreachingFirstResponse = `
+SELECT
+  t.id AS id,
+  t.uuid AS uuid,
+  t.org_id AS org_id,
+  t.contact_id AS contact_id,
+  t.ticketer_id AS ticketer_id,
+  t.external_id AS external_id,
+  t.status AS status,
+  t.topic_id AS topic_id,
+  t.body AS body,
+  t.assignee_id AS assignee_id,
+  t.config AS config,
+  t.opened_on AS opened_on,
+  t.replied_on,
+  t.modified_on AS modified_on,
+  t.closed_on AS closed_on,
+  t.last_activity_on AS last_activity_on
+FROM
+  tickets_ticket t
+WHERE
+  t.org_id = $1 AND
+  t.topic_id = $2 AND
+  t.status = 'O' AND
+  t.replied_on IS NULL AND
+  t.opened_on < $3 AND
+  (t.config->>'sla-breached-first_response')::timestamptz IS DISTINCT FROM t.opened_on
+ORDER BY
+  t.opened_on
+`
+
+// a ticket can breach its idle SLA if there's been no activity on it and it hasn't already breached in its window, which
+// starts at its last activity, so tickets which breach repeatedly require some activity in between
+const sqlSelectTicketsBreachingIdle = `
+SELECT
+  t.id AS id,
+  t.uuid AS uuid,
+  t.org_id AS org_id,
+  t.contact_id AS contact_id,
+  t.ticketer_id AS ticketer_id,
+  t.external_id AS external_id,
+  t.status AS status,
+  t.topic_id AS topic_id,
+  t.body AS body,
+  t.assignee_id AS assignee_id,
+  t.config AS config,
+  t.opened_on AS opened_on,
+  t.replied_on,
+  t.modified_on AS modified_on,
+  t.closed_on AS closed_on,
+  t.last_activity_on AS last_activity_on
+FROM
+  tickets_ticket t
+WHERE
+  t.org_id = $1 AND
+  t.topic_id = $2 AND
+  t.status = 'O' AND
+  t.last_activity_on < $3 AND
+  (t.config->>'sla-breached-idle')::timestamptz IS DISTINCT FROM t.last_activity_on
+ORDER BY
+  t.last_activity_on
+`
+
+// LoadTicketsBreachingSLA loads the open tickets in the given org and topic which have breached the given limit
+func LoadTicketsBreachingSLA(ctx context.Context, db Queryer, orgID OrgID, topicID TopicID, breach TicketSLABreach, limit time.Duration) ([]*Ticket, error) {
+	cutoff := dates.Now().Add(-limit)
+
+	switch breach {
+	case TicketSLABreachFirstResponse:
+		return loadTickets(ctx, db, sqlSelectTicketsBreachingFirstResponse, orgID, topicID, cutoff)
+	case TicketSLABreachIdle:
+		return loadTickets(ctx, db, sqlSelectTicketsBreachingIdle, orgID, topicID, cutoff)
+	}
+	return nil, errors.Errorf("unknown SLA breach type: %s", breach)
+}
+
+// TicketsRecordSLABreach adds SLA breached events to the given tickets
+func TicketsRecordSLABreach(ctx context.Context, db Queryer, tickets []*Ticket, breach TicketSLABreach, limit time.Duration) (map[*Ticket]*TicketEvent, error) {
+	events := make([]*TicketEvent, 0, len(tickets))
+	eventsByTicket := make(map[*Ticket]*TicketEvent, len(tickets))
+
+	for _, ticket := range tickets {
+		e := NewTicketSLABreachedEvent(ticket, breach, limit)
+		events = append(events, e)
+		eventsByTicket[ticket] = e
+	}
+
+	if err := InsertTicketEvents(ctx, db, events); err != nil {
+		return nil, errors.Wrap(err, "error inserting ticket events")
+	}
+
+	return eventsByTicket, nil
+}
+
+const sqlUpdateTicketsSLABreached = `
+UPDATE tickets_ticket
+   SET config = COALESCE(config, '{}'::jsonb) || jsonb_build_object($2::text, CASE WHEN $3 THEN last_activity_on ELSE opened_on END)
+ WHERE id = ANY($1)`
+
+// TicketsSetSLABreached records that the given tickets have breached an SLA policy in their current window, so that they
+// aren't escalated again until a new window starts. This should be called after the tickets have been escalated because
+// escalating a ticket is activity within the window that it breached.
+func TicketsSetSLABreached(ctx context.Context, db Queryer, tickets []*Ticket, breach TicketSLABreach) error {
+	ids := make([]TicketID, len(tickets))
+	for i, ticket := range tickets {
+		ids[i] = ticket.ID()
+	}
+
+	key := fmt.Sprintf(ticketConfigSLABreached, breach)
+
+	return Exec(ctx, "set tickets SLA breached", db, sqlUpdateTicketsSLABreached, pq.Array(ids), key, breach == TicketSLABreachIdle)
+}
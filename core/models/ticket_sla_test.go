@@ -0,0 +1,117 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketSLAPolicies(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	policies, err := oa.Org().TicketSLAPolicies()
+	assert.NoError(t, err)
+	assert.Nil(t, policies)
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_sla": {"0a8f2e00-fef6-402c-bd79-d789446ec0e0": {"first_response": 3600, "idle": 86400, "action": "assign", "assignee": "admin1@nyaruka.com"}}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	policies, err = oa.Org().TicketSLAPolicies()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(policies))
+
+	policy := policies[testdata.SupportTopic.UUID]
+	assert.Equal(t, models.TicketSLAActionAssign, policy.Action)
+	assert.Equal(t, "admin1@nyaruka.com", policy.Assignee)
+	assert.Equal(t, time.Hour, policy.Limit(models.TicketSLABreachFirstResponse))
+	assert.Equal(t, 24*time.Hour, policy.Limit(models.TicketSLABreachIdle))
+
+	// assign action without an assignee isn't valid
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_sla": {"0a8f2e00-fef6-402c-bd79-d789446ec0e0": {"idle": 86400, "action": "assign"}}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	_, err = oa.Org().TicketSLAPolicies()
+	assert.EqualError(t, err, "invalid ticket SLA policy for topic 0a8f2e00-fef6-402c-bd79-d789446ec0e0: assign action requires an assignee")
+}
+
+func TestLoadTicketsBreachingSLA(t *testing.T) {
+	ctx, _, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	now := time.Now()
+
+	// opened 2 hours ago and never replied to
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-2*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-2*time.Hour))
+
+	// opened 2 hours ago, replied to and active recently
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", now.Add(-2*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET replied_on = $2, last_activity_on = $2 WHERE id = $1`, ticket2.ID, now.Add(-10*time.Minute))
+
+	// opened 3 hours ago but with a different topic
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Where my hat", "", now.Add(-3*time.Hour), nil)
+
+	tickets, err := models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachFirstResponse, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tickets))
+	assert.Equal(t, ticket1.ID, tickets[0].ID())
+
+	tickets, err = models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachIdle, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tickets))
+	assert.Equal(t, ticket1.ID, tickets[0].ID())
+
+	events, err := models.TicketsRecordSLABreach(ctx, db, tickets, models.TicketSLABreachIdle, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.TicketEventTypeSLABreached, events[tickets[0]].EventType())
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B' AND note = 'No activity within SLA of 30m0s'`, ticket1.ID).Returns(1)
+
+	// once a ticket is marked as breached, it doesn't breach again until there's been more activity
+	err = models.TicketsSetSLABreached(ctx, db, tickets, models.TicketSLABreachIdle)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT (config->>'sla-breached-idle')::timestamptz = last_activity_on FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns(true)
+
+	tickets, err = models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachIdle, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(tickets))
+
+	// but breaches are recorded separately for each type of SLA
+	tickets, err = models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachFirstResponse, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tickets))
+
+	err = models.TicketsSetSLABreached(ctx, db, tickets, models.TicketSLABreachFirstResponse)
+	require.NoError(t, err)
+
+	tickets, err = models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachFirstResponse, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(tickets))
+
+	// new activity starts a new idle window
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-time.Hour))
+
+	tickets, err = models.LoadTicketsBreachingSLA(ctx, db, testdata.Org1.ID, testdata.SupportTopic.ID, models.TicketSLABreachIdle, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(tickets))
+	assert.Equal(t, ticket1.ID, tickets[0].ID())
+}
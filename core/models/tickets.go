@@ -162,8 +162,13 @@ func (t *Ticket) FlowTicket(oa *OrgAssets) (*flows.Ticket, error) {
 	), nil
 }
 
-// ForwardIncoming forwards an incoming message from a contact to this ticket
+// ForwardIncoming forwards an incoming message from a contact to this ticket. Messages aren't forwarded to tickets which
+// have been merged into another ticket, as the surviving ticket receives them instead.
 func (t *Ticket) ForwardIncoming(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, msgUUID flows.MsgUUID, text string, attachments []utils.Attachment) error {
+	if t.MergedInto() != "" {
+		return nil
+	}
+
 	ticketer := oa.TicketerByID(t.t.TicketerID)
 	if ticketer == nil {
 		return errors.Errorf("can't find ticketer with id %d", t.t.TicketerID)
@@ -615,7 +620,8 @@ UPDATE tickets_ticket
    SET status = 'O', modified_on = $2, closed_on = NULL, last_activity_on = $2
  WHERE id = ANY($1)`
 
-// ReopenTickets reopens the passed in tickets
+// ReopenTickets reopens the passed in tickets. Tickets which have been merged into other tickets can't be reopened, as
+// their messages are now handled by the tickets they were merged into, and so are skipped.
 func ReopenTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, tickets []*Ticket, externally bool, logger *HTTPLogger) (map[*Ticket]*TicketEvent, error) {
 	byTicketer := make(map[TicketerID][]*Ticket)
 	ids := make([]TicketID, 0, len(tickets))
@@ -625,7 +631,7 @@ func ReopenTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, user
 	now := dates.Now()
 
 	for _, ticket := range tickets {
-		if ticket.Status() != TicketStatusOpen {
+		if ticket.Status() != TicketStatusOpen && ticket.MergedInto() == "" {
 			byTicketer[ticket.TicketerID()] = append(byTicketer[ticket.TicketerID()], ticket)
 			ids = append(ids, ticket.ID())
 			t := &ticket.t
@@ -669,6 +675,17 @@ func ReopenTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, user
 		return nil, errors.Wrapf(err, "error inserting ticket events")
 	}
 
+	// automatically assign any reopened tickets which don't have an assignee
+	reopened := make([]*Ticket, 0, len(eventsByTicket))
+	for _, ticket := range tickets {
+		if eventsByTicket[ticket] != nil {
+			reopened = append(reopened, ticket)
+		}
+	}
+	if _, err := TicketsAutoAssign(ctx, rt, rt.DB, oa, reopened); err != nil {
+		return nil, errors.Wrapf(err, "error auto assigning tickets")
+	}
+
 	if err := recalcGroupsForTicketChanges(ctx, rt.DB, oa, contactIDs); err != nil {
 		return nil, errors.Wrapf(err, "error recalculting groups")
 	}
@@ -26,6 +26,7 @@ type Topic struct {
 		OrgID     OrgID            `json:"org_id"`
 		Name      string           `json:"name"`
 		IsDefault bool             `json:"is_default"`
+		TeamIDs   []TeamID         `json:"team_ids"`
 	}
 }
 
@@ -44,13 +45,17 @@ func (t *Topic) Name() string { return t.t.Name }
 // Type returns the type
 func (t *Topic) IsDefault() bool { return t.t.IsDefault }
 
+// TeamIDs returns the ids of the teams which handle tickets with this topic
+func (t *Topic) TeamIDs() []TeamID { return t.t.TeamIDs }
+
 const selectOrgTopicsSQL = `
 SELECT ROW_TO_JSON(r) FROM (SELECT
 	t.id as id,
 	t.uuid as uuid,
 	t.org_id as org_id,
 	t.name as name,
-	t.is_default as is_default
+	t.is_default as is_default,
+	(SELECT ARRAY_AGG(tt.team_id ORDER BY tt.team_id) FROM tickets_team_topics tt WHERE tt.topic_id = t.id) as team_ids
 FROM
 	tickets_topic t
 WHERE
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// VariantHoldout is the variant recorded for contacts who fall into the holdout group of a split, i.e. the percentage
+// of contacts not allocated to any variant, who aren't sent anything
+const VariantHoldout = "holdout"
+
+// Variant is one arm of an A/B split of a broadcast or flow start, allocated a percentage of its contacts. Flow start
+// variants start their contacts in a different flow and broadcast variants send them different translations.
+type Variant struct {
+	Name         string                                  `json:"name"`
+	Percent      int                                     `json:"percent"`
+	FlowID       FlowID                                  `json:"flow_id,omitempty"`
+	Translations map[envs.Language]*BroadcastTranslation `json:"translations,omitempty"`
+}
+
+// ValidateVariants checks that the given variants have unique names and allocations which add up to no more than 100%
+func ValidateVariants(variants []*Variant) error {
+	names := make(map[string]bool, len(variants))
+	total := 0
+
+	for _, v := range variants {
+		if v.Name == "" || v.Name == VariantHoldout || names[v.Name] {
+			return errors.Errorf("invalid variant name '%s'", v.Name)
+		}
+		if v.Percent <= 0 {
+			return errors.Errorf("variant '%s' must be allocated a positive percentage", v.Name)
+		}
+		names[v.Name] = true
+		total += v.Percent
+	}
+
+	if total > 100 {
+		return errors.Errorf("variant allocations add up to %d%%", total)
+	}
+	return nil
+}
+
+// AssignVariant deterministically assigns a contact to one of the given variants by hashing their UUID with the given
+// seed, so that a contact always gets the same variant of a particular split. Returns nil if they fall into the holdout.
+func AssignVariant(variants []*Variant, seed string, contactUUID flows.ContactUUID) *Variant {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	h.Write([]byte(contactUUID))
+	bucket := int(h.Sum32() % 100)
+
+	for _, v := range variants {
+		if bucket < v.Percent {
+			return v
+		}
+		bucket -= v.Percent
+	}
+	return nil
+}
+
+const (
+	startVariantsKey     = "variant_assignments:start:%d"
+	broadcastVariantsKey = "variant_assignments:broadcast:%d"
+
+	// VariantAssignmentsExpiry is how long the variant assignments of a split are kept for, and so how long after a
+	// broadcast or flow start its variants can be compared
+	VariantAssignmentsExpiry = 90 * 24 * time.Hour
+
+	// VariantStatsMaxContacts is the maximum number of assigned contacts that variant statistics are computed from, with
+	// the statistics of larger splits being computed from a sample of their contacts
+	VariantStatsMaxContacts = 50000
+)
+
+// StartVariantsKey returns the redis key used to store the variant assignments of the given flow start
+func StartVariantsKey(id StartID) string { return fmt.Sprintf(startVariantsKey, id) }
+
+// BroadcastVariantsKey returns the redis key used to store the variant assignments of the given broadcast
+func BroadcastVariantsKey(id BroadcastID) string { return fmt.Sprintf(broadcastVariantsKey, id) }
+
+const sqlSelectContactUUIDs = `SELECT id, uuid FROM contacts_contact WHERE id = ANY($1)`
+
+// AssignVariants assigns each of the given contacts to one of the variants of the given flow start or broadcast and
+// records those assignments in redis, including those of contacts in the holdout group. Returns the assigned variant of
+// each contact, with contacts in the holdout group being omitted.
+//
+// There's no table for variant assignments in RapidPro so they are only kept for VariantAssignmentsExpiry, and are lost
+// if redis is flushed, which means comparing variants is best effort.
+func AssignVariants(ctx context.Context, db Queryer, rc redis.Conn, startID StartID, broadcastID BroadcastID, variants []*Variant, contactIDs []ContactID) (map[ContactID]*Variant, error) {
+	if err := ValidateVariants(variants); err != nil {
+		return nil, err
+	}
+
+	// each split gets its own seed so that the same contacts don't always end up in the same variant
+	seed, key := fmt.Sprintf("start:%d", startID), StartVariantsKey(startID)
+	if broadcastID != NilBroadcastID {
+		seed, key = fmt.Sprintf("broadcast:%d", broadcastID), BroadcastVariantsKey(broadcastID)
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlSelectContactUUIDs, pq.Array(contactIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "error selecting contact uuids")
+	}
+	defer rows.Close()
+
+	assigned := make(map[ContactID]*Variant, len(contactIDs))
+	assignments := redis.Args{}.Add(key)
+
+	for rows.Next() {
+		var contactID ContactID
+		var contactUUID flows.ContactUUID
+		if err := rows.Scan(&contactID, &contactUUID); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact uuid")
+		}
+
+		name := VariantHoldout
+		if v := AssignVariant(variants, seed, contactUUID); v != nil {
+			assigned[contactID] = v
+			name = v.Name
+		}
+
+		assignments = assignments.Add(int(contactID), name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading contact uuids")
+	}
+
+	if len(assignments) > 1 {
+		rc.Send("MULTI")
+		rc.Send("HSET", assignments...)
+		rc.Send("EXPIRE", key, int(VariantAssignmentsExpiry/time.Second))
+		if _, err := rc.Do("EXEC"); err != nil {
+			return nil, errors.Wrap(err, "error recording variant assignments")
+		}
+	}
+
+	return assigned, nil
+}
+
+// VariantStats are the statistics used to compare the variants of a split, including its holdout group. Response rates
+// are based on contacts sending a message after the broadcast or flow start was created, which lets variants be compared
+// with the holdout group.
+type VariantStats struct {
+	Name         string    `json:"name"`
+	Contacts     int       `json:"contacts"`
+	Responded    int       `json:"responded"`
+	ResponseRate float64   `json:"response_rate"`
+	Runs         *RunStats `json:"runs,omitempty"`
+}
+
+const sqlSelectVariantResponses = `
+SELECT
+	a.variant,
+	count(*) AS contacts,
+	count(*) FILTER (WHERE EXISTS (
+		SELECT 1 FROM msgs_msg m WHERE m.org_id = $3 AND m.contact_id = a.contact_id AND m.direction = 'I' AND m.created_on > s.created_on
+	)) AS responded
+FROM
+	unnest($1::int[], $2::text[]) a(contact_id, variant)
+CROSS JOIN
+	%s s
+WHERE
+	s.org_id = $3 AND s.id = $4
+GROUP BY
+	a.variant`
+
+const sqlSelectVariantRunStatuses = `
+SELECT
+	a.variant,
+	r.status,
+	count(*)
+FROM
+	unnest($1::int[], $2::text[]) a(contact_id, variant)
+INNER JOIN
+	flows_flowrun r ON r.start_id = $3 AND r.contact_id = a.contact_id
+GROUP BY
+	a.variant, r.status`
+
+const sqlSelectVariantRunResults = `
+SELECT
+	a.variant,
+	res.key,
+	COALESCE(res.value->>'category', ''),
+	count(*)
+FROM
+	unnest($1::int[], $2::text[]) a(contact_id, variant)
+INNER JOIN
+	flows_flowrun r ON r.start_id = $3 AND r.contact_id = a.contact_id
+CROSS JOIN LATERAL
+	jsonb_each(r.results::jsonb) res
+GROUP BY
+	a.variant, res.key, res.value->>'category'`
+
+// GetStartVariantStats gets the statistics of each variant of the given flow start, including those of the runs it
+// created for each variant's contacts
+func GetStartVariantStats(ctx context.Context, db Queryer, rc redis.Conn, orgID OrgID, startID StartID) ([]*VariantStats, error) {
+	contactIDs, names, err := loadVariantAssignments(rc, StartVariantsKey(startID))
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := loadVariantResponses(ctx, db, "flows_flowstart", orgID, startID, contactIDs, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return stats, nil
+	}
+
+	// the holdout group and variants whose contacts have no runs yet have empty run statistics
+	byName := make(map[string]*VariantStats, len(stats))
+	for _, s := range stats {
+		s.Runs = newRunStats()
+		byName[s.Name] = s
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlSelectVariantRunStatuses, pq.Array(contactIDs), pq.Array(names), startID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying variant run statuses")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var status RunStatus
+		var count int
+		if err := rows.Scan(&name, &status, &count); err != nil {
+			return nil, errors.Wrap(err, "error scanning variant run status")
+		}
+		if s := byName[name]; s != nil {
+			s.Runs.addStatus(status, count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading variant run statuses")
+	}
+
+	rows, err = db.QueryxContext(ctx, sqlSelectVariantRunResults, pq.Array(contactIDs), pq.Array(names), startID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying variant run results")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, key, category string
+		var count int
+		if err := rows.Scan(&name, &key, &category, &count); err != nil {
+			return nil, errors.Wrap(err, "error scanning variant run result")
+		}
+		if s := byName[name]; s != nil {
+			s.Runs.addResult(key, category, count)
+		}
+	}
+
+	return stats, errors.Wrap(rows.Err(), "error reading variant run results")
+}
+
+// GetBroadcastVariantStats gets the statistics of each variant of the given broadcast
+func GetBroadcastVariantStats(ctx context.Context, db Queryer, rc redis.Conn, orgID OrgID, broadcastID BroadcastID) ([]*VariantStats, error) {
+	contactIDs, names, err := loadVariantAssignments(rc, BroadcastVariantsKey(broadcastID))
+	if err != nil {
+		return nil, err
+	}
+
+	return loadVariantResponses(ctx, db, "msgs_broadcast", orgID, broadcastID, contactIDs, names)
+}
+
+// loads the variant assignments with the given key as parallel slices of contact ids and variant names, scanning the
+// hash so that no more than VariantStatsMaxContacts are loaded
+func loadVariantAssignments(rc redis.Conn, key string) ([]ContactID, []string, error) {
+	contactIDs := make([]ContactID, 0, 100)
+	names := make([]string, 0, 100)
+	cursor := 0
+
+	for {
+		values, err := redis.Values(rc.Do("HSCAN", key, cursor, "COUNT", 1000))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error reading variant assignments")
+		}
+
+		cursor, _ = redis.Int(values[0], nil)
+		assignments, _ := redis.StringMap(values[1], nil)
+
+		for id, name := range assignments {
+			if len(contactIDs) == VariantStatsMaxContacts {
+				return contactIDs, names, nil
+			}
+
+			contactID, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid contact id in variant assignments: %s", id)
+			}
+			contactIDs = append(contactIDs, ContactID(contactID))
+			names = append(names, name)
+		}
+
+		if cursor == 0 {
+			return contactIDs, names, nil
+		}
+	}
+}
+
+// loads the contact and response counts of each variant, sorted by name with the holdout group last
+func loadVariantResponses(ctx context.Context, db Queryer, table string, orgID OrgID, id interface{}, contactIDs []ContactID, names []string) ([]*VariantStats, error) {
+	stats := make([]*VariantStats, 0, 3)
+	if len(contactIDs) == 0 {
+		return stats, nil
+	}
+
+	rows, err := db.QueryxContext(ctx, fmt.Sprintf(sqlSelectVariantResponses, table), pq.Array(contactIDs), pq.Array(names), orgID, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying variant responses")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &VariantStats{}
+		if err := rows.Scan(&s.Name, &s.Contacts, &s.Responded); err != nil {
+			return nil, errors.Wrap(err, "error scanning variant responses")
+		}
+		if s.Contacts > 0 {
+			s.ResponseRate = float64(s.Responded) / float64(s.Contacts)
+		}
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading variant responses")
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if (stats[i].Name == VariantHoldout) != (stats[j].Name == VariantHoldout) {
+			return stats[j].Name == VariantHoldout
+		}
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats, nil
+}
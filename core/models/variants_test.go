@@ -0,0 +1,195 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVariants(t *testing.T) {
+	tcs := []struct {
+		variants []*models.Variant
+		err      string
+	}{
+		{[]*models.Variant{{Name: "A", Percent: 50}, {Name: "B", Percent: 50}}, ""},
+		{[]*models.Variant{{Name: "A", Percent: 45}, {Name: "B", Percent: 45}}, ""},
+		{[]*models.Variant{{Name: "A", Percent: 60}, {Name: "B", Percent: 50}}, "variant allocations add up to 110%"},
+		{[]*models.Variant{{Name: "A", Percent: 50}, {Name: "A", Percent: 50}}, "invalid variant name 'A'"},
+		{[]*models.Variant{{Name: "", Percent: 50}}, "invalid variant name ''"},
+		{[]*models.Variant{{Name: "holdout", Percent: 50}}, "invalid variant name 'holdout'"},
+		{[]*models.Variant{{Name: "A", Percent: 0}}, "variant 'A' must be allocated a positive percentage"},
+	}
+
+	for i, tc := range tcs {
+		err := models.ValidateVariants(tc.variants)
+		if tc.err == "" {
+			assert.NoError(t, err, "%d: unexpected error", i)
+		} else {
+			assert.EqualError(t, err, tc.err, "%d: error mismatch", i)
+		}
+	}
+}
+
+func TestAssignVariant(t *testing.T) {
+	a := &models.Variant{Name: "A", Percent: 40}
+	b := &models.Variant{Name: "B", Percent: 40}
+	variants := []*models.Variant{a, b}
+
+	tcs := []struct {
+		seed     string
+		contact  *testdata.Contact
+		expected *models.Variant
+	}{
+		{"start:1", testdata.Cathy, a},
+		{"start:1", testdata.Bob, b},
+		{"start:1", testdata.George, a},
+		{"start:1", testdata.Alexandria, b},
+		{"start:2", testdata.Cathy, nil}, // holdout
+		{"start:2", testdata.Bob, a},
+		{"start:2", testdata.George, nil},
+		{"start:2", testdata.Alexandria, a},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, models.AssignVariant(variants, tc.seed, tc.contact.UUID), "variant mismatch for %s with seed %s", tc.contact.UUID, tc.seed)
+	}
+}
+
+func TestAssignVariants(t *testing.T) {
+	ctx, _, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	startID := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, nil)
+	variants := []*models.Variant{{Name: "A", Percent: 40, FlowID: testdata.Favorites.ID}, {Name: "B", Percent: 40, FlowID: testdata.PickANumber.ID}}
+	contacts := []*testdata.Contact{testdata.Cathy, testdata.Bob, testdata.George, testdata.Alexandria}
+
+	assigned, err := models.AssignVariants(ctx, db, rc, startID, models.NilBroadcastID, variants, []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID, testdata.Alexandria.ID})
+	require.NoError(t, err)
+
+	// every contact has their assignment recorded, including those in the holdout group
+	recorded := make(map[string]string, len(contacts))
+	for _, c := range contacts {
+		expected := models.AssignVariant(variants, fmt.Sprintf("start:%d", startID), c.UUID)
+		assert.Equal(t, expected, assigned[c.ID])
+
+		name := models.VariantHoldout
+		if expected != nil {
+			name = expected.Name
+		}
+		recorded[fmt.Sprint(c.ID)] = name
+	}
+	assertredis.HGetAll(t, rp, fmt.Sprintf("variant_assignments:start:%d", startID), recorded)
+
+	// assigning again doesn't change assignments
+	_, err = models.AssignVariants(ctx, db, rc, startID, models.NilBroadcastID, variants, []models.ContactID{testdata.Cathy.ID})
+	require.NoError(t, err)
+	assertredis.HGetAll(t, rp, fmt.Sprintf("variant_assignments:start:%d", startID), recorded)
+
+	_, err = models.AssignVariants(ctx, db, rc, startID, models.NilBroadcastID, []*models.Variant{{Name: "A", Percent: 101}}, []models.ContactID{testdata.Cathy.ID})
+	assert.EqualError(t, err, "variant allocations add up to 101%")
+}
+
+func TestVariantStats(t *testing.T) {
+	ctx, _, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	startID := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, nil)
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, nil, nil)
+	db.MustExec(`UPDATE flows_flowstart SET created_on = NOW() - INTERVAL '1 hour' WHERE id = $1`, startID)
+	db.MustExec(`UPDATE msgs_broadcast SET created_on = NOW() - INTERVAL '1 hour' WHERE id = $1`, bcastID)
+
+	// Cathy and Bob get variant A of the start, George is in the holdout group
+	_, err := rc.Do("HSET", models.StartVariantsKey(startID), testdata.Cathy.ID, "A", testdata.Bob.ID, "A", testdata.George.ID, models.VariantHoldout)
+	require.NoError(t, err)
+
+	// Cathy completed her run and responded, Bob is still waiting
+	run1ID := testdata.InsertFlowRun(db, testdata.Org1, models.SessionID(0), testdata.Cathy, testdata.Favorites, models.RunStatusCompleted)
+	run2ID := testdata.InsertFlowRun(db, testdata.Org1, models.SessionID(0), testdata.Bob, testdata.Favorites, models.RunStatusWaiting)
+	db.MustExec(`UPDATE flows_flowrun SET start_id = $2, results = '{"color": {"name": "Color", "value": "red", "category": "Red"}}' WHERE id = $1`, run1ID, startID)
+	db.MustExec(`UPDATE flows_flowrun SET start_id = $2, results = '{}' WHERE id = $1`, run2ID, startID)
+	testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "red", models.MsgStatusHandled)
+
+	// runs of the same flow which weren't created by the start aren't included
+	run3ID := testdata.InsertFlowRun(db, testdata.Org1, models.SessionID(0), testdata.Bob, testdata.Favorites, models.RunStatusCompleted)
+	testdata.InsertFlowRun(db, testdata.Org1, models.SessionID(0), testdata.Alexandria, testdata.Favorites, models.RunStatusExpired)
+	db.MustExec(`UPDATE flows_flowrun SET results = '{"color": {"name": "Color", "value": "blue", "category": "Blue"}}' WHERE id = $1`, run3ID)
+
+	stats, err := models.GetStartVariantStats(ctx, db, rc, testdata.Org1.ID, startID)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.VariantStats{
+		{
+			Name:         "A",
+			Contacts:     2,
+			Responded:    1,
+			ResponseRate: 0.5,
+			Runs:         &models.RunStats{Total: 2, Active: 1, Completed: 1, Results: map[string]map[string]int{"color": {"Red": 1}}},
+		},
+		{
+			Name:     models.VariantHoldout,
+			Contacts: 1,
+			Runs:     &models.RunStats{Results: map[string]map[string]int{}},
+		},
+	}, stats)
+
+	// broadcast variants don't have runs
+	_, err = rc.Do("HSET", models.BroadcastVariantsKey(bcastID), testdata.Cathy.ID, "B", testdata.Alexandria.ID, "A")
+	require.NoError(t, err)
+
+	stats, err = models.GetBroadcastVariantStats(ctx, db, rc, testdata.Org1.ID, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.VariantStats{
+		{Name: "A", Contacts: 1, Responded: 0, ResponseRate: 0},
+		{Name: "B", Contacts: 1, Responded: 1, ResponseRate: 1},
+	}, stats)
+
+	// nothing for splits without assignments
+	stats, err = models.GetStartVariantStats(ctx, db, rc, testdata.Org1.ID, models.StartID(123456))
+	require.NoError(t, err)
+	assert.Equal(t, []*models.VariantStats{}, stats)
+	// large splits are compared using a sample of their contacts
+	args := redis.Args{}.Add(models.BroadcastVariantsKey(bcastID))
+	for i := 0; i < models.VariantStatsMaxContacts+100; i++ {
+		args = args.Add(100000+i, "A")
+	}
+	_, err = rc.Do("HSET", args...)
+	require.NoError(t, err)
+
+	stats, err = models.GetBroadcastVariantStats(ctx, db, rc, testdata.Org1.ID, bcastID)
+	require.NoError(t, err)
+
+	sampled := 0
+	for _, s := range stats {
+		sampled += s.Contacts
+	}
+	assert.Equal(t, models.VariantStatsMaxContacts, sampled)
+}
+
+func TestFlowStartForVariant(t *testing.T) {
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID}).
+		WithVariants([]*models.Variant{{Name: "A", Percent: 50, FlowID: testdata.PickANumber.ID}})
+
+	part := start.ForVariant(start.Variants()[0])
+	assert.Equal(t, testdata.PickANumber.ID, part.FlowID())
+	assert.Nil(t, part.Variants())
+	assert.Equal(t, []models.ContactID{testdata.Cathy.ID}, part.ContactIDs())
+
+	// original is unchanged
+	assert.Equal(t, testdata.Favorites.ID, start.FlowID())
+	assert.Len(t, start.Variants(), 1)
+}
@@ -0,0 +1,53 @@
+package msgio
+
+import (
+	"encoding/json"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/pkg/errors"
+)
+
+// CheckSendControl checks whether the broadcast or flow start with the given control key has been paused or cancelled
+// before the given batch task does any work. Tasks of paused sends are parked until the send is resumed. Returns
+// whether the task should go ahead.
+func CheckSendControl(rc redis.Conn, task *queue.Task, key string) (bool, error) {
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return false, errors.Wrapf(err, "error marshalling %s task", task.Type)
+	}
+
+	control, err := models.ParkPausedBatch(rc, key, payload)
+	if err != nil {
+		return false, err
+	}
+
+	return control == models.SendControlNone, nil
+}
+
+// ResumeSend resumes the paused broadcast or flow start with the given control key, putting the batch tasks which were
+// parked while it was paused back on the batch queue. Returns the number of tasks re-queued.
+func ResumeSend(rc redis.Conn, key string) (int, error) {
+	parked, err := models.ResumeSend(rc, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// parked tasks are no longer stored anywhere else so we try to re-queue all of them even if some fail
+	var lastErr error
+	requeued := 0
+
+	for _, payload := range parked {
+		task := &queue.Task{}
+		if err := json.Unmarshal(payload, task); err != nil {
+			lastErr = errors.Wrap(err, "error unmarshalling parked task")
+		} else if err := queue.RequeueTask(rc, queue.BatchQueue, task); err != nil {
+			lastErr = errors.Wrapf(err, "error re-queuing parked %s task", task.Type)
+		} else {
+			requeued++
+		}
+	}
+
+	return requeued, lastErr
+}
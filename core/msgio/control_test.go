@@ -0,0 +1,94 @@
+package msgio_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/redisx/assertredis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSendControl(t *testing.T) {
+	_, _, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	key := models.BroadcastControlKey(123)
+	task1 := &queue.Task{Type: queue.SendBroadcastBatch, OrgID: 1, Task: []byte(`{"broadcast_id": 123, "contact_ids": [1]}`)}
+	task2 := &queue.Task{Type: queue.SendBroadcastBatch, OrgID: 1, Task: []byte(`{"broadcast_id": 123, "contact_ids": [2]}`)}
+
+	assertControl := func(expected models.SendControl) {
+		control, err := models.GetSendControl(rc, key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, control)
+	}
+	assertCheck := func(task *queue.Task, expectedProceed bool) {
+		proceed, err := msgio.CheckSendControl(rc, task, key)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedProceed, proceed)
+	}
+	assertQueued := func(expected int) {
+		size, err := queue.Size(rc, queue.BatchQueue)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, size)
+	}
+
+	// tasks of sends without a flag go ahead
+	assertControl(models.SendControlNone)
+	assertCheck(task1, true)
+
+	// tasks of paused sends are parked, and pausing doesn't expire
+	assert.NoError(t, models.SetSendControl(rc, key, models.SendControlPause))
+	assertControl(models.SendControlPause)
+	assertCheck(task1, false)
+	assertCheck(task2, false)
+	assertredis.SCard(t, rp, key+":paused", 2)
+	assertredis.Exists(t, rp, key)
+
+	ttl, err := rc.Do("TTL", key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), ttl)
+
+	// until the send is resumed, when they're put back on the queue
+	requeued, err := msgio.ResumeSend(rc, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requeued)
+	assertControl(models.SendControlNone)
+	assertredis.NotExists(t, rp, key+":paused")
+	assertQueued(2)
+
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Equal(t, queue.SendBroadcastBatch, task.Type)
+
+	// and tasks of resumed sends go ahead
+	assertCheck(task1, true)
+
+	// resuming a send which isn't paused does nothing
+	requeued, err = msgio.ResumeSend(rc, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+
+	// tasks of cancelled sends are dropped, including those which were parked
+	assert.NoError(t, models.SetSendControl(rc, key, models.SendControlPause))
+	assertCheck(task1, false)
+	assert.NoError(t, models.SetSendControl(rc, key, models.SendControlCancel))
+	assertControl(models.SendControlCancel)
+	assertredis.NotExists(t, rp, key+":paused")
+	assertCheck(task2, false)
+	assertredis.NotExists(t, rp, key+":paused")
+
+	// and a cancelled send can't be resumed
+	requeued, err = msgio.ResumeSend(rc, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assertControl(models.SendControlCancel)
+	assertQueued(1)
+}
@@ -28,6 +28,11 @@ func SendMessages(ctx context.Context, rt *runtime.Runtime, tx models.Queryer, f
 			continue
 		}
 
+		// and any message being held until later (e.g. quiet hours), these will be sent when they're released
+		if msg.IsHeld() {
+			continue
+		}
+
 		channel := msg.Channel()
 		if channel != nil {
 			if channel.Type() == models.ChannelTypeAndroid {
@@ -3,8 +3,10 @@ package msgio_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/runtime"
@@ -19,6 +21,7 @@ type msgSpec struct {
 	Channel      *testdata.Channel
 	Contact      *testdata.Contact
 	Failed       bool
+	Held         bool
 	HighPriority bool
 }
 
@@ -28,7 +31,12 @@ func (m *msgSpec) createMsg(t *testing.T, rt *runtime.Runtime, oa *models.OrgAss
 		status = models.MsgStatusFailed
 	}
 
-	flowMsg := testdata.InsertOutgoingMsg(rt.DB, testdata.Org1, m.Channel, m.Contact, "Hello", nil, status, m.HighPriority)
+	var flowMsg *flows.MsgOut
+	if m.Held {
+		flowMsg = testdata.InsertHeldOutgoingMsg(rt.DB, testdata.Org1, m.Channel, m.Contact, "Hello", time.Now().Add(time.Hour))
+	} else {
+		flowMsg = testdata.InsertOutgoingMsg(rt.DB, testdata.Org1, m.Channel, m.Contact, "Hello", nil, status, m.HighPriority)
+	}
 	msgs, err := models.GetMessagesByID(context.Background(), rt.DB, testdata.Org1.ID, models.DirectionOut, []models.MsgID{models.MsgID(flowMsg.ID())})
 	require.NoError(t, err)
 
@@ -149,6 +157,24 @@ func TestSendMessages(t *testing.T) {
 			FCMTokensSynced: []string{},
 			PendingMsgs:     1,
 		},
+		{
+			Description: "messages being held are ignored",
+			Msgs: []msgSpec{
+				{
+					Channel: testdata.TwilioChannel,
+					Contact: testdata.Cathy,
+					Held:    true,
+				},
+				{
+					Channel: androidChannel1,
+					Contact: testdata.Bob,
+					Held:    true,
+				},
+			},
+			QueueSizes:      map[string][]int{},
+			FCMTokensSynced: []string{},
+			PendingMsgs:     3,
+		},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,136 @@
+package msgio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/pkg/errors"
+)
+
+const throttleKey = "send_throttle:%s"
+
+var reserveScript = redis.NewScript(1, `
+-- KEYS: [BucketKey] ARGV: [Rate, Burst, Count, Now]
+local key = KEYS[1]
+local rate, burst, count, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+
+-- a bucket we haven't seen before (or which has expired) starts out full
+local state = redis.call("HMGET", key, "tokens", "updated")
+local tokens, updated = tonumber(state[1]), tonumber(state[2])
+if not tokens then
+  tokens, updated = burst, now
+end
+
+-- refill the bucket for the time that has passed since it was last updated, then take our tokens which may leave it
+-- in debt, i.e. reserving tokens that will only be available in the future
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate) - count
+redis.call("HMSET", key, "tokens", tokens, "updated", now)
+redis.call("EXPIRE", key, math.ceil((burst - tokens) / rate) + 60)
+
+-- the wait in seconds until the bucket is out of debt
+if tokens < 0 then
+  return tostring(-tokens / rate)
+end
+return "0"
+`)
+
+// ReserveSends reserves the given number of sends from the token bucket of the given channel, returning how long the
+// caller should wait before sending them to respect the channel's send rate. Channels without a send rate are never
+// throttled.
+func ReserveSends(rc redis.Conn, channel *models.Channel, count int) (time.Duration, error) {
+	rate, burst := channel.SendRate()
+	if rate == 0 || count == 0 {
+		return 0, nil
+	}
+
+	now := float64(dates.Now().UnixNano()/int64(time.Microsecond)) / float64(1000000)
+
+	wait, err := redis.String(reserveScript.Do(rc, fmt.Sprintf(throttleKey, channel.UUID()), rate, burst, count, strconv.FormatFloat(now, 'f', 6, 64)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reserving sends for channel %s", channel.UUID())
+	}
+
+	secs, _ := strconv.ParseFloat(wait, 64)
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// ThrottleBatch reserves sends for a batch of contacts in a broadcast or flow start from the throttled channels which
+// could send to them, returning the longest wait. We don't know which channels the batch will be sent on until its
+// messages are created, so each sending channel reserves a send for every contact in the batch with a URN of one of
+// its schemes, as given by schemeCounts, up to the size of the batch.
+func ThrottleBatch(rc redis.Conn, oa *models.OrgAssets, schemeCounts map[string]int, size int) (time.Duration, error) {
+	channels, err := oa.Channels()
+	if err != nil {
+		return 0, errors.Wrap(err, "error loading channels")
+	}
+
+	var longest time.Duration
+
+	for _, c := range channels {
+		channel := c.(*models.Channel)
+		if !canSend(channel) {
+			continue
+		}
+
+		// a contact may have URNs of several of this channel's schemes but will only be sent one message
+		count := 0
+		for _, scheme := range channel.Schemes() {
+			count += schemeCounts[scheme]
+		}
+		if count > size {
+			count = size
+		}
+
+		wait, err := ReserveSends(rc, channel, count)
+		if err != nil {
+			return 0, err
+		}
+		if wait > longest {
+			longest = wait
+		}
+	}
+
+	return longest, nil
+}
+
+// QueueThrottledBatch queues a batch task of a broadcast or flow start, delaying it if necessary to respect the send
+// rates of the channels which could send to its contacts, and records it against the progress with the given key if
+// there is one
+func QueueThrottledBatch(ctx context.Context, rc redis.Conn, oa *models.OrgAssets, q, taskType string, batch interface{}, size int, schemeCounts map[string]int, progressKey string) error {
+	wait, err := ThrottleBatch(rc, oa, schemeCounts, size)
+	if err != nil {
+		return err
+	}
+
+	runAt := dates.Now().Add(wait)
+
+	if wait > 0 {
+		err = queue.AddDelayedTask(ctx, rc, q, taskType, int(oa.OrgID()), batch, runAt)
+	} else {
+		err = queue.AddTask(ctx, rc, q, taskType, int(oa.OrgID()), batch, queue.DefaultPriority)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error queuing %s task", taskType)
+	}
+
+	if progressKey != "" {
+		return models.RecordBatchQueued(rc, progressKey, size, runAt)
+	}
+	return nil
+}
+
+func canSend(channel *models.Channel) bool {
+	for _, role := range channel.Roles() {
+		if role == assets.ChannelRoleSend {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package msgio_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	now := time.Date(2021, 11, 10, 15, 0, 0, 0, time.UTC)
+	dates.SetNowSource(dates.NewFixedNowSource(now))
+
+	// twilio channel can send 10 msgs/sec with bursts of 20, vonage channel 5 msgs/sec
+	db.MustExec(`UPDATE channels_channel SET config = '{"send_rate": 10, "send_burst": 20}' WHERE id = $1`, testdata.TwilioChannel.ID)
+	db.MustExec(`UPDATE channels_channel SET config = '{"send_rate": 5}' WHERE id = $1`, testdata.VonageChannel.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	twilio := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+	twitter := oa.ChannelByUUID(testdata.TwitterChannel.UUID)
+
+	reserve := func(ch *models.Channel, count int) time.Duration {
+		wait, err := msgio.ReserveSends(rc, ch, count)
+		require.NoError(t, err)
+		return wait
+	}
+
+	// channels without a send rate are never throttled
+	assert.Equal(t, time.Duration(0), reserve(twitter, 1000))
+
+	// a new bucket starts out full so we can take a burst straight away
+	assert.Equal(t, time.Duration(0), reserve(twilio, 20))
+
+	// after that we have to wait for it to refill
+	assert.Equal(t, time.Second, reserve(twilio, 10))
+	assert.Equal(t, 1500*time.Millisecond, reserve(twilio, 5))
+
+	// time passing pays off our debt
+	dates.SetNowSource(dates.NewFixedNowSource(now.Add(1500 * time.Millisecond)))
+	assert.Equal(t, time.Second, reserve(twilio, 10))
+
+	// batches are throttled by the slowest of the org's channels which could be sending to them
+	dates.SetNowSource(dates.NewFixedNowSource(now))
+	testsuite.Reset(testsuite.ResetRedis)
+
+	wait, err := msgio.ThrottleBatch(rc, oa, map[string]int{"tel": 5}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	wait, err = msgio.ThrottleBatch(rc, oa, map[string]int{"tel": 10}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, wait) // vonage bucket only has a burst of 5
+
+	// batches whose contacts can't be sent to by the throttled channels don't reserve any of their sends
+	wait, err = msgio.ThrottleBatch(rc, oa, map[string]int{"twitter": 10}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// so the vonage bucket is still in the same debt
+	wait, err = msgio.ThrottleBatch(rc, oa, map[string]int{"tel": 5}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, wait)
+
+	// batches which don't need to wait are queued straight away, others are delayed until they can be sent
+	testsuite.Reset(testsuite.ResetRedis)
+
+	err = msgio.QueueThrottledBatch(ctx, rc, oa, queue.BatchQueue, queue.SendBroadcastBatch, map[string]int{"size": 5}, 5, map[string]int{"tel": 5}, "send_progress:broadcast:123")
+	assert.NoError(t, err)
+	err = msgio.QueueThrottledBatch(ctx, rc, oa, queue.BatchQueue, queue.SendBroadcastBatch, map[string]int{"size": 5}, 5, map[string]int{"tel": 5}, "send_progress:broadcast:123")
+	assert.NoError(t, err)
+
+	size, err := queue.Size(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	delayed, err := queue.DelayedSize(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delayed)
+
+	progress, err := models.GetSendProgress(rc, "send_progress:broadcast:123")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.SendProgress{Queued: 10, Handled: 0, ThrottledUntil: timeRef(now.Add(time.Second))}, progress)
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/pkg/errors"
+)
+
+const (
+	deadPattern      = "%s:dead"
+	deadTasksPattern = "%s:dead:tasks"
+)
+
+// DeadTask is a task which failed permanently and was moved to the dead letter queue of its queue
+type DeadTask struct {
+	ID       string    `json:"id"`
+	Task     *Task     `json:"task"`
+	Error    string    `json:"error"`
+	FailedOn time.Time `json:"failed_on"`
+}
+
+// DeadLetterTask moves the passed in failed task to the dead letter queue for the given queue
+func DeadLetterTask(rc redis.Conn, queue string, task *Task, taskErr error) error {
+	dead := &DeadTask{
+		ID:       string(uuids.New()),
+		Task:     task,
+		Error:    taskErr.Error(),
+		FailedOn: dates.Now(),
+	}
+
+	jsonPayload, err := json.Marshal(dead)
+	if err != nil {
+		return err
+	}
+
+	rc.Send("hset", fmt.Sprintf(deadTasksPattern, queue), dead.ID, jsonPayload)
+	rc.Send("zadd", fmt.Sprintf(deadPattern, queue), scoreFor(dead.FailedOn, DefaultPriority), dead.ID)
+	_, err = rc.Do("")
+	return err
+}
+
+// DeadLetterSize returns the number of tasks in the dead letter queue for the given queue
+func DeadLetterSize(rc redis.Conn, queue string) (int, error) {
+	return redis.Int(rc.Do("zcard", fmt.Sprintf(deadPattern, queue)))
+}
+
+// ListDeadTasks returns up to count dead tasks for the given queue, oldest first, starting at offset
+func ListDeadTasks(rc redis.Conn, queue string, offset, count int) ([]*DeadTask, error) {
+	ids, err := redis.Strings(rc.Do("zrange", fmt.Sprintf(deadPattern, queue), offset, offset+count-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing dead tasks for: %s", queue)
+	}
+	if len(ids) == 0 {
+		return []*DeadTask{}, nil
+	}
+
+	args := redis.Args{}.Add(fmt.Sprintf(deadTasksPattern, queue)).AddFlat(ids)
+	payloads, err := redis.ByteSlices(rc.Do("hmget", args...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching dead tasks for: %s", queue)
+	}
+
+	tasks := make([]*DeadTask, 0, len(payloads))
+	for _, payload := range payloads {
+		if payload == nil {
+			continue
+		}
+		dead := &DeadTask{}
+		if err := json.Unmarshal(payload, dead); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling dead task")
+		}
+		tasks = append(tasks, dead)
+	}
+
+	return tasks, nil
+}
+
+// GetDeadTask returns the dead task with the given id, or nil if no such task exists
+func GetDeadTask(rc redis.Conn, queue string, id string) (*DeadTask, error) {
+	payload, err := redis.Bytes(rc.Do("hget", fmt.Sprintf(deadTasksPattern, queue), id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching dead task %s", id)
+	}
+
+	dead := &DeadTask{}
+	return dead, json.Unmarshal(payload, dead)
+}
+
+var removeDeadTask = redis.NewScript(2, `-- KEYS: [DeadSet] [DeadTasks] ARGV: [TaskID]
+	local payload = redis.call("hget", KEYS[2], ARGV[1])
+	if not payload then
+		return ""
+	end
+
+	redis.call("hdel", KEYS[2], ARGV[1])
+	redis.call("zrem", KEYS[1], ARGV[1])
+	return payload
+`)
+
+// ReplayDeadTask removes the dead task with the given id from the dead letter queue and adds it back to its queue
+// with its error count reset. Returns false if no such task exists.
+func ReplayDeadTask(rc redis.Conn, queue string, id string) (bool, error) {
+	payload, err := redis.Bytes(removeDeadTask.Do(rc, fmt.Sprintf(deadPattern, queue), fmt.Sprintf(deadTasksPattern, queue), id))
+	if err != nil {
+		return false, errors.Wrapf(err, "error removing dead task %s", id)
+	}
+	if len(payload) == 0 {
+		return false, nil
+	}
+
+	dead := &DeadTask{}
+	if err := json.Unmarshal(payload, dead); err != nil {
+		return false, errors.Wrapf(err, "error unmarshalling dead task %s", id)
+	}
+
+	// replayed task continues the trace of the original
+	ctx := dead.Task.Context(context.Background())
+
+	return true, AddTask(ctx, rc, queue, dead.Task.Type, dead.Task.OrgID, dead.Task.Task, DefaultPriority)
+}
+
+// PurgeDeadTask deletes the dead task with the given id. Returns false if no such task exists.
+func PurgeDeadTask(rc redis.Conn, queue string, id string) (bool, error) {
+	payload, err := redis.Bytes(removeDeadTask.Do(rc, fmt.Sprintf(deadPattern, queue), fmt.Sprintf(deadTasksPattern, queue), id))
+	if err != nil {
+		return false, errors.Wrapf(err, "error removing dead task %s", id)
+	}
+	return len(payload) > 0, nil
+}
+
+// PurgeDeadTasks deletes all dead tasks for the given queue which failed before the given time, returning the
+// number of tasks deleted
+func PurgeDeadTasks(rc redis.Conn, queue string, before time.Time) (int, error) {
+	ids, err := redis.Strings(rc.Do("zrangebyscore", fmt.Sprintf(deadPattern, queue), "-inf", "("+scoreFor(before, DefaultPriority)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error listing dead tasks for: %s", queue)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	rc.Send("hdel", redis.Args{}.Add(fmt.Sprintf(deadTasksPattern, queue)).AddFlat(ids)...)
+	rc.Send("zrem", redis.Args{}.Add(fmt.Sprintf(deadPattern, queue)).AddFlat(ids)...)
+	_, err = rc.Do("")
+	return len(ids), err
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	require.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2", "test:dead", "test:dead:tasks")
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task1", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 2, "task2", DefaultPriority))
+
+	task1, _ := PopNextTask(rc, "test")
+	task2, _ := PopNextTask(rc, "test")
+
+	task1.ErrorCount = 3
+	assert.NoError(t, DeadLetterTask(rc, "test", task1, errors.New("boom")))
+	assert.NoError(t, DeadLetterTask(rc, "test", task2, errors.New("bang")))
+
+	size, err := DeadLetterSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	dead, err := ListDeadTasks(rc, "test", 0, 10)
+	assert.NoError(t, err)
+	require.Len(t, dead, 2)
+	assert.Equal(t, "boom", dead[0].Error)
+	assert.Equal(t, 1, dead[0].Task.OrgID)
+	assert.Equal(t, 3, dead[0].Task.ErrorCount)
+	assert.Equal(t, "bang", dead[1].Error)
+
+	dead, err = ListDeadTasks(rc, "test", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+
+	// inspect a single task
+	d, err := GetDeadTask(rc, "test", dead[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "bang", d.Error)
+
+	d, err = GetDeadTask(rc, "test", "xyz")
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	// replay the first task, which should put it back on the queue with no errors
+	all, _ := ListDeadTasks(rc, "test", 0, 10)
+	replayed, err := ReplayDeadTask(rc, "test", all[0].ID)
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+
+	replayed, err = ReplayDeadTask(rc, "test", all[0].ID)
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, 1, task.OrgID)
+		assert.Equal(t, 0, task.ErrorCount)
+		assert.Equal(t, `"task1"`, string(task.Task))
+	}
+
+	size, _ = DeadLetterSize(rc, "test")
+	assert.Equal(t, 1, size)
+
+	// purging with a time before the failure does nothing
+	purged, err := PurgeDeadTasks(rc, "test", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	purged, err = PurgeDeadTasks(rc, "test", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	size, _ = DeadLetterSize(rc, "test")
+	assert.Equal(t, 0, size)
+
+	// purge a single task
+	assert.NoError(t, DeadLetterTask(rc, "test", task2, errors.New("bang")))
+	all, _ = ListDeadTasks(rc, "test", 0, 10)
+
+	removed, err := PurgeDeadTask(rc, "test", all[0].ID)
+	assert.NoError(t, err)
+	assert.True(t, removed)
+
+	removed, err = PurgeDeadTask(rc, "test", all[0].ID)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// OrgQueue is the state of a single org's queue within a queue
+type OrgQueue struct {
+	OrgID  int        `json:"org_id"`
+	Size   int        `json:"size"`
+	Active int        `json:"active"`
+	Limits *OrgLimits `json:"limits"`
+	Next   *Task      `json:"next"`
+}
+
+// OrgQueues returns the state of each org's queue within the given queue, that is the number of queued tasks, the
+// number of workers currently active on that org, its limits and the task at the head of its queue
+func OrgQueues(rc redis.Conn, queue string) ([]*OrgQueue, error) {
+	values, err := redis.Strings(rc.Do("zrange", fmt.Sprintf(activePattern, queue), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting active queues for: %s", queue)
+	}
+
+	limits, err := GetOrgLimits(rc, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := make([]*OrgQueue, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		orgID, _ := strconv.Atoi(values[i])
+		active, _ := strconv.ParseFloat(values[i+1], 64)
+		orgQueue := fmt.Sprintf(queuePattern, queue, orgID)
+
+		size, err := redis.Int(rc.Do("zcard", orgQueue))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting size of: %s", orgQueue)
+		}
+
+		next, err := PeekTasks(rc, queue, orgID, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		o := &OrgQueue{OrgID: orgID, Size: size, Active: int(active), Limits: limits[orgID]}
+		if o.Limits == nil {
+			o.Limits = &OrgLimits{Weight: 1}
+		}
+		if len(next) > 0 {
+			o.Next = next[0]
+		}
+		orgs = append(orgs, o)
+	}
+
+	return orgs, nil
+}
+
+// PeekTasks returns up to count tasks from the given org's queue, in the order they will be popped, starting at offset
+func PeekTasks(rc redis.Conn, queue string, orgID int, offset, count int) ([]*Task, error) {
+	payloads, err := redis.ByteSlices(rc.Do("zrange", fmt.Sprintf(queuePattern, queue, orgID), offset, offset+count-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error peeking tasks for org %d in: %s", orgID, queue)
+	}
+
+	tasks := make([]*Task, len(payloads))
+	for i, payload := range payloads {
+		tasks[i] = &Task{}
+		if err := json.Unmarshal(payload, tasks[i]); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling task")
+		}
+	}
+	return tasks, nil
+}
+
+// DrainOrgQueue removes all queued tasks for the given org, returning the number of tasks removed. Tasks which
+// are already being worked on are unaffected.
+func DrainOrgQueue(rc redis.Conn, queue string, orgID int) (int, error) {
+	orgQueue := fmt.Sprintf(queuePattern, queue, orgID)
+
+	rc.Send("zcard", orgQueue)
+	rc.Send("del", orgQueue)
+	values, err := redis.Ints(rc.Do(""))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error draining: %s", orgQueue)
+	}
+	return values[0], nil
+}
+
+// PrioritizeOrgQueue changes the priority of all queued tasks for the given org, returning the number of tasks
+// updated. Tasks keep their order relative to when they were queued, but any which are later requeued after being
+// interrupted go back to the priority they were originally queued with.
+func PrioritizeOrgQueue(rc redis.Conn, queue string, orgID int, priority Priority) (int, error) {
+	orgQueue := fmt.Sprintf(queuePattern, queue, orgID)
+
+	payloads, err := redis.ByteSlices(rc.Do("zrange", orgQueue, 0, -1))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading tasks from: %s", orgQueue)
+	}
+
+	for _, payload := range payloads {
+		task := &Task{}
+		if err := json.Unmarshal(payload, task); err != nil {
+			return 0, errors.Wrapf(err, "error unmarshalling task")
+		}
+
+		// only update tasks which haven't been popped in the meantime
+		rc.Send("zadd", orgQueue, "XX", scoreFor(task.QueuedOn, priority), payload)
+	}
+
+	if _, err := rc.Do(""); err != nil {
+		return 0, errors.Wrapf(err, "error updating task priorities in: %s", orgQueue)
+	}
+
+	return len(payloads), nil
+}
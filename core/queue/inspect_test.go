@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	require.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2")
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task1", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task2", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 2, "task3", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task4", DefaultPriority))
+
+	// pop a task for org 2 so it has an active worker
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 2, "task5", HighPriority))
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, task.OrgID)
+
+	orgs, err := OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	require.Len(t, orgs, 2)
+
+	byOrg := map[int]*OrgQueue{orgs[0].OrgID: orgs[0], orgs[1].OrgID: orgs[1]}
+	assert.Equal(t, 2, byOrg[1].Size)
+	assert.Equal(t, 1, byOrg[1].Active)
+	assert.Equal(t, `"task2"`, string(byOrg[1].Next.Task))
+	assert.Equal(t, 2, byOrg[2].Size)
+	assert.Equal(t, 0, byOrg[2].Active)
+	assert.Equal(t, `"task5"`, string(byOrg[2].Next.Task))
+
+	tasks, err := PeekTasks(rc, "test", 2, 0, 10)
+	assert.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, `"task5"`, string(tasks[0].Task))
+	assert.Equal(t, `"task3"`, string(tasks[1].Task))
+
+	// move org 2's tasks back to default priority, so they're back in the order they were queued
+	updated, err := PrioritizeOrgQueue(rc, "test", 2, DefaultPriority)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated)
+
+	tasks, err = PeekTasks(rc, "test", 2, 0, 10)
+	assert.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, `"task3"`, string(tasks[0].Task))
+	assert.Equal(t, `"task5"`, string(tasks[1].Task))
+
+	// drain org 1's queue
+	drained, err := DrainOrgQueue(rc, "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, drained)
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	// draining an empty queue is a noop
+	drained, err = DrainOrgQueue(rc, "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, drained)
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	capsPattern    = "%s:caps"
+	weightsPattern = "%s:weights"
+)
+
+// OrgLimits are the limits on how many workers an org can use within a queue
+type OrgLimits struct {
+	// MaxWorkers is the maximum number of workers that can work on the org's tasks at once, zero meaning no limit
+	MaxWorkers int `json:"max_workers"`
+
+	// Weight is the org's share of workers relative to other orgs, e.g. an org with weight 2 gets twice the workers
+	// of an org with the default weight of 1 when both have queued tasks
+	Weight float64 `json:"weight"`
+}
+
+// SetOrgLimits sets the limits for the given org within the given queue. Setting limits of zero max workers and
+// a weight of 1 restores the defaults.
+func SetOrgLimits(rc redis.Conn, queue string, orgID int, limits *OrgLimits) error {
+	if limits.MaxWorkers < 0 {
+		return errors.Errorf("max workers can't be negative")
+	}
+	if limits.Weight <= 0 {
+		return errors.Errorf("weight must be greater than zero")
+	}
+
+	org := strconv.Itoa(orgID)
+
+	if limits.MaxWorkers > 0 {
+		rc.Send("hset", fmt.Sprintf(capsPattern, queue), org, limits.MaxWorkers)
+	} else {
+		rc.Send("hdel", fmt.Sprintf(capsPattern, queue), org)
+	}
+
+	if limits.Weight != 1 {
+		rc.Send("hset", fmt.Sprintf(weightsPattern, queue), org, strconv.FormatFloat(limits.Weight, 'f', -1, 64))
+	} else {
+		rc.Send("hdel", fmt.Sprintf(weightsPattern, queue), org)
+	}
+
+	_, err := rc.Do("")
+	return err
+}
+
+// GetOrgLimits returns the limits for all orgs in the given queue which have non-default limits
+func GetOrgLimits(rc redis.Conn, queue string) (map[int]*OrgLimits, error) {
+	caps, err := redis.IntMap(rc.Do("hgetall", fmt.Sprintf(capsPattern, queue)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading org caps for: %s", queue)
+	}
+	weights, err := redis.StringMap(rc.Do("hgetall", fmt.Sprintf(weightsPattern, queue)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading org weights for: %s", queue)
+	}
+
+	limits := make(map[int]*OrgLimits, len(caps)+len(weights))
+	get := func(org string) *OrgLimits {
+		orgID, _ := strconv.Atoi(org)
+		if limits[orgID] == nil {
+			limits[orgID] = &OrgLimits{Weight: 1}
+		}
+		return limits[orgID]
+	}
+
+	for org, maxWorkers := range caps {
+		get(org).MaxWorkers = maxWorkers
+	}
+	for org, weight := range weights {
+		get(org).Weight, _ = strconv.ParseFloat(weight, 64)
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgLimits(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	require.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2", "test:3", "test:caps", "test:weights")
+
+	assert.EqualError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{MaxWorkers: -1, Weight: 1}), "max workers can't be negative")
+	assert.EqualError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{MaxWorkers: 0, Weight: 0}), "weight must be greater than zero")
+
+	assert.NoError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{MaxWorkers: 2, Weight: 1}))
+	assert.NoError(t, SetOrgLimits(rc, "test", 2, &OrgLimits{MaxWorkers: 0, Weight: 0.5}))
+
+	limits, err := GetOrgLimits(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]*OrgLimits{1: {MaxWorkers: 2, Weight: 1}, 2: {MaxWorkers: 0, Weight: 0.5}}, limits)
+
+	// restoring defaults removes the org's limits
+	assert.NoError(t, SetOrgLimits(rc, "test", 2, &OrgLimits{MaxWorkers: 0, Weight: 1}))
+
+	limits, err = GetOrgLimits(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]*OrgLimits{1: {MaxWorkers: 2, Weight: 1}}, limits)
+}
+
+func TestOrgCaps(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	require.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2", "test:caps", "test:weights")
+
+	// org 1 can only have one worker at a time
+	assert.NoError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{MaxWorkers: 1, Weight: 1}))
+
+	for _, task := range []string{"task1", "task2", "task3"} {
+		assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, task, DefaultPriority))
+	}
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 2, "task4", DefaultPriority))
+
+	pop := func() string {
+		task, err := PopNextTask(rc, "test")
+		require.NoError(t, err)
+		if task == nil {
+			return ""
+		}
+		return string(task.Task)
+	}
+
+	assert.Equal(t, `"task1"`, pop())
+	assert.Equal(t, `"task4"`, pop())
+
+	// org 1 is at its cap and org 2 has nothing left
+	assert.Equal(t, "", pop())
+	assert.Equal(t, "", pop())
+
+	// once org 1's task completes we can pop its next one
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+	assert.Equal(t, `"task2"`, pop())
+	assert.Equal(t, "", pop())
+
+	// org 2 finishing doesn't help org 1
+	assert.NoError(t, MarkTaskComplete(rc, "test", 2))
+	assert.Equal(t, "", pop())
+
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+	assert.Equal(t, `"task3"`, pop())
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+	assert.Equal(t, "", pop())
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+}
+
+func TestOrgWeights(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	require.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2", "test:3", "test:caps", "test:weights")
+
+	// org 1 gets twice the workers of org 2, and org 3 half
+	assert.NoError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{Weight: 2}))
+	assert.NoError(t, SetOrgLimits(rc, "test", 3, &OrgLimits{Weight: 0.5}))
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task", DefaultPriority))
+		assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 2, "task", DefaultPriority))
+		assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 3, "task", DefaultPriority))
+	}
+
+	// pop 7 tasks without completing any of them
+	popped := map[int]int{}
+	for i := 0; i < 7; i++ {
+		task, err := PopNextTask(rc, "test")
+		require.NoError(t, err)
+		popped[task.OrgID]++
+	}
+
+	assert.Equal(t, map[int]int{1: 4, 2: 2, 3: 1}, popped)
+
+	// completing org 1 tasks means it continues to get more workers
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	task, err := PopNextTask(rc, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 1, task.OrgID)
+
+	// with no limits set, we go back to equal numbers of workers per org
+	assert.NoError(t, SetOrgLimits(rc, "test", 1, &OrgLimits{Weight: 1}))
+	assert.NoError(t, SetOrgLimits(rc, "test", 3, &OrgLimits{Weight: 1}))
+
+	// org 1 has 3 workers, org 2 has 2, org 3 has 1
+	task, _ = PopNextTask(rc, "test")
+	assert.Equal(t, 3, task.OrgID)
+	task, _ = PopNextTask(rc, "test")
+	assert.Equal(t, 2, task.OrgID)
+	task, _ = PopNextTask(rc, "test")
+	assert.Equal(t, 3, task.OrgID)
+}
@@ -1,13 +1,17 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // Task is a utility struct for encoding a task
@@ -17,14 +21,24 @@ type Task struct {
 	Task       json.RawMessage `json:"task"`
 	QueuedOn   time.Time       `json:"queued_on"`
 	ErrorCount int             `json:"error_count,omitempty"`
+	Priority   Priority        `json:"priority,omitempty"`
+
+	// the trace context of whatever queued this task so that handling it continues the same trace
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// Context returns a copy of the given context which carries the trace context this task was queued with, if any
+func (t *Task) Context(ctx context.Context) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(t.TraceContext))
 }
 
 // Priority is the priority for the task
 type Priority int
 
 const (
-	queuePattern  = "%s:%d"
-	activePattern = "%s:active"
+	queuePattern   = "%s:%d"
+	activePattern  = "%s:active"
+	delayedPattern = "%s:delayed"
 
 	// DefaultPriority is the default priority for tasks
 	DefaultPriority = Priority(0)
@@ -81,40 +95,165 @@ func Size(rc redis.Conn, queue string) (int, error) {
 	return size, nil
 }
 
+// scoreFor returns the sorted set score for a task runnable at the given time with the given priority
+func scoreFor(t time.Time, priority Priority) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000)+float64(priority), 'f', 6, 64)
+}
+
 // AddTask adds the passed in task to our queue for execution
-func AddTask(rc redis.Conn, queue string, taskType string, orgID int, task interface{}, priority Priority) error {
-	score := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000)+float64(priority), 'f', 6, 64)
+func AddTask(ctx context.Context, rc redis.Conn, queue string, taskType string, orgID int, task interface{}, priority Priority) error {
+	now := dates.Now()
 
-	taskBody, err := json.Marshal(task)
+	jsonPayload, err := newPayload(ctx, taskType, orgID, task, now, priority)
 	if err != nil {
 		return err
 	}
 
-	payload := &Task{
-		Type:     taskType,
-		OrgID:    orgID,
-		Task:     taskBody,
-		QueuedOn: time.Now(),
+	rc.Send("zadd", fmt.Sprintf(queuePattern, queue, orgID), scoreFor(now, priority), jsonPayload)
+	rc.Send("zincrby", fmt.Sprintf(activePattern, queue), 0, orgID)
+	_, err = rc.Do("")
+	return err
+}
+
+// AddDelayedTask adds the passed in task to our queue but it won't become runnable until the given time
+func AddDelayedTask(ctx context.Context, rc redis.Conn, queue string, taskType string, orgID int, task interface{}, runAt time.Time) error {
+	jsonPayload, err := newPayload(ctx, taskType, orgID, task, dates.Now(), DefaultPriority)
+	if err != nil {
+		return err
 	}
-	jsonPayload, err := json.Marshal(payload)
+
+	_, err = rc.Do("zadd", fmt.Sprintf(delayedPattern, queue), scoreFor(runAt, DefaultPriority), jsonPayload)
+	return err
+}
+
+// DelayedSize returns the number of tasks for the passed in queue which are waiting to become runnable
+func DelayedSize(rc redis.Conn, queue string) (int, error) {
+	return redis.Int(rc.Do("zcard", fmt.Sprintf(delayedPattern, queue)))
+}
+
+// RetryTask re-queues the passed in task so that it becomes runnable again once the given delay has passed
+func RetryTask(rc redis.Conn, queue string, task *Task, delay time.Duration) error {
+	jsonPayload, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
 
-	rc.Send("zadd", fmt.Sprintf(queuePattern, queue, orgID), score, jsonPayload)
-	rc.Send("zincrby", fmt.Sprintf(activePattern, queue), 0, orgID)
+	_, err = rc.Do("zadd", fmt.Sprintf(delayedPattern, queue), scoreFor(dates.Now().Add(delay), DefaultPriority), jsonPayload)
+	return err
+}
+
+// RequeueTask puts the passed in task back on its org's queue, unchanged, e.g. because it was interrupted. It is
+// scored by when it was originally queued and with its original priority so it will be handled ahead of tasks queued
+// since.
+func RequeueTask(rc redis.Conn, queue string, task *Task) error {
+	jsonPayload, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	rc.Send("zadd", fmt.Sprintf(queuePattern, queue, task.OrgID), scoreFor(task.QueuedOn, task.Priority), jsonPayload)
+	rc.Send("zincrby", fmt.Sprintf(activePattern, queue), 0, task.OrgID)
 	_, err = rc.Do("")
 	return err
 }
 
-var popTask = redis.NewScript(1, `-- KEYS: [QueueName]
-    -- first get what is the active queue
-	local result = redis.call("zrange", KEYS[1] .. ":active", 0, 0, "WITHSCORES")
+func newPayload(ctx context.Context, taskType string, orgID int, task interface{}, queuedOn time.Time, priority Priority) ([]byte, error) {
+	taskBody, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+
+	// record the trace context, if any, of whatever is queueing this task
+	traceContext := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, traceContext)
+	if len(traceContext) == 0 {
+		traceContext = nil
+	}
+
+	return json.Marshal(&Task{
+		Type:         taskType,
+		OrgID:        orgID,
+		Task:         taskBody,
+		QueuedOn:     queuedOn,
+		Priority:     priority,
+		TraceContext: traceContext,
+	})
+}
+
+// RetryDelay returns the delay before retrying a task which has failed the given number of times, doubling
+// the initial delay with each failure up to a maximum of one hour
+func RetryDelay(initial time.Duration, errorCount int) time.Duration {
+	delay := initial
+	for i := 1; i < errorCount && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+var promoteDelayed = redis.NewScript(1, `-- KEYS: [QueueName] ARGV: [Now]
+	local delayed = KEYS[1] .. ":delayed"
+	local due = redis.call("zrangebyscore", delayed, "-inf", ARGV[1], "WITHSCORES")
+
+	for i = 1, #due, 2 do
+		local payload = due[i]
+		local group = tostring(cjson.decode(payload)["org_id"])
+
+		-- move to the org queue, keeping the score so that due tasks are handled in the order they became due
+		redis.call("zadd", KEYS[1] .. ":" .. group, due[i + 1], payload)
+		redis.call("zincrby", KEYS[1] .. ":active", 0, group)
+		redis.call("zrem", delayed, payload)
+	end
 
-	-- nothing? return nothing
-	local group = result[1]
-	if not group then
-		return {"empty", ""}
+	return #due / 2
+`)
+
+var popTask = redis.NewScript(1, `-- KEYS: [QueueName]
+	local active = KEYS[1] .. ":active"
+	local caps = KEYS[1] .. ":caps"
+	local weights = KEYS[1] .. ":weights"
+	local group = nil
+
+	if redis.call("exists", caps) == 0 and redis.call("exists", weights) == 0 then
+		-- no org limits, so the active queue is the one with the fewest workers
+		local result = redis.call("zrange", active, 0, 0)
+
+		-- nothing? return nothing
+		group = result[1]
+		if not group then
+			return {"empty", ""}
+		end
+	else
+		-- otherwise it's the queue with the fewest workers relative to its weight that isn't at its cap
+		local result = redis.call("zrange", active, 0, -1, "WITHSCORES")
+		local lowest = nil
+
+		for i = 1, #result, 2 do
+			local org = result[i]
+			local workers = tonumber(result[i + 1])
+			local cap = tonumber(redis.call("hget", caps, org)) or 0
+			local weight = tonumber(redis.call("hget", weights, org)) or 1
+
+			if cap <= 0 or workers < cap then
+				if redis.call("zcard", KEYS[1] .. ":" .. org) > 0 then
+					local load = workers / weight
+					if lowest == nil or load < lowest then
+						lowest = load
+						group = org
+					end
+				elseif workers <= 0 then
+					-- nothing queued and nothing running, remove this group from active queues
+					redis.call("zrem", active, org)
+				end
+			end
+		end
+
+		-- nothing queued for any org under its cap? return nothing
+		if not group then
+			return {"empty", ""}
+		end
 	end
 
 	local queue = KEYS[1] .. ":" .. group
@@ -128,19 +267,23 @@ var popTask = redis.NewScript(1, `-- KEYS: [QueueName]
 		redis.call('zremrangebyrank', queue, 0, 0)
 
 		-- and add a worker to this queue
-		redis.call("zincrby", KEYS[1] .. ":active", 1, group)
+		redis.call("zincrby", active, 1, group)
 
 		return {group, result[1]}
 	else
 		-- no result found, remove this group from active queues
-		redis.call("zrem", KEYS[1] .. ":active", group)
+		redis.call("zrem", active, group)
 
 		return {"retry", ""}
 	end
 `)
 
-// PopNextTask pops the next task off our queue
+// PopNextTask pops the next task off our queue, first promoting any delayed tasks or retries which are now due
 func PopNextTask(rc redis.Conn, queue string) (*Task, error) {
+	if _, err := promoteDelayed.Do(rc, queue, scoreFor(dates.Now(), DefaultPriority)); err != nil {
+		return nil, errors.Wrapf(err, "error promoting delayed tasks for: %s", queue)
+	}
+
 	task := Task{}
 	for {
 		values, err := redis.Strings(popTask.Do(rc, queue))
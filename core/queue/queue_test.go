@@ -1,14 +1,20 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestQueues(t *testing.T) {
+	ctx := context.Background()
 	rc, err := redis.Dial("tcp", "localhost:6379")
 	assert.NoError(t, err)
 	rc.Do("del", "test:active", "test:1", "test:2", "test:3")
@@ -67,7 +73,7 @@ func TestQueues(t *testing.T) {
 		} else if tc.Priority == markCompletePriority {
 			assert.NoError(t, MarkTaskComplete(rc, tc.Queue, tc.TaskGroup))
 		} else {
-			assert.NoError(t, AddTask(rc, tc.Queue, tc.TaskType, tc.TaskGroup, tc.Task, tc.Priority))
+			assert.NoError(t, AddTask(ctx, rc, tc.Queue, tc.TaskType, tc.TaskGroup, tc.Task, tc.Priority))
 		}
 
 		size, err := Size(rc, tc.Queue)
@@ -75,3 +81,184 @@ func TestQueues(t *testing.T) {
 		assert.Equal(t, tc.Size, size, "%d: mismatch", i)
 	}
 }
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:delayed")
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task1", DefaultPriority))
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	// retry the task with a delay, it shouldn't be poppable until that passes
+	task.ErrorCount++
+	assert.NoError(t, RetryTask(rc, "test", task, time.Millisecond*200))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	time.Sleep(time.Millisecond * 250)
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, 1, task.OrgID)
+		assert.Equal(t, 1, task.ErrorCount)
+		assert.Equal(t, `"task1"`, string(task.Task))
+	}
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+}
+
+func TestDelayedTasks(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:2", "test:delayed")
+
+	now := time.Now()
+
+	assert.NoError(t, AddDelayedTask(ctx, rc, "test", "send_broadcast", 1, "task1", now.Add(time.Millisecond*400)))
+	assert.NoError(t, AddDelayedTask(ctx, rc, "test", "send_broadcast", 2, "task2", now.Add(time.Millisecond*200)))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task3", DefaultPriority))
+
+	delayed, err := DelayedSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delayed)
+
+	// only the non-delayed task is runnable
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, `"task3"`, string(task.Task))
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	time.Sleep(time.Millisecond * 250)
+
+	// now the earlier of the delayed tasks is due
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, "send_broadcast", task.Type)
+		assert.Equal(t, 2, task.OrgID)
+		assert.Equal(t, `"task2"`, string(task.Task))
+	}
+	assert.NoError(t, MarkTaskComplete(rc, "test", 2))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	time.Sleep(time.Millisecond * 200)
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, 1, task.OrgID)
+		assert.Equal(t, `"task1"`, string(task.Task))
+	}
+
+	delayed, err = DelayedSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delayed)
+}
+
+func TestRequeueTask(t *testing.T) {
+	ctx := context.Background()
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1")
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task1", DefaultPriority))
+
+	task1, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task2", DefaultPriority))
+
+	// requeue the interrupted task, it should go ahead of the task queued after it
+	assert.NoError(t, RequeueTask(rc, "test", task1))
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, task1, task)
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, `"task2"`, string(task.Task))
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	// requeued tasks keep their original priority
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task3", HighPriority))
+
+	task3, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, HighPriority, task3.Priority)
+
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task4", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task5", HighPriority))
+
+	assert.NoError(t, RequeueTask(rc, "test", task3))
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	for _, expected := range []string{`"task3"`, `"task5"`, `"task4"`} {
+		task, err = PopNextTask(rc, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(task.Task))
+		assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+	}
+}
+
+func TestTaskTraceContext(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1")
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+
+	// task queued without a trace has no trace context
+	assert.NoError(t, AddTask(context.Background(), rc, "test", "campaign", 1, "task1", DefaultPriority))
+	assert.NoError(t, AddTask(ctx, rc, "test", "campaign", 1, "task2", DefaultPriority))
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task.TraceContext)
+	assert.False(t, trace.SpanContextFromContext(task.Context(context.Background())).IsValid())
+	assert.NoError(t, MarkTaskComplete(rc, "test", 1))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, task.TraceContext)
+
+	// handling the task continues the same trace
+	spanContext := trace.SpanContextFromContext(task.Context(context.Background()))
+	assert.Equal(t, traceID, spanContext.TraceID())
+	assert.Equal(t, spanID, spanContext.SpanID())
+	assert.True(t, spanContext.IsRemote())
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second*10, RetryDelay(time.Second*10, 1))
+	assert.Equal(t, time.Second*20, RetryDelay(time.Second*10, 2))
+	assert.Equal(t, time.Second*40, RetryDelay(time.Second*10, 3))
+	assert.Equal(t, time.Hour, RetryDelay(time.Second*10, 20))
+}
@@ -20,18 +20,27 @@ var registeredTypes = map[string](func() Task){}
 func RegisterType(name string, initFunc func() Task) {
 	registeredTypes[name] = initFunc
 
-	mailroom.AddTaskFunction(name, func(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error {
-		// decode our task body
-		typedTask, err := ReadTask(task.Type, task.Task)
-		if err != nil {
-			return errors.Wrapf(err, "error reading task of type %s", task.Type)
-		}
-
-		ctx, cancel := context.WithTimeout(ctx, typedTask.Timeout())
-		defer cancel()
-
-		return typedTask.Perform(ctx, rt, models.OrgID(task.OrgID))
-	})
+	mailroom.AddTaskFunction(name, performTask)
+}
+
+// RegisterRetryableType registers a new type of task which is idempotent and so can be retried if it fails
+func RegisterRetryableType(name string, initFunc func() Task) {
+	registeredTypes[name] = initFunc
+
+	mailroom.AddRetryableTaskFunction(name, performTask)
+}
+
+func performTask(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error {
+	// decode our task body
+	typedTask, err := ReadTask(task.Type, task.Task)
+	if err != nil {
+		return errors.Wrapf(err, "error reading task of type %s", task.Type)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, typedTask.Timeout())
+	defer cancel()
+
+	return typedTask.Perform(ctx, rt, models.OrgID(task.OrgID))
 }
 
 // Task is the common interface for all task types
@@ -80,7 +80,7 @@ func QueueEventFires(ctx context.Context, rt *runtime.Runtime) error {
 
 		// if not, queue up current task...
 		if task != nil {
-			err = queueFiresTask(rt.RP, orgID, task)
+			err = queueFiresTask(ctx, rt.RP, orgID, task)
 			if err != nil {
 				return errors.Wrapf(err, "error queueing task")
 			}
@@ -101,7 +101,7 @@ func QueueEventFires(ctx context.Context, rt *runtime.Runtime) error {
 
 	// queue our last task if we have one
 	if task != nil {
-		if err := queueFiresTask(rt.RP, orgID, task); err != nil {
+		if err := queueFiresTask(ctx, rt.RP, orgID, task); err != nil {
 			return errors.Wrapf(err, "error queueing task")
 		}
 		numTasks++
@@ -118,11 +118,11 @@ func QueueEventFires(ctx context.Context, rt *runtime.Runtime) error {
 	return nil
 }
 
-func queueFiresTask(rp *redis.Pool, orgID models.OrgID, task *FireCampaignEventTask) error {
+func queueFiresTask(ctx context.Context, rp *redis.Pool, orgID models.OrgID, task *FireCampaignEventTask) error {
 	rc := rp.Get()
 	defer rc.Close()
 
-	err := queue.AddTask(rc, queue.BatchQueue, TypeFireCampaignEvent, int(orgID), task, queue.DefaultPriority)
+	err := queue.AddTask(ctx, rc, queue.BatchQueue, TypeFireCampaignEvent, int(orgID), task, queue.DefaultPriority)
 	if err != nil {
 		return errors.Wrap(err, "error queuing task")
 	}
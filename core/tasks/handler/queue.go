@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -38,18 +39,19 @@ func queueHandleTask(rc redis.Conn, contactID models.ContactID, task *queue.Task
 		return errors.Wrapf(err, "error adding contact event")
 	}
 
-	return queueContactTask(rc, models.OrgID(task.OrgID), contactID)
+	// the handle task continues the trace, if any, of the event
+	return queueContactTask(task.Context(context.Background()), rc, models.OrgID(task.OrgID), contactID)
 }
 
 // pushes a single contact task on our queue. Note this does not push the actual content of the task
 // only that a task exists for the contact, addHandleTask should be used if the task has already been pushed
 // off the contact specific queue.
-func queueContactTask(rc redis.Conn, orgID models.OrgID, contactID models.ContactID) error {
+func queueContactTask(ctx context.Context, rc redis.Conn, orgID models.OrgID, contactID models.ContactID) error {
 	// create our contact event
 	contactTask := &HandleEventTask{ContactID: contactID}
 
 	// then add a handle task for that contact on our global handler queue
-	err := queue.AddTask(rc, queue.HandlerQueue, queue.HandleContactEvent, int(orgID), contactTask, queue.DefaultPriority)
+	err := queue.AddTask(ctx, rc, queue.HandlerQueue, queue.HandleContactEvent, int(orgID), contactTask, queue.DefaultPriority)
 	if err != nil {
 		return errors.Wrapf(err, "error adding handle event task")
 	}
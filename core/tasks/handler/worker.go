@@ -23,9 +23,13 @@ import (
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/core/runner"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/metrics"
+	"github.com/nyaruka/mailroom/utils/tracing"
 	"github.com/nyaruka/null"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -40,6 +44,11 @@ const (
 	TicketClosedEventType    = "ticket_closed"
 )
 
+var (
+	eventDuration = metrics.NewHistogram("mailroom_handler_event_duration_seconds", "the time taken to handle contact events", metrics.DefaultBuckets, "type")
+	eventLatency  = metrics.NewHistogram("mailroom_handler_event_latency_seconds", "the time from contact events being queued to being handled", metrics.DefaultBuckets, "type")
+)
+
 func init() {
 	mailroom.AddTaskFunction(queue.HandleContactEvent, HandleEvent)
 }
@@ -72,7 +81,7 @@ func handleContactEvent(ctx context.Context, rt *runtime.Runtime, task *queue.Ta
 	if lock == "" {
 		rc := rt.RP.Get()
 		defer rc.Close()
-		err = queueContactTask(rc, models.OrgID(task.OrgID), eventTask.ContactID)
+		err = queueContactTask(ctx, rc, models.OrgID(task.OrgID), eventTask.ContactID)
 		if err != nil {
 			return errors.Wrapf(err, "error re-adding contact task after failing to get lock")
 		}
@@ -111,6 +120,16 @@ func handleContactEvent(ctx context.Context, rt *runtime.Runtime, task *queue.Ta
 			return errors.Wrapf(err, "error unmarshalling contact event: %s", event)
 		}
 
+		// trace handling of this event as part of this task, linked to the trace of whatever queued the event
+		eventCtx, span := tracing.Tracer().Start(ctx, "event "+contactEvent.Type,
+			trace.WithLinks(trace.LinkFromContext(contactEvent.Context(context.Background()))),
+			trace.WithAttributes(attribute.Int64("contact_id", int64(eventTask.ContactID))),
+		)
+		endSpan := func(err error) error {
+			tracing.EndSpan(span, err)
+			return err
+		}
+
 		// hand off to the appropriate handler
 		switch contactEvent.Type {
 
@@ -118,51 +137,55 @@ func handleContactEvent(ctx context.Context, rt *runtime.Runtime, task *queue.Ta
 			evt := &StopEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
-				return errors.Wrapf(err, "error unmarshalling stop event: %s", event)
+				return endSpan(errors.Wrapf(err, "error unmarshalling stop event: %s", event))
 			}
-			err = handleStopEvent(ctx, rt, evt)
+			err = handleStopEvent(eventCtx, rt, evt)
 
 		case NewConversationEventType, ReferralEventType, MOMissEventType, WelcomeMessageEventType:
 			evt := &models.ChannelEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
-				return errors.Wrapf(err, "error unmarshalling channel event: %s", event)
+				return endSpan(errors.Wrapf(err, "error unmarshalling channel event: %s", event))
 			}
-			_, err = HandleChannelEvent(ctx, rt, models.ChannelEventType(contactEvent.Type), evt, nil)
+			_, err = HandleChannelEvent(eventCtx, rt, models.ChannelEventType(contactEvent.Type), evt, nil)
 
 		case MsgEventType:
 			msg := &MsgEvent{}
 			err = json.Unmarshal(contactEvent.Task, msg)
 			if err != nil {
-				return errors.Wrapf(err, "error unmarshalling msg event: %s", event)
+				return endSpan(errors.Wrapf(err, "error unmarshalling msg event: %s", event))
 			}
-			err = handleMsgEvent(ctx, rt, msg)
+			err = handleMsgEvent(eventCtx, rt, msg)
 
 		case TicketClosedEventType:
 			evt := &models.TicketEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
-				return errors.Wrapf(err, "error unmarshalling ticket event: %s", event)
+				return endSpan(errors.Wrapf(err, "error unmarshalling ticket event: %s", event))
 			}
-			err = handleTicketEvent(ctx, rt, evt)
+			err = handleTicketEvent(eventCtx, rt, evt)
 
 		case TimeoutEventType, ExpirationEventType:
 			evt := &TimedEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
-				return errors.Wrapf(err, "error unmarshalling timeout event: %s", event)
+				return endSpan(errors.Wrapf(err, "error unmarshalling timeout event: %s", event))
 			}
-			err = handleTimedEvent(ctx, rt, contactEvent.Type, evt)
+			err = handleTimedEvent(eventCtx, rt, contactEvent.Type, evt)
 
 		default:
-			return errors.Errorf("unknown contact event type: %s", contactEvent.Type)
+			return endSpan(errors.Errorf("unknown contact event type: %s", contactEvent.Type))
 		}
 
-		// log our processing time to librato
+		tracing.EndSpan(span, err)
+
+		// log our processing time to librato and prometheus
 		analytics.Gauge(fmt.Sprintf("mr.%s_elapsed", contactEvent.Type), float64(time.Since(start))/float64(time.Second))
+		eventDuration.ObserveDuration(time.Since(start), contactEvent.Type)
 
 		// and total latency for this task since it was queued
 		analytics.Gauge(fmt.Sprintf("mr.%s_latency", contactEvent.Type), float64(time.Since(task.QueuedOn))/float64(time.Second))
+		eventLatency.ObserveDuration(time.Since(task.QueuedOn), contactEvent.Type)
 
 		// if we get an error processing an event, requeue it for later and return our error
 		if err != nil {
@@ -15,7 +15,7 @@ import (
 const TypeInterruptSessions = "interrupt_sessions"
 
 func init() {
-	tasks.RegisterType(TypeInterruptSessions, func() tasks.Task { return &InterruptSessionsTask{} })
+	tasks.RegisterRetryableType(TypeInterruptSessions, func() tasks.Task { return &InterruptSessionsTask{} })
 }
 
 // InterruptSessionsTask is our task for interrupting sessions
@@ -8,6 +8,7 @@ import (
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/pkg/errors"
@@ -29,6 +30,14 @@ func handleFlowStartTask(ctx context.Context, rt *runtime.Runtime, task *queue.T
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
+	// check whether this start has been paused or cancelled
+	rc := rt.RP.Get()
+	proceed, err := msgio.CheckSendControl(rc, task, models.StartControlKey(batch.StartID()))
+	rc.Close()
+	if !proceed {
+		return err
+	}
+
 	return HandleFlowStartBatch(ctx, rt, batch)
 }
 
@@ -0,0 +1,60 @@
+package msgs
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	releaseBatchSize = 5000
+
+	// stop releasing before the cron's lock expires and leave anything left for the next run
+	releaseTimeLimit = time.Minute * 4
+)
+
+func init() {
+	mailroom.RegisterCron("release_held_messages", time.Second*60, false, ReleaseHeldMessages)
+}
+
+// ReleaseHeldMessages sends any outgoing messages which were held by quiet hours and whose window has now ended. These
+// are released in batches until nothing more is due, so that a large broadcast goes out as soon as its window opens.
+func ReleaseHeldMessages(ctx context.Context, rt *runtime.Runtime) error {
+	start := time.Now()
+	released := 0
+
+	for time.Since(start) < releaseTimeLimit {
+		msgs, err := models.GetHeldMessagesForRelease(ctx, rt.DB, releaseBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "error fetching held messages to release")
+		}
+		if len(msgs) == 0 {
+			break // nothing more to release
+		}
+
+		err = models.MarkMessagesQueued(ctx, rt.DB, msgs)
+		if err != nil {
+			return errors.Wrap(err, "error marking messages as queued")
+		}
+
+		msgio.SendMessages(ctx, rt, rt.DB, nil, msgs)
+
+		released += len(msgs)
+
+		if len(msgs) < releaseBatchSize {
+			break // that was the last batch
+		}
+	}
+
+	if released > 0 {
+		logrus.WithField("count", released).WithField("elapsed", time.Since(start)).Info("released held messages")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package msgs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/msgs"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseHeldMessages(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	// nothing to release
+	err := msgs.ReleaseHeldMessages(ctx, rt)
+	require.NoError(t, err)
+
+	testsuite.AssertCourierQueues(t, map[string][]int{})
+
+	// a pending message without a release time (should be ignored)
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", nil, models.MsgStatusPending, false)
+
+	// a held message which isn't due to be released yet (should be ignored)
+	testdata.InsertHeldOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", time.Now().Add(time.Hour))
+
+	// held messages which are due to be released
+	testdata.InsertHeldOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", time.Now().Add(-time.Minute))
+	testdata.InsertHeldOutgoingMsg(db, testdata.Org1, testdata.VonageChannel, testdata.Bob, "Hi", time.Now().Add(-time.Minute))
+
+	err = msgs.ReleaseHeldMessages(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE status = 'P'`).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE status = 'Q'`).Returns(2)
+
+	testsuite.AssertCourierQueues(t, map[string][]int{
+		"msgs:74729f45-7f29-4868-9dc4-90e491e3c7d8|10/0": {1}, // twilio, bulk priority
+		"msgs:19012bfd-3ce3-4cae-9bb9-76cf92c73d49|10/0": {1}, // vonage, bulk priority
+	})
+}
+
+func TestReleaseHeldMessagesInBatches(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	// more held messages are due than can be released in one batch
+	msg := testdata.InsertHeldOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", time.Now().Add(-time.Minute))
+	db.MustExec(
+		`INSERT INTO msgs_msg(uuid, text, attachments, created_on, direction, status, visibility, contact_id, contact_urn_id, org_id, channel_id, msg_count, error_count, next_attempt, high_priority)
+		 SELECT md5(random()::text)::uuid, text, attachments, created_on, direction, status, visibility, contact_id, contact_urn_id, org_id, channel_id, msg_count, error_count, next_attempt, high_priority
+		   FROM msgs_msg, generate_series(1, 5000) WHERE id = $1`, msg.ID(),
+	)
+
+	// they're all released by a single run
+	err := msgs.ReleaseHeldMessages(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE status = 'P'`).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE status = 'Q'`).Returns(5001)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/core/models"
@@ -39,6 +40,11 @@ func handleSendBroadcast(ctx context.Context, rt *runtime.Runtime, task *queue.T
 		return errors.Wrapf(err, "error unmarshalling broadcast: %s", string(task.Task))
 	}
 
+	// check whether this broadcast (or the part of it for a timezone) has been paused or cancelled
+	if proceed, err := checkBroadcastControl(rt, task, broadcast.ID()); !proceed {
+		return err
+	}
+
 	return CreateBroadcastBatches(ctx, rt, broadcast)
 }
 
@@ -66,8 +72,10 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 		return errors.Wrapf(err, "error getting contact ids for urns")
 	}
 
-	urnContacts := make(map[models.ContactID]urns.URN)
-	repeatedContacts := make(map[models.ContactID]urns.URN)
+	// broadcasts delivered at a local time are split by timezone, with each part being queued until that time
+	if bcast.DeliverAt() != "" {
+		return scheduleLocalDelivery(ctx, rt, oa, bcast, contactIDs, urnMap)
+	}
 
 	q := queue.BatchQueue
 
@@ -76,6 +84,22 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 		q = queue.HandlerQueue
 	}
 
+	// broadcasts split into variants send each contact the translations of the variant they're assigned to, and nothing
+	// to those in the holdout group
+	if len(bcast.Variants()) > 0 && bcast.ID() != models.NilBroadcastID {
+		return queueVariantBatches(ctx, rt, oa, q, bcast, contactIDs, urnMap)
+	}
+
+	return queueBroadcastBatches(ctx, rt, oa, q, bcast, contactIDs, urnMap, true)
+}
+
+// queues batches to send the given broadcast to the given contacts and URNs. If this is the last part of a broadcast,
+// its final batch is marked as the last so that the broadcast is marked as sent once it's been handled. All parts of a
+// broadcast must use the same queue so that the last part's batch can't be handled before those of earlier parts.
+func queueBroadcastBatches(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, q string, bcast *models.Broadcast, contactIDs map[models.ContactID]bool, urnMap map[urns.URN]models.ContactID, lastPart bool) error {
+	urnContacts := make(map[models.ContactID]urns.URN)
+	repeatedContacts := make(map[models.ContactID]urns.URN)
+
 	// we want to remove contacts that are also present in URN sends, these will be a special case in our last batch
 	for u, id := range urnMap {
 		if contactIDs[id] {
@@ -88,6 +112,12 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 	rc := rt.RP.Get()
 	defer rc.Close()
 
+	// broadcasts created by flows don't have ids so can't have their progress tracked
+	progressKey := ""
+	if bcast.ID() != models.NilBroadcastID {
+		progressKey = models.BroadcastProgressKey(bcast.ID())
+	}
+
 	contacts := make([]models.ContactID, 0, 100)
 
 	// utility functions for queueing the current set of contacts
@@ -103,11 +133,21 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 
 		// also set our URNs
 		if isLast {
-			batch.IsLast = true
+			batch.IsLast = lastPart
 			batch.URNs = urnContacts
 		}
 
-		err = queue.AddTask(rc, q, queue.SendBroadcastBatch, int(bcast.OrgID()), batch, queue.DefaultPriority)
+		// batches are spread out over time if the channels which can send to them have send rates
+		schemeCounts, err := models.CountContactURNSchemes(ctx, rt.DB, batch.ContactIDs)
+		if err != nil {
+			logrus.WithError(err).Error("error counting URN schemes of broadcast batch")
+			schemeCounts = make(map[string]int)
+		}
+		for _, u := range batch.URNs {
+			schemeCounts[u.Scheme()]++
+		}
+
+		err = msgio.QueueThrottledBatch(ctx, rc, oa, q, queue.SendBroadcastBatch, batch, len(batch.ContactIDs)+len(batch.URNs), schemeCounts, progressKey)
 		if err != nil {
 			logrus.WithError(err).Error("error while queuing broadcast batch")
 		}
@@ -122,8 +162,131 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 		contacts = append(contacts, c)
 	}
 
-	// queue our last batch
-	queueBatch(true)
+	// queue our last batch, which for the last part is queued even if empty so that the broadcast is marked as sent
+	if lastPart || len(contacts) > 0 || len(urnContacts) > 0 {
+		queueBatch(true)
+	}
+
+	return nil
+}
+
+// assigns the contacts of a broadcast split into variants to those variants, and queues batches for each variant
+func queueVariantBatches(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, q string, bcast *models.Broadcast, contactIDs map[models.ContactID]bool, urnMap map[urns.URN]models.ContactID) error {
+	allContactIDs := make([]models.ContactID, 0, len(contactIDs)+len(urnMap))
+	for id := range contactIDs {
+		allContactIDs = append(allContactIDs, id)
+	}
+	for _, id := range urnMap {
+		if !contactIDs[id] {
+			allContactIDs = append(allContactIDs, id)
+		}
+	}
+
+	rc := rt.RP.Get()
+	assigned, err := models.AssignVariants(ctx, rt.DB, rc, models.NilStartID, bcast.ID(), bcast.Variants(), allContactIDs)
+	rc.Close()
+	if err != nil {
+		return errors.Wrap(err, "error assigning broadcast variants")
+	}
+
+	variants := bcast.Variants()
+	for i, v := range variants {
+		variantContactIDs := make(map[models.ContactID]bool)
+		for id := range contactIDs {
+			if assigned[id] == v {
+				variantContactIDs[id] = true
+			}
+		}
+		variantURNs := make(map[urns.URN]models.ContactID)
+		for u, id := range urnMap {
+			if assigned[id] == v {
+				variantURNs[u] = id
+			}
+		}
+
+		err := queueBroadcastBatches(ctx, rt, oa, q, bcast.ForVariant(v), variantContactIDs, variantURNs, i == len(variants)-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splits a broadcast being delivered at a local time of day into a broadcast for each timezone of its contacts, and
+// queues each of those to be sent when that time arrives in that timezone
+func scheduleLocalDelivery(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, bcast *models.Broadcast, contactIDs map[models.ContactID]bool, urnMap map[urns.URN]models.ContactID) error {
+	deliverAt, err := dates.ParseTimeOfDay("tt:mm", bcast.DeliverAt())
+	if err != nil {
+		return errors.Wrapf(err, "invalid broadcast delivery time '%s'", bcast.DeliverAt())
+	}
+
+	allContactIDs := make([]models.ContactID, 0, len(contactIDs)+len(urnMap))
+	for id := range contactIDs {
+		allContactIDs = append(allContactIDs, id)
+	}
+	for _, id := range urnMap {
+		if !contactIDs[id] {
+			allContactIDs = append(allContactIDs, id)
+		}
+	}
+
+	timezones, err := models.LoadContactTimezones(ctx, rt.DB, oa, allContactIDs, bcast.TimezoneField())
+	if err != nil {
+		return errors.Wrap(err, "error loading contact timezones")
+	}
+
+	// bucket our contacts and URNs by the name of their timezone
+	locations := make(map[string]*time.Location)
+	bucketContacts := make(map[string][]models.ContactID)
+	bucketURNs := make(map[string][]urns.URN)
+
+	for id := range contactIDs {
+		if tz := timezones[id]; tz != nil {
+			locations[tz.String()] = tz
+			bucketContacts[tz.String()] = append(bucketContacts[tz.String()], id)
+		}
+	}
+	for u, id := range urnMap {
+		if tz := timezones[id]; tz != nil {
+			locations[tz.String()] = tz
+			bucketURNs[tz.String()] = append(bucketURNs[tz.String()], u)
+		}
+	}
+
+	// nobody to send to, we're done
+	if len(locations) == 0 {
+		return models.MarkBroadcastSent(ctx, rt.DB, bcast.ID())
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	now := dates.Now()
+	deliverOns := make(map[string]time.Time, len(locations))
+	for name, tz := range locations {
+		deliverOns[name] = models.NextLocalDelivery(deliverAt, tz, now)
+	}
+
+	// record all our timezones as pending before queuing any of them, so that the broadcast is only marked as sent once
+	// all of them have been delivered
+	if bcast.ID() != models.NilBroadcastID {
+		for name, deliverOn := range deliverOns {
+			err := models.RecordTimezoneScheduled(rc, models.BroadcastProgressKey(bcast.ID()), name, len(bucketContacts[name])+len(bucketURNs[name]), deliverOn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, deliverOn := range deliverOns {
+		part := bcast.ForTimezone(name, bucketContacts[name], bucketURNs[name])
+
+		err := queue.AddDelayedTask(ctx, rc, queue.BatchQueue, queue.SendBroadcast, int(bcast.OrgID()), part, deliverOn)
+		if err != nil {
+			return errors.Wrapf(err, "error queuing broadcast for timezone %s", name)
+		}
+	}
 
 	return nil
 }
@@ -143,6 +306,10 @@ func handleSendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, task *qu
 		return errors.Wrapf(err, "error unmarshalling broadcast: %s", string(task.Task))
 	}
 
+	if proceed, err := checkBroadcastControl(rt, task, broadcast.BroadcastID); !proceed {
+		return err
+	}
+
 	// try to send the batch
 	return SendBroadcastBatch(ctx, rt, broadcast)
 }
@@ -152,7 +319,7 @@ func SendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, bcast *models.
 	// always set our broadcast as sent if it is our last
 	defer func() {
 		if bcast.IsLast {
-			err := models.MarkBroadcastSent(ctx, rt.DB, bcast.BroadcastID)
+			err := markBroadcastSent(ctx, rt, bcast)
 			if err != nil {
 				logrus.WithError(err).Error("error marking broadcast as sent")
 			}
@@ -171,5 +338,53 @@ func SendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, bcast *models.
 	}
 
 	msgio.SendMessages(ctx, rt, rt.DB, nil, msgs)
+
+	if bcast.BroadcastID != models.NilBroadcastID {
+		rc := rt.RP.Get()
+		defer rc.Close()
+
+		if err := models.RecordBatchHandled(rc, models.BroadcastProgressKey(bcast.BroadcastID), len(bcast.ContactIDs)+len(bcast.URNs)); err != nil {
+			logrus.WithError(err).Error("error recording broadcast progress")
+		}
+	}
+
 	return nil
 }
+
+// checks whether the given broadcast has been paused or cancelled, returning whether the task should go ahead
+func checkBroadcastControl(rt *runtime.Runtime, task *queue.Task, broadcastID models.BroadcastID) (bool, error) {
+	if broadcastID == models.NilBroadcastID {
+		return true, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	proceed, err := msgio.CheckSendControl(rc, task, models.BroadcastControlKey(broadcastID))
+	if err != nil {
+		return false, err
+	}
+	if !proceed {
+		logrus.WithField("broadcast_id", broadcastID).WithField("task_type", task.Type).Info("skipping task of paused or cancelled broadcast")
+	}
+	return proceed, nil
+}
+
+// marks the broadcast of the given batch as sent, unless it's being delivered by timezone and other timezones are still
+// pending
+func markBroadcastSent(ctx context.Context, rt *runtime.Runtime, bcast *models.BroadcastBatch) error {
+	if bcast.Timezone != "" && bcast.BroadcastID != models.NilBroadcastID {
+		rc := rt.RP.Get()
+		defer rc.Close()
+
+		remaining, err := models.RecordTimezoneDelivered(rc, models.BroadcastProgressKey(bcast.BroadcastID), bcast.Timezone)
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return nil
+		}
+	}
+
+	return models.MarkBroadcastSent(ctx, rt.DB, bcast.BroadcastID)
+}
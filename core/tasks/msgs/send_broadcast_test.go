@@ -2,10 +2,13 @@ package msgs_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
@@ -17,8 +20,10 @@ import (
 	"github.com/nyaruka/mailroom/core/tasks/msgs"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBroadcastEvents(t *testing.T) {
@@ -270,6 +275,10 @@ func TestBroadcastTask(t *testing.T) {
 		if tc.BroadcastID != models.NilBroadcastID {
 			assertdb.Query(t, db, `SELECT count(*) FROM msgs_broadcast WHERE id = $1 AND status = 'S'`, tc.BroadcastID).
 				Returns(1, "%d: broadcast not marked as sent", i)
+
+			progress, err := models.GetSendProgress(rc, models.BroadcastProgressKey(tc.BroadcastID))
+			assert.NoError(t, err)
+			assert.Equal(t, &models.SendProgress{Queued: tc.MsgCount, Handled: tc.MsgCount}, progress, "%d: progress mismatch", i)
 		}
 
 		// if we had a ticket, make sure its replied_on and last_activity_on were updated
@@ -289,3 +298,194 @@ func TestBroadcastTask(t *testing.T) {
 
 	assertdb.Query(t, db, `SELECT SUM(count) FROM tickets_ticketdailytiming WHERE count_type = 'R' AND scope = CONCAT('o:', $1::text)`, testdata.Org1.ID).Returns(1)
 }
+
+func TestBroadcastThrottling(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// twilio channel can only be sent to at 50 msgs/sec
+	db.MustExec(`UPDATE channels_channel SET config = '{"send_rate": 50}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "hello world"}, models.NilScheduleID, nil, []*testdata.Group{testdata.DoctorsGroup})
+
+	translations := map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "hello world"}}
+	bcast := models.NewBroadcast(testdata.Org1.ID, bcastID, translations, models.TemplateStateEvaluated, "eng", nil, nil, []models.GroupID{testdata.DoctorsGroup.ID}, models.NilTicketID, models.NilUserID)
+
+	err := msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	assert.NoError(t, err)
+
+	// both batches exceed what the channel can send in a second so both are delayed
+	size, err := queue.Size(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	delayed, err := queue.DelayedSize(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delayed)
+
+	progress, err := models.GetSendProgress(rc, models.BroadcastProgressKey(bcastID))
+	assert.NoError(t, err)
+	assert.Equal(t, 121, progress.Queued)
+	assert.Equal(t, 0, progress.Handled)
+	assert.NotNil(t, progress.ThrottledUntil)
+}
+
+func TestBroadcastLocalDelivery(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	// 15:00 UTC is 08:00 in Cathy's timezone (America/Denver, from her URN) and 17:00 in Rwanda
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2021, 11, 10, 15, 0, 0, 0, time.UTC)))
+
+	denver, _ := time.LoadLocation("America/Denver")
+	kigali, _ := time.LoadLocation("Africa/Kigali")
+
+	// create a contact whose timezone is in their gender field
+	rwandan := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID("a393abc0-283d-4c9b-a1b3-641a035c34bf"), "Ann", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(db, testdata.Org1, rwandan, urns.URN("tel:+16055745555"), 1000)
+	db.MustExec(`UPDATE contacts_contact SET fields = jsonb_build_object($2::text, jsonb_build_object('text', 'Africa/Kigali')) WHERE id = $1`, rwandan.ID, testdata.GenderField.UUID)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "good morning"}, models.NilScheduleID, []*testdata.Contact{testdata.Cathy, rwandan}, nil)
+
+	translations := map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "good morning"}}
+	bcast := models.NewBroadcast(testdata.Org1.ID, bcastID, translations, models.TemplateStateEvaluated, "eng", nil, []models.ContactID{testdata.Cathy.ID, rwandan.ID}, nil, models.NilTicketID, models.NilUserID)
+	bcast.WithLocalDelivery("09:00", "gender")
+
+	err := msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	require.NoError(t, err)
+
+	// broadcast should have been split into a part for each timezone, each of which is delayed until 09:00 there
+	delayed, err := queue.DelayedSize(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delayed)
+
+	progress, err := models.GetSendProgress(rc, models.BroadcastProgressKey(bcastID))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(progress.PendingTimezones))
+	assert.Equal(t, 1, progress.PendingTimezones["America/Denver"].Contacts)
+	assert.True(t, time.Date(2021, 11, 10, 9, 0, 0, 0, denver).Equal(progress.PendingTimezones["America/Denver"].DeliverOn))
+	assert.Equal(t, 1, progress.PendingTimezones["Africa/Kigali"].Contacts)
+	assert.True(t, time.Date(2021, 11, 11, 9, 0, 0, 0, kigali).Equal(progress.PendingTimezones["Africa/Kigali"].DeliverOn))
+
+	sendPart := func() {
+		task, err := queue.PopNextTask(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		assert.Equal(t, queue.SendBroadcast, task.Type)
+
+		part := &models.Broadcast{}
+		jsonx.MustUnmarshal(task.Task, part)
+
+		err = msgs.CreateBroadcastBatches(ctx, rt, part)
+		require.NoError(t, err)
+
+		task, err = queue.PopNextTask(rc, queue.HandlerQueue)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+
+		batch := &models.BroadcastBatch{}
+		jsonx.MustUnmarshal(task.Task, batch)
+
+		err = msgs.SendBroadcastBatch(ctx, rt, batch)
+		require.NoError(t, err)
+	}
+
+	// nothing to do until it's 09:00 in Denver
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2021, 11, 10, 16, 0, 0, 0, time.UTC)))
+	sendPart()
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1`, bcastID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1 AND contact_id = $2`, bcastID, testdata.Cathy.ID).Returns(1)
+
+	// broadcast is only partially delivered so isn't marked as sent
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("P")
+
+	progress, err = models.GetSendProgress(rc, models.BroadcastProgressKey(bcastID))
+	require.NoError(t, err)
+	assert.Equal(t, 1, progress.Handled)
+	assert.Equal(t, 1, len(progress.PendingTimezones))
+	assert.NotNil(t, progress.PendingTimezones["Africa/Kigali"])
+
+	// then it's 09:00 in Rwanda
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)))
+	sendPart()
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1`, bcastID).Returns(2)
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("S")
+
+	progress, err = models.GetSendProgress(rc, models.BroadcastProgressKey(bcastID))
+	require.NoError(t, err)
+	assert.Equal(t, 2, progress.Handled)
+	assert.Nil(t, progress.PendingTimezones)
+}
+
+func TestBroadcastVariants(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	contacts := []*testdata.Contact{testdata.Cathy, testdata.Bob, testdata.George, testdata.Alexandria}
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "hello"}, models.NilScheduleID, contacts, nil)
+
+	translations := map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "hello"}}
+	variants := []*models.Variant{
+		{Name: "A", Percent: 40, Translations: map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "hello A"}}},
+		{Name: "B", Percent: 40, Translations: map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "hello B"}}},
+	}
+	bcast := models.NewBroadcast(testdata.Org1.ID, bcastID, translations, models.TemplateStateEvaluated, "eng", nil, []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID, testdata.Alexandria.ID}, nil, models.NilTicketID, models.NilUserID)
+	bcast.WithVariants(variants)
+
+	err := msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	require.NoError(t, err)
+
+	// all variants are queued to the same queue, chosen by the size of the whole broadcast, so that a small variant
+	// can't be handled first and mark the broadcast as sent
+	size, err := queue.Size(rc, queue.HandlerQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	for {
+		task, err := queue.PopNextTask(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		if task == nil {
+			break
+		}
+
+		batch := &models.BroadcastBatch{}
+		jsonx.MustUnmarshal(task.Task, batch)
+
+		err = msgs.SendBroadcastBatch(ctx, rt, batch)
+		require.NoError(t, err)
+	}
+
+	// each contact is sent the text of their variant, and contacts in the holdout group aren't sent anything
+	assignments := make(map[string]string, len(contacts))
+	for _, c := range contacts {
+		v := models.AssignVariant(variants, fmt.Sprintf("broadcast:%d", bcastID), c.UUID)
+
+		if v != nil {
+			assertdb.Query(t, db, `SELECT text FROM msgs_msg WHERE broadcast_id = $1 AND contact_id = $2`, bcastID, c.ID).Returns(v.Translations["eng"].Text)
+			assignments[fmt.Sprint(c.ID)] = v.Name
+		} else {
+			assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1 AND contact_id = $2`, bcastID, c.ID).Returns(0)
+			assignments[fmt.Sprint(c.ID)] = models.VariantHoldout
+		}
+	}
+
+	assertredis.HGetAll(t, rp, models.BroadcastVariantsKey(bcastID), assignments)
+
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("S")
+}
@@ -120,7 +120,7 @@ func checkSchedules(ctx context.Context, rt *runtime.Runtime) error {
 
 		// add our task if we have one
 		if task != nil {
-			err = queue.AddTask(rc, queue.BatchQueue, taskName, int(s.OrgID()), task, queue.HighPriority)
+			err = queue.AddTask(ctx, rc, queue.BatchQueue, taskName, int(s.OrgID()), task, queue.HighPriority)
 			if err != nil {
 				log.WithError(err).Error("error firing task with name: ", taskName)
 			}
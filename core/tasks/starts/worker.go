@@ -9,6 +9,7 @@ import (
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/core/runner"
 	"github.com/nyaruka/mailroom/core/search"
@@ -43,6 +44,11 @@ func handleFlowStart(ctx context.Context, rt *runtime.Runtime, task *queue.Task)
 		return errors.Wrapf(err, "error unmarshalling flow start task: %s", string(task.Task))
 	}
 
+	// check whether this start has been paused or cancelled before it was split into batches
+	if proceed, err := checkStartControl(rt, task, startTask.ID()); !proceed {
+		return err
+	}
+
 	err = CreateFlowBatches(ctx, rt, startTask)
 	if err != nil {
 		models.MarkStartFailed(ctx, rt.DB, startTask.ID())
@@ -149,17 +155,35 @@ func CreateFlowBatches(ctx context.Context, rt *runtime.Runtime, start *models.F
 		}
 	}
 
+	// starts split into variants start each contact in the flow of the variant they're assigned to, and don't start
+	// those in the holdout group
+	parts := []*startPart{{start: start, contactIDs: make([]models.ContactID, 0, len(contactIDs))}}
+	for id := range contactIDs {
+		parts[0].contactIDs = append(parts[0].contactIDs, id)
+	}
+	if len(start.Variants()) > 0 {
+		parts, err = splitStartVariants(ctx, rt, oa, start, parts[0].contactIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	totalContacts := 0
+	for _, part := range parts {
+		totalContacts += len(part.contactIDs)
+	}
+
 	rc := rt.RP.Get()
 	defer rc.Close()
 
 	// mark our start as starting, last task will mark as complete
-	err = models.MarkStartStarted(ctx, rt.DB, start.ID(), len(contactIDs), createdContactIDs)
+	err = models.MarkStartStarted(ctx, rt.DB, start.ID(), totalContacts, createdContactIDs)
 	if err != nil {
 		return errors.Wrapf(err, "error marking start as started")
 	}
 
 	// if there are no contacts to start, mark our start as complete, we are done
-	if len(contactIDs) == 0 {
+	if totalContacts == 0 {
 		err = models.MarkStartComplete(ctx, rt.DB, start.ID())
 		if err != nil {
 			return errors.Wrapf(err, "error marking start as complete")
@@ -169,7 +193,7 @@ func CreateFlowBatches(ctx context.Context, rt *runtime.Runtime, start *models.F
 
 	// by default we start in the batch queue unless we have two or fewer contacts
 	q := queue.BatchQueue
-	if len(contactIDs) <= 2 {
+	if totalContacts <= 2 {
 		q = queue.HandlerQueue
 	}
 
@@ -179,31 +203,82 @@ func CreateFlowBatches(ctx context.Context, rt *runtime.Runtime, start *models.F
 		taskType = queue.StartIVRFlowBatch
 	}
 
-	contacts := make([]models.ContactID, 0, 100)
-	queueBatch := func(last bool) {
-		batch := start.CreateBatch(contacts, last, len(contactIDs))
-		err = queue.AddTask(rc, q, taskType, int(start.OrgID()), batch, queue.DefaultPriority)
+	queued := 0
+	queueBatch := func(part *startPart, contacts []models.ContactID) {
+		queued += len(contacts)
+		batch := part.start.CreateBatch(contacts, queued == totalContacts, totalContacts)
+
+		// messaging batches are spread out over time if the channels which can send to them have send rates, IVR
+		// batches are throttled separately by the number of concurrent calls their channels allow
+		if taskType == queue.StartFlowBatch {
+			var schemeCounts map[string]int
+			schemeCounts, err = models.CountContactURNSchemes(ctx, rt.DB, contacts)
+			if err != nil {
+				logrus.WithError(err).WithField("start_id", start.ID()).Error("error counting URN schemes of start batch")
+				schemeCounts = make(map[string]int)
+			}
+			err = msgio.QueueThrottledBatch(ctx, rc, oa, q, taskType, batch, len(contacts), schemeCounts, models.StartProgressKey(start.ID()))
+		} else {
+			err = queue.AddTask(ctx, rc, q, taskType, int(start.OrgID()), batch, queue.DefaultPriority)
+		}
 		if err != nil {
 			// TODO: is continuing the right thing here? what do we do if redis is down? (panic!)
 			logrus.WithError(err).WithField("start_id", start.ID()).Error("error while queuing start")
 		}
-		contacts = make([]models.ContactID, 0, 100)
 	}
 
-	// build up batches of contacts to start
-	for c := range contactIDs {
-		if len(contacts) == startBatchSize {
-			queueBatch(false)
+	// build up batches of contacts to start, the last of which will be marked as such
+	for _, part := range parts {
+		for i := 0; i < len(part.contactIDs); i += startBatchSize {
+			end := i + startBatchSize
+			if end > len(part.contactIDs) {
+				end = len(part.contactIDs)
+			}
+			queueBatch(part, part.contactIDs[i:end])
 		}
-		contacts = append(contacts, c)
 	}
 
-	// queue our last batch
-	if len(contacts) > 0 {
-		queueBatch(true)
+	return nil
+}
+
+// the contacts of a flow start which are started in a particular flow
+type startPart struct {
+	start      *models.FlowStart
+	contactIDs []models.ContactID
+}
+
+// assigns the given contacts of a start split into variants to those variants, returning a part for each variant
+func splitStartVariants(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, start *models.FlowStart, contactIDs []models.ContactID) ([]*startPart, error) {
+	parts := make([]*startPart, len(start.Variants()))
+	partsByVariant := make(map[*models.Variant]*startPart, len(start.Variants()))
+
+	for i, v := range start.Variants() {
+		flow, err := oa.FlowByID(v.FlowID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading flow for variant '%s'", v.Name)
+		}
+		if flow.FlowType() != start.FlowType() {
+			return nil, errors.Errorf("flow for variant '%s' isn't of the same type as the start", v.Name)
+		}
+
+		parts[i] = &startPart{start: start.ForVariant(v)}
+		partsByVariant[v] = parts[i]
 	}
 
-	return nil
+	rc := rt.RP.Get()
+	assigned, err := models.AssignVariants(ctx, rt.DB, rc, start.ID(), models.NilBroadcastID, start.Variants(), contactIDs)
+	rc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "error assigning start variants")
+	}
+
+	for _, id := range contactIDs {
+		if v := assigned[id]; v != nil {
+			partsByVariant[v].contactIDs = append(partsByVariant[v].contactIDs, id)
+		}
+	}
+
+	return parts, nil
 }
 
 // HandleFlowStartBatch starts a batch of contacts in a flow
@@ -221,11 +296,37 @@ func handleFlowStartBatch(ctx context.Context, rt *runtime.Runtime, task *queue.
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
+	if proceed, err := checkStartControl(rt, task, startBatch.StartID()); !proceed {
+		return err
+	}
+
 	// start these contacts in our flow
 	_, err = runner.StartFlowBatch(ctx, rt, startBatch)
 	if err != nil {
 		return errors.Wrapf(err, "error starting flow batch: %s", string(task.Task))
 	}
 
-	return err
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := models.RecordBatchHandled(rc, models.StartProgressKey(startBatch.StartID()), len(startBatch.ContactIDs())); err != nil {
+		logrus.WithError(err).Error("error recording flow start progress")
+	}
+
+	return nil
+}
+
+// checks whether the given flow start has been paused or cancelled, returning whether the task should go ahead
+func checkStartControl(rt *runtime.Runtime, task *queue.Task, startID models.StartID) (bool, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	proceed, err := msgio.CheckSendControl(rc, task, models.StartControlKey(startID))
+	if err != nil {
+		return false, err
+	}
+	if !proceed {
+		logrus.WithField("start_id", startID).WithField("task_type", task.Type).Info("skipping task of paused or cancelled flow start")
+	}
+	return proceed, nil
 }
@@ -2,16 +2,18 @@ package starts
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	_ "github.com/nyaruka/mailroom/core/handlers"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/core/queue"
-	"github.com/nyaruka/mailroom/core/runner"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -272,17 +274,21 @@ func TestStarts(t *testing.T) {
 
 			count++
 			assert.Equal(t, queue.StartFlowBatch, task.Type)
-			batch := &models.FlowStartBatch{}
-			err = json.Unmarshal(task.Task, batch)
-			assert.NoError(t, err)
 
-			_, err = runner.StartFlowBatch(ctx, rt, batch)
+			err = handleFlowStartBatch(ctx, rt, task)
 			assert.NoError(t, err)
 		}
 
 		// assert our count of batches
 		assert.Equal(t, tc.expectedBatchCount, count, "unexpected batch count in '%s'", tc.label)
 
+		// assert our progress
+		if tc.expectedBatchCount > 0 {
+			progress, err := models.GetSendProgress(rc, models.StartProgressKey(start.ID()))
+			assert.NoError(t, err)
+			assert.Equal(t, &models.SendProgress{Queued: tc.expectedContactCount, Handled: tc.expectedContactCount}, progress, "progress mismatch in '%s'", tc.label)
+		}
+
 		// assert our count of total flow runs created
 		assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE flow_id = $1 AND start_id = $2`, tc.flowID, start.ID()).Returns(tc.expectedTotalCount, "unexpected total run count in '%s'", tc.label)
 
@@ -301,3 +307,139 @@ func TestStarts(t *testing.T) {
 		}
 	}
 }
+
+func TestStartControl(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// start doctors in favorites which will be split into 2 batches
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID).
+		WithGroupIDs([]models.GroupID{testdata.DoctorsGroup.ID})
+	require.NoError(t, models.InsertFlowStarts(ctx, db, []*models.FlowStart{start}))
+
+	startJSON, err := json.Marshal(start)
+	require.NoError(t, err)
+
+	err = handleFlowStart(ctx, rt, &queue.Task{Type: queue.StartFlow, Task: startJSON})
+	require.NoError(t, err)
+
+	handleBatches := func() int {
+		count := 0
+		for {
+			task, err := queue.PopNextTask(rc, queue.BatchQueue)
+			require.NoError(t, err)
+			if task == nil {
+				return count
+			}
+			count++
+			require.NoError(t, handleFlowStartBatch(ctx, rt, task))
+		}
+	}
+
+	// pause the start so that its batches are parked without doing anything
+	require.NoError(t, models.SetSendControl(rc, models.StartControlKey(start.ID()), models.SendControlPause))
+
+	assert.Equal(t, 2, handleBatches())
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE start_id = $1`, start.ID()).Returns(0)
+
+	// and aren't handled again until it's resumed
+	assert.Equal(t, 0, handleBatches())
+
+	requeued, err := msgio.ResumeSend(rc, models.StartControlKey(start.ID()))
+	require.NoError(t, err)
+	assert.Equal(t, 2, requeued)
+
+	assert.Equal(t, 2, handleBatches())
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE start_id = $1`, start.ID()).Returns(121)
+	assertdb.Query(t, db, `SELECT status FROM flows_flowstart WHERE id = $1`, start.ID()).Returns("C")
+
+	// a start which is cancelled before it's been split into batches is never started
+	start = models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID})
+	require.NoError(t, models.InsertFlowStarts(ctx, db, []*models.FlowStart{start}))
+
+	require.NoError(t, models.SetSendControl(rc, models.StartControlKey(start.ID()), models.SendControlCancel))
+	require.NoError(t, models.MarkStartInterrupted(ctx, db, start.ID()))
+
+	startJSON, err = json.Marshal(start)
+	require.NoError(t, err)
+
+	err = handleFlowStart(ctx, rt, &queue.Task{Type: queue.StartFlow, Task: startJSON})
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE start_id = $1`, start.ID()).Returns(0)
+	assertdb.Query(t, db, `SELECT status FROM flows_flowstart WHERE id = $1`, start.ID()).Returns("I")
+}
+
+func TestStartVariants(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	contacts := []*testdata.Contact{testdata.Cathy, testdata.Bob, testdata.George, testdata.Alexandria}
+	variants := []*models.Variant{{Name: "A", Percent: 40, FlowID: testdata.Favorites.ID}, {Name: "B", Percent: 40, FlowID: testdata.PickANumber.ID}}
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID, testdata.Alexandria.ID}).
+		WithVariants(variants)
+	require.NoError(t, models.InsertFlowStarts(ctx, db, []*models.FlowStart{start}))
+
+	startJSON, err := json.Marshal(start)
+	require.NoError(t, err)
+
+	err = handleFlowStart(ctx, rt, &queue.Task{Type: queue.StartFlow, Task: startJSON})
+	require.NoError(t, err)
+
+	for _, q := range []string{queue.BatchQueue, queue.HandlerQueue} {
+		for {
+			task, err := queue.PopNextTask(rc, q)
+			require.NoError(t, err)
+			if task == nil {
+				break
+			}
+			require.NoError(t, handleFlowStartBatch(ctx, rt, task))
+		}
+	}
+
+	// each contact is started in the flow of their variant, and contacts in the holdout group aren't started
+	expectedStarted := 0
+	assignments := make(map[string]string, len(contacts))
+	for _, c := range contacts {
+		v := models.AssignVariant(start.Variants(), fmt.Sprintf("start:%d", start.ID()), c.UUID)
+
+		if v != nil {
+			expectedStarted++
+			assertdb.Query(t, db, `SELECT flow_id FROM flows_flowrun WHERE start_id = $1 AND contact_id = $2`, start.ID(), c.ID).Returns(int64(v.FlowID))
+			assignments[fmt.Sprint(c.ID)] = v.Name
+		} else {
+			assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE start_id = $1 AND contact_id = $2`, start.ID(), c.ID).Returns(0)
+			assignments[fmt.Sprint(c.ID)] = models.VariantHoldout
+		}
+	}
+
+	assertredis.HGetAll(t, rp, models.StartVariantsKey(start.ID()), assignments)
+
+	assertdb.Query(t, db, `SELECT status, contact_count FROM flows_flowstart WHERE id = $1`, start.ID()).Columns(map[string]interface{}{"status": "C", "contact_count": int64(expectedStarted)})
+
+	// variants with flows of a different type fail the start
+	start = models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID}).
+		WithVariants([]*models.Variant{{Name: "A", Percent: 50, FlowID: testdata.IVRFlow.ID}})
+	require.NoError(t, models.InsertFlowStarts(ctx, db, []*models.FlowStart{start}))
+
+	startJSON, err = json.Marshal(start)
+	require.NoError(t, err)
+
+	err = handleFlowStart(ctx, rt, &queue.Task{Type: queue.StartFlow, Task: startJSON})
+	assert.EqualError(t, err, "flow for variant 'A' isn't of the same type as the start")
+	assertdb.Query(t, db, `SELECT status FROM flows_flowstart WHERE id = $1`, start.ID()).Returns("F")
+}
@@ -0,0 +1,223 @@
+package tickets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/core/tasks/handler"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	mailroom.RegisterCron("close_inactive_tickets", time.Minute, false, CloseInactiveTickets)
+}
+
+// CloseInactiveTickets looks for open tickets which have been inactive for longer than their org's auto-close policy
+// allows and closes them
+func CloseInactiveTickets(ctx context.Context, rt *runtime.Runtime) error {
+	log := logrus.WithField("comp", "ticket_auto_close")
+	start := time.Now()
+
+	orgIDs, err := models.GetOrgIDsWithOpenTickets(ctx, rt.DB)
+	if err != nil {
+		return errors.Wrap(err, "error fetching orgs with open tickets")
+	}
+
+	numClosed := 0
+
+	for _, orgID := range orgIDs {
+		// an org with a bad policy or broken ticketer shouldn't stop us closing tickets in other orgs
+		num, err := closeOrgInactiveTickets(ctx, rt, orgID)
+		if err != nil {
+			log.WithError(err).WithField("org_id", orgID).Error("error closing inactive tickets")
+		}
+		numClosed += num
+	}
+
+	log.WithField("elapsed", time.Since(start)).WithField("closed", numClosed).Info("inactive tickets closed")
+	return nil
+}
+
+func closeOrgInactiveTickets(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) (int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error loading org assets")
+	}
+
+	policy, err := oa.Org().TicketAutoClosePolicy()
+	if err != nil || policy == nil {
+		return 0, err
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	topics, _ := oa.Topics()
+	numClosed := 0
+
+	for _, t := range topics {
+		topic := t.(*models.Topic)
+		timeout := policy.TimeoutFor(topic.UUID())
+		if timeout <= 0 {
+			continue
+		}
+
+		tickets, err := models.LoadInactiveTickets(ctx, rt.DB, orgID, topic.ID(), dates.Now().Add(-timeout))
+		if err != nil {
+			return numClosed, errors.Wrapf(err, "error loading inactive tickets for topic %s", topic.UUID())
+		}
+
+		// tickets are closed separately for each ticketer so that one which is failing doesn't stop us closing the others
+		byTicketer := make(map[models.TicketerID][]*models.Ticket)
+		for _, ticket := range tickets {
+			byTicketer[ticket.TicketerID()] = append(byTicketer[ticket.TicketerID()], ticket)
+		}
+
+		for ticketerID, ticketerTickets := range byTicketer {
+			log := logrus.WithFields(logrus.Fields{"comp": "ticket_auto_close", "org_id": orgID, "topic_uuid": topic.UUID(), "ticketer_id": ticketerID})
+
+			backingOff, err := isAutoCloseBackingOff(rc, ticketerID)
+			if err != nil {
+				return numClosed, err
+			}
+			if backingOff {
+				continue
+			}
+
+			num, err := closeTickets(ctx, rt, oa, ticketerTickets, policy.Message)
+			if err != nil {
+				backoff, rerr := recordAutoCloseFailure(rc, ticketerID)
+				if rerr != nil {
+					return numClosed, rerr
+				}
+
+				log.WithError(err).WithField("backoff", backoff).Error("error closing inactive tickets")
+				continue
+			}
+
+			if err := clearAutoCloseFailures(rc, ticketerID); err != nil {
+				return numClosed, err
+			}
+
+			numClosed += num
+		}
+	}
+
+	return numClosed, nil
+}
+
+const (
+	autoCloseBackoffKey     = "ticket_auto_close_backoff:%d"
+	autoCloseInitialBackoff = 2 * time.Minute
+	autoCloseMaxBackoff     = time.Hour
+)
+
+// checks whether we're waiting to retry closing tickets on the given ticketer after it failed to close tickets
+func isAutoCloseBackingOff(rc redis.Conn, ticketerID models.TicketerID) (bool, error) {
+	retryAfter, err := redis.Int64(rc.Do("HGET", fmt.Sprintf(autoCloseBackoffKey, ticketerID), "retry_after"))
+	if err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "error reading ticketer backoff")
+	}
+	return dates.Now().Unix() < retryAfter, nil
+}
+
+// records that the given ticketer failed to close tickets, returning how long we'll wait before trying it again, which
+// doubles with each consecutive failure
+func recordAutoCloseFailure(rc redis.Conn, ticketerID models.TicketerID) (time.Duration, error) {
+	key := fmt.Sprintf(autoCloseBackoffKey, ticketerID)
+
+	failures, err := redis.Int(rc.Do("HINCRBY", key, "failures", 1))
+	if err != nil {
+		return 0, errors.Wrap(err, "error recording ticketer failure")
+	}
+
+	backoff := autoCloseInitialBackoff
+	for i := 1; i < failures && backoff < autoCloseMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > autoCloseMaxBackoff {
+		backoff = autoCloseMaxBackoff
+	}
+
+	rc.Send("MULTI")
+	rc.Send("HSET", key, "retry_after", dates.Now().Add(backoff).Unix())
+	rc.Send("EXPIRE", key, int((backoff+autoCloseMaxBackoff)/time.Second))
+	if _, err := rc.Do("EXEC"); err != nil {
+		return 0, errors.Wrap(err, "error recording ticketer backoff")
+	}
+
+	return backoff, nil
+}
+
+// clears any failures recorded for the given ticketer once it has closed tickets successfully
+func clearAutoCloseFailures(rc redis.Conn, ticketerID models.TicketerID) error {
+	_, err := rc.Do("DEL", fmt.Sprintf(autoCloseBackoffKey, ticketerID))
+	return errors.Wrap(err, "error clearing ticketer failures")
+}
+
+// closes the given tickets, notifying their ticketers, queues the closed events so that ticket_closed triggers fire,
+// and sends the closing message to the contacts if there is one
+func closeTickets(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, tickets []*models.Ticket, message string) (int, error) {
+	logger := &models.HTTPLogger{}
+
+	evts, err := models.CloseTickets(ctx, rt, oa, models.NilUserID, tickets, true, false, logger)
+
+	// insert our HTTP logs even if closing failed as they're what's needed to debug a failing ticketer
+	logErr := logger.Insert(ctx, rt.DB)
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error closing tickets")
+	}
+	if logErr != nil {
+		return 0, errors.Wrap(logErr, "error inserting HTTP logs")
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	contactIDs := make([]models.ContactID, 0, len(evts))
+
+	for _, ticket := range tickets {
+		if evt := evts[ticket]; evt != nil {
+			if err := handler.QueueTicketEvent(rc, ticket.ContactID(), evt); err != nil {
+				return 0, errors.Wrapf(err, "error queueing ticket closed event for ticket %d", ticket.ID())
+			}
+			contactIDs = append(contactIDs, ticket.ContactID())
+		}
+	}
+
+	if message != "" && len(contactIDs) > 0 {
+		if err := sendClosingMessage(ctx, rt, oa, contactIDs, message); err != nil {
+			return 0, errors.Wrap(err, "error sending closing messages")
+		}
+	}
+
+	return len(evts), nil
+}
+
+// sends the closing message as a broadcast which isn't associated with the tickets, so it isn't counted as a reply
+func sendClosingMessage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, contactIDs []models.ContactID, text string) error {
+	translations := map[envs.Language]*models.BroadcastTranslation{envs.Language("base"): {Text: text}}
+
+	bcast := models.NewBroadcast(oa.OrgID(), models.NilBroadcastID, translations, models.TemplateStateEvaluated, envs.Language("base"), nil, contactIDs, nil, models.NilTicketID, models.NilUserID)
+	batch := bcast.CreateBatch(contactIDs)
+
+	msgs, err := batch.CreateMessages(ctx, rt, oa)
+	if err != nil {
+		return errors.Wrap(err, "error creating message batch")
+	}
+
+	msgio.SendMessages(ctx, rt, rt.DB, nil, msgs)
+	return nil
+}
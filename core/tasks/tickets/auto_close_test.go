@@ -0,0 +1,118 @@
+package tickets_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseInactiveTickets(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// tickets are closed after a day of inactivity, except support tickets which are closed after an hour
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_auto_close": {
+		"timeout": 86400,
+		"topics": {"0a8f2e00-fef6-402c-bd79-d789446ec0e0": 3600},
+		"message": "Your ticket has been closed"
+	}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	now := time.Now()
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-3*time.Hour), nil)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SalesTopic, "Where my shoes", "", now.Add(-3*time.Hour), nil)
+	ticket3 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.DefaultTopic, "Where my hat", "", now.Add(-48*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-2*time.Hour))
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket2.ID, now.Add(-2*time.Hour))
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket3.ID, now.Add(-25*time.Hour))
+
+	err := tickets.CloseInactiveTickets(ctx, rt)
+	require.NoError(t, err)
+
+	// first and third tickets were closed, second is still within the default timeout
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("C")
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Returns("O")
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket3.ID).Returns("C")
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE event_type = 'C' AND created_by_id IS NULL`).Returns(2)
+
+	// and their contacts were sent the closing message
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE direction = 'O' AND text = 'Your ticket has been closed' AND contact_id = ANY(ARRAY[$1, $2])`, testdata.Cathy.ID, testdata.Alexandria.ID).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE direction = 'O'`).Returns(2)
+
+	// and the closing message isn't counted as a reply
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE replied_on IS NOT NULL`).Returns(0)
+
+	// running again doesn't close anything else
+	err = tickets.CloseInactiveTickets(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE status = 'C'`).Returns(2)
+}
+
+func TestCloseInactiveTicketsBackoff(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	// mailgun fails to close tickets the first time we try
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://api.mailgun.net/v3/tickets.rapidpro.io/messages": {
+			httpx.NewMockResponse(500, nil, `Internal Server Error`),
+			httpx.NewMockResponse(200, nil, `{
+				"id": "<20200426161758.1.590432020254B2BF@tickets.rapidpro.io>",
+				"message": "Queued. Thank you."
+			}`),
+		},
+	}))
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_auto_close": {"timeout": 3600}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	now := time.Now()
+	dates.SetNowSource(dates.NewFixedNowSource(now))
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Mailgun, testdata.SupportTopic, "Where my pants", "", now.Add(-3*time.Hour), nil)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", now.Add(-3*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $1`, now.Add(-2*time.Hour))
+
+	// the internal ticket is still closed even though mailgun failed
+	err := tickets.CloseInactiveTickets(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("O")
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Returns("C")
+	assertredis.Exists(t, rp, "ticket_auto_close_backoff:2")
+
+	// and the HTTP log of the failed mailgun call was still recorded
+	assertdb.Query(t, db, `SELECT count(*) FROM request_logs_httplog WHERE ticketer_id = $1 AND status_code = 500`, testdata.Mailgun.ID).Returns(1)
+
+	// and we don't try mailgun again until its backoff has passed
+	dates.SetNowSource(dates.NewFixedNowSource(now.Add(time.Minute)))
+
+	err = tickets.CloseInactiveTickets(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("O")
+
+	dates.SetNowSource(dates.NewFixedNowSource(now.Add(3 * time.Minute)))
+
+	err = tickets.CloseInactiveTickets(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("C")
+	assertredis.NotExists(t, rp, "ticket_auto_close_backoff:2")
+}
@@ -0,0 +1,261 @@
+package tickets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/core/tasks/handler"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeBulkTicket is the type of the task to perform an operation on all tickets matching a filter
+const TypeBulkTicket = "bulk_ticket"
+
+// BulkTicketAction is the operation performed by a bulk ticket task
+type BulkTicketAction string
+
+// BulkTicketStatus is the status of a bulk ticket task
+type BulkTicketStatus string
+
+const (
+	BulkTicketActionClose       BulkTicketAction = "close"
+	BulkTicketActionReopen      BulkTicketAction = "reopen"
+	BulkTicketActionAssign      BulkTicketAction = "assign"
+	BulkTicketActionAddNote     BulkTicketAction = "add_note"
+	BulkTicketActionChangeTopic BulkTicketAction = "change_topic"
+
+	BulkTicketStatusQueued     BulkTicketStatus = "queued"
+	BulkTicketStatusInProgress BulkTicketStatus = "in_progress"
+	BulkTicketStatusCompleted  BulkTicketStatus = "completed"
+	BulkTicketStatusFailed     BulkTicketStatus = "failed"
+
+	bulkTicketBatchSize      = 100
+	bulkTicketProgressKey    = "bulk_ticket:%d:%s"
+	bulkTicketProgressExpiry = 24 * time.Hour
+)
+
+func init() {
+	tasks.RegisterType(TypeBulkTicket, func() tasks.Task { return &BulkTicketTask{} })
+}
+
+// BulkTicketProgress is the progress of a bulk ticket task which is stored in redis so that callers can poll it
+type BulkTicketProgress struct {
+	Status    BulkTicketStatus `json:"status"`
+	Total     int              `json:"total"`
+	Processed int              `json:"processed"`
+	Changed   int              `json:"changed"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// BulkTicketTask is our task to perform an operation on all the tickets which match a filter
+type BulkTicketTask struct {
+	UUID       uuids.UUID           `json:"uuid"                  validate:"required"`
+	UserID     models.UserID        `json:"user_id"`
+	Action     BulkTicketAction     `json:"action"                validate:"required,eq=close|eq=reopen|eq=assign|eq=add_note|eq=change_topic"`
+	Filter     *models.TicketFilter `json:"filter"                validate:"required"`
+	AssigneeID models.UserID        `json:"assignee_id,omitempty"`
+	Note       string               `json:"note,omitempty"`
+	TopicID    models.TopicID       `json:"topic_id,omitempty"`
+	Force      bool                 `json:"force,omitempty"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *BulkTicketTask) Timeout() time.Duration {
+	return time.Hour
+}
+
+// Perform finds the tickets matching our filter and performs our operation on them in batches, recording our
+// progress as we go
+func (t *BulkTicketTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	log := logrus.WithFields(logrus.Fields{"comp": "bulk_ticket", "org_id": orgID, "uuid": t.UUID, "action": t.Action})
+	start := time.Now()
+
+	progress := &BulkTicketProgress{Status: BulkTicketStatusInProgress}
+
+	err := t.perform(ctx, rt, orgID, progress)
+	if err != nil {
+		progress.Status = BulkTicketStatusFailed
+		progress.Error = err.Error()
+	} else {
+		progress.Status = BulkTicketStatusCompleted
+	}
+
+	if err := t.saveProgress(rt, orgID, progress); err != nil {
+		log.WithError(err).Error("error saving bulk ticket progress")
+	}
+
+	log.WithField("elapsed", time.Since(start)).WithField("changed", progress.Changed).Info("bulk ticket operation complete")
+
+	return err
+}
+
+func (t *BulkTicketTask) perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, progress *BulkTicketProgress) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading org assets")
+	}
+
+	// resolve any contact query to contact ids using elastic
+	var contactIDs []models.ContactID
+	if t.Filter.ContactQuery != "" {
+		contactIDs, err = search.GetContactIDsForQuery(ctx, rt.ES, oa, t.Filter.ContactQuery, -1)
+		if err != nil {
+			return errors.Wrapf(err, "error performing contact query")
+		}
+	}
+
+	ticketIDs, err := models.SelectTicketIDsByFilter(ctx, rt.DB, orgID, t.Filter, contactIDs)
+	if err != nil {
+		return err
+	}
+
+	progress.Total = len(ticketIDs)
+	if err := t.saveProgress(rt, orgID, progress); err != nil {
+		return errors.Wrap(err, "error saving progress")
+	}
+
+	for i := 0; i < len(ticketIDs); i += bulkTicketBatchSize {
+		end := i + bulkTicketBatchSize
+		if end > len(ticketIDs) {
+			end = len(ticketIDs)
+		}
+
+		tickets, err := models.LoadTickets(ctx, rt.DB, ticketIDs[i:end])
+		if err != nil {
+			return errors.Wrap(err, "error loading tickets")
+		}
+
+		changed, err := t.performBatch(ctx, rt, oa, tickets)
+		if err != nil {
+			return errors.Wrapf(err, "error performing %s on tickets", t.Action)
+		}
+
+		progress.Processed = end
+		progress.Changed += changed
+		if err := t.saveProgress(rt, orgID, progress); err != nil {
+			return errors.Wrap(err, "error saving progress")
+		}
+	}
+
+	return nil
+}
+
+// performs our operation on a batch of tickets, returning the number actually changed
+func (t *BulkTicketTask) performBatch(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, tickets []*models.Ticket) (int, error) {
+	var evts map[*models.Ticket]*models.TicketEvent
+	var err error
+
+	switch t.Action {
+	case BulkTicketActionClose:
+		logger := &models.HTTPLogger{}
+
+		evts, err = models.CloseTickets(ctx, rt, oa, t.UserID, tickets, true, t.Force, logger)
+		if err != nil {
+			return 0, err
+		}
+		if err := logger.Insert(ctx, rt.DB); err != nil {
+			return 0, errors.Wrap(err, "error inserting HTTP logs")
+		}
+
+		// queue the closed events so that ticket_closed triggers fire
+		rc := rt.RP.Get()
+		defer rc.Close()
+
+		for ticket, evt := range evts {
+			if err := handler.QueueTicketEvent(rc, ticket.ContactID(), evt); err != nil {
+				return 0, errors.Wrapf(err, "error queueing ticket event for ticket %d", ticket.ID())
+			}
+		}
+
+	case BulkTicketActionReopen:
+		logger := &models.HTTPLogger{}
+
+		evts, err = models.ReopenTickets(ctx, rt, oa, t.UserID, tickets, true, logger)
+		if err != nil {
+			return 0, err
+		}
+		if err := logger.Insert(ctx, rt.DB); err != nil {
+			return 0, errors.Wrap(err, "error inserting HTTP logs")
+		}
+
+	case BulkTicketActionAssign:
+		evts, err = models.TicketsAssign(ctx, rt.DB, oa, t.UserID, tickets, t.AssigneeID, t.Note)
+
+	case BulkTicketActionAddNote:
+		evts, err = models.TicketsAddNote(ctx, rt.DB, oa, t.UserID, tickets, t.Note)
+
+	case BulkTicketActionChangeTopic:
+		evts, err = models.TicketsChangeTopic(ctx, rt.DB, oa, t.UserID, tickets, t.TopicID)
+	}
+
+	return len(evts), err
+}
+
+// an empty filter would match every ticket in the org so bulk operations require at least one condition
+func (t *BulkTicketTask) validate() error {
+	if t.Filter.IsEmpty() {
+		return errors.New("filter must set at least one condition")
+	}
+	return nil
+}
+
+func (t *BulkTicketTask) saveProgress(rt *runtime.Runtime, orgID models.OrgID, progress *BulkTicketProgress) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	return setBulkTicketProgress(rc, orgID, t.UUID, progress)
+}
+
+func setBulkTicketProgress(rc redis.Conn, orgID models.OrgID, uuid uuids.UUID, progress *BulkTicketProgress) error {
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("SET", fmt.Sprintf(bulkTicketProgressKey, orgID, uuid), encoded, "EX", int(bulkTicketProgressExpiry/time.Second))
+	return err
+}
+
+// QueueBulkTicketTask records the given task as queued and adds it to the batch queue
+func QueueBulkTicketTask(ctx context.Context, rc redis.Conn, orgID models.OrgID, task *BulkTicketTask) error {
+	if err := task.validate(); err != nil {
+		return err
+	}
+
+	if err := setBulkTicketProgress(rc, orgID, task.UUID, &BulkTicketProgress{Status: BulkTicketStatusQueued}); err != nil {
+		return errors.Wrap(err, "error saving progress")
+	}
+
+	return queue.AddTask(ctx, rc, queue.BatchQueue, TypeBulkTicket, int(orgID), task, queue.DefaultPriority)
+}
+
+// GetBulkTicketProgress gets the progress of the bulk ticket task with the given UUID, or nil if there's no such task
+func GetBulkTicketProgress(rc redis.Conn, orgID models.OrgID, uuid uuids.UUID) (*BulkTicketProgress, error) {
+	encoded, err := redis.Bytes(rc.Do("GET", fmt.Sprintf(bulkTicketProgressKey, orgID, uuid)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	progress := &BulkTicketProgress{}
+	if err := json.Unmarshal(encoded, progress); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling progress")
+	}
+	return progress, nil
+}
@@ -0,0 +1,106 @@
+package tickets_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkTicket(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	now := time.Now()
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-48*time.Hour), nil)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", now.Add(-48*time.Hour), testdata.Agent)
+	ticket3 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Where my hat", "", now, nil)
+
+	// queue a task to add a note to all support tickets
+	task := &tickets.BulkTicketTask{
+		UUID:   uuids.UUID("2c06d3e5-7a5c-4e0b-8f8e-4c1e7c7c3c1a"),
+		UserID: testdata.Admin.ID,
+		Action: tickets.BulkTicketActionAddNote,
+		Filter: &models.TicketFilter{TopicID: testdata.SupportTopic.ID},
+		Note:   "Cleaning up",
+	}
+	err := tickets.QueueBulkTicketTask(ctx, rc, testdata.Org1.ID, task)
+	require.NoError(t, err)
+
+	progress, err := tickets.GetBulkTicketProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, &tickets.BulkTicketProgress{Status: tickets.BulkTicketStatusQueued}, progress)
+
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err = tickets.GetBulkTicketProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, &tickets.BulkTicketProgress{Status: tickets.BulkTicketStatusCompleted, Total: 2, Processed: 2, Changed: 2}, progress)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE event_type = 'N' AND note = 'Cleaning up' AND ticket_id = ANY(ARRAY[$1, $2]::int[])`, ticket1.ID, ticket2.ID).Returns(2)
+
+	// close all open tickets which haven't been active in the last day
+	dayAgo := now.Add(-24 * time.Hour)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket1.ID, now.Add(-48*time.Hour))
+
+	task = &tickets.BulkTicketTask{
+		UUID:   uuids.UUID("9f1e3b1f-2c3b-4d8e-8f5a-0e6b8a2f7d3c"),
+		UserID: testdata.Admin.ID,
+		Action: tickets.BulkTicketActionClose,
+		Filter: &models.TicketFilter{Status: models.TicketStatusOpen, LastActivityBefore: &dayAgo},
+	}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err = tickets.GetBulkTicketProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, &tickets.BulkTicketProgress{Status: tickets.BulkTicketStatusCompleted, Total: 1, Processed: 1, Changed: 1}, progress)
+
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns("C")
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Returns("O")
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket3.ID).Returns("O")
+
+	// and the closed event was queued for handling
+	numTasks, err := redis.Int(rc.Do("LLEN", fmt.Sprintf("c:%d:%d", testdata.Org1.ID, testdata.Cathy.ID)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, numTasks)
+
+	// empty filters would match every ticket so can't be queued or performed
+	task = &tickets.BulkTicketTask{
+		UUID:   uuids.UUID("5b3c8a4e-1d2f-4e6a-9b7c-3f2e1d0c9b8a"),
+		UserID: testdata.Admin.ID,
+		Action: tickets.BulkTicketActionClose,
+		Filter: &models.TicketFilter{},
+	}
+	err = tickets.QueueBulkTicketTask(ctx, rc, testdata.Org1.ID, task)
+	assert.EqualError(t, err, "filter must set at least one condition")
+
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	assert.EqualError(t, err, "filter must set at least one condition")
+
+	progress, err = tickets.GetBulkTicketProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, &tickets.BulkTicketProgress{Status: tickets.BulkTicketStatusFailed, Error: "filter must set at least one condition"}, progress)
+
+	assertdb.Query(t, db, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Returns("O")
+
+	// no progress for unknown tasks
+	progress, err = tickets.GetBulkTicketProgress(rc, testdata.Org1.ID, uuids.UUID("a4f4cbd1-0d3b-4a77-a1e5-1b2c3d4e5f60"))
+	assert.NoError(t, err)
+	assert.Nil(t, progress)
+}
@@ -0,0 +1,326 @@
+package tickets
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeExportTickets is the type of the task to export tickets to a file in media storage
+const TypeExportTickets = "export_tickets"
+
+// TicketExportFormat is the file format of a ticket export
+type TicketExportFormat string
+
+// TicketExportStatus is the status of a ticket export task
+type TicketExportStatus string
+
+const (
+	TicketExportFormatCSV   TicketExportFormat = "csv"
+	TicketExportFormatJSONL TicketExportFormat = "jsonl"
+
+	TicketExportStatusQueued     TicketExportStatus = "queued"
+	TicketExportStatusInProgress TicketExportStatus = "in_progress"
+	TicketExportStatusCompleted  TicketExportStatus = "completed"
+	TicketExportStatusFailed     TicketExportStatus = "failed"
+
+	ticketExportBatchSize      = 500
+	ticketExportProgressKey    = "ticket_export:%d:%s"
+	ticketExportProgressExpiry = 24 * time.Hour
+)
+
+func init() {
+	tasks.RegisterType(TypeExportTickets, func() tasks.Task { return &ExportTicketsTask{} })
+}
+
+// TicketExportProgress is the progress of a ticket export task which is stored in redis so that callers can poll it
+type TicketExportProgress struct {
+	Status   TicketExportStatus `json:"status"`
+	Total    int                `json:"total"`
+	Exported int                `json:"exported"`
+	URL      string             `json:"url,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// ExportTicketsTask is our task to export all the tickets which match a filter, with their event history, to a CSV or
+// JSONL file in media storage
+type ExportTicketsTask struct {
+	UUID   uuids.UUID           `json:"uuid"    validate:"required"`
+	UserID models.UserID        `json:"user_id" validate:"required"`
+	Format TicketExportFormat   `json:"format"  validate:"required,eq=csv|eq=jsonl"`
+	Filter *models.TicketFilter `json:"filter"  validate:"required"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *ExportTicketsTask) Timeout() time.Duration {
+	return time.Hour
+}
+
+// Perform writes the tickets matching our filter to a file in media storage
+func (t *ExportTicketsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	log := logrus.WithFields(logrus.Fields{"comp": "export_tickets", "org_id": orgID, "uuid": t.UUID, "format": t.Format})
+	start := time.Now()
+
+	progress := &TicketExportProgress{Status: TicketExportStatusInProgress}
+
+	err := t.perform(ctx, rt, orgID, progress)
+	if err != nil {
+		progress.Status = TicketExportStatusFailed
+		progress.Error = err.Error()
+	} else {
+		progress.Status = TicketExportStatusCompleted
+	}
+
+	if err := t.saveProgress(rt, orgID, progress); err != nil {
+		log.WithError(err).Error("error saving ticket export progress")
+	}
+
+	log.WithField("elapsed", time.Since(start)).WithField("exported", progress.Exported).Info("ticket export complete")
+
+	return err
+}
+
+func (t *ExportTicketsTask) perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, progress *TicketExportProgress) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading org assets")
+	}
+
+	// resolve any contact query to contact ids using elastic
+	var contactIDs []models.ContactID
+	if t.Filter.ContactQuery != "" {
+		contactIDs, err = search.GetContactIDsForQuery(ctx, rt.ES, oa, t.Filter.ContactQuery, -1)
+		if err != nil {
+			return errors.Wrapf(err, "error performing contact query")
+		}
+	}
+
+	ticketIDs, err := models.SelectTicketIDsByFilter(ctx, rt.DB, orgID, t.Filter, contactIDs)
+	if err != nil {
+		return err
+	}
+
+	progress.Total = len(ticketIDs)
+	if err := t.saveProgress(rt, orgID, progress); err != nil {
+		return errors.Wrap(err, "error saving progress")
+	}
+
+	// media storage only accepts the complete contents of a file so the export is built up in memory
+	content := &bytes.Buffer{}
+
+	var writer ticketExportWriter
+	if t.Format == TicketExportFormatCSV {
+		writer = newCSVTicketExportWriter(content)
+	} else {
+		writer = newJSONLTicketExportWriter(content)
+	}
+
+	for i := 0; i < len(ticketIDs); i += ticketExportBatchSize {
+		end := i + ticketExportBatchSize
+		if end > len(ticketIDs) {
+			end = len(ticketIDs)
+		}
+
+		rows, err := models.LoadTicketExportRows(ctx, rt.DB, ticketIDs[i:end])
+		if err != nil {
+			return errors.Wrap(err, "error loading tickets")
+		}
+
+		for _, row := range rows {
+			if err := writer.Write(row); err != nil {
+				return errors.Wrap(err, "error writing ticket")
+			}
+		}
+
+		progress.Exported += len(rows)
+		if err := t.saveProgress(rt, orgID, progress); err != nil {
+			return errors.Wrap(err, "error saving progress")
+		}
+	}
+
+	if err := writer.Finish(); err != nil {
+		return errors.Wrap(err, "error finishing export file")
+	}
+
+	path := filepath.Join(rt.Config.S3MediaPrefix, fmt.Sprintf("%d", orgID), "ticket_exports", fmt.Sprintf("%s.%s", t.UUID, t.Format))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	progress.URL, err = rt.MediaStorage.Put(ctx, path, writer.ContentType(), content.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "error storing export file")
+	}
+
+	return models.NotifyExportFinished(ctx, rt.DB, orgID, t.UserID, string(t.UUID))
+}
+
+func (t *ExportTicketsTask) saveProgress(rt *runtime.Runtime, orgID models.OrgID, progress *TicketExportProgress) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	return setTicketExportProgress(rc, orgID, t.UUID, progress)
+}
+
+func setTicketExportProgress(rc redis.Conn, orgID models.OrgID, uuid uuids.UUID, progress *TicketExportProgress) error {
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("SET", fmt.Sprintf(ticketExportProgressKey, orgID, uuid), encoded, "EX", int(ticketExportProgressExpiry/time.Second))
+	return err
+}
+
+// QueueExportTicketsTask records the given task as queued and adds it to the batch queue
+func QueueExportTicketsTask(ctx context.Context, rc redis.Conn, orgID models.OrgID, task *ExportTicketsTask) error {
+	if err := setTicketExportProgress(rc, orgID, task.UUID, &TicketExportProgress{Status: TicketExportStatusQueued}); err != nil {
+		return errors.Wrap(err, "error saving progress")
+	}
+
+	return queue.AddTask(ctx, rc, queue.BatchQueue, TypeExportTickets, int(orgID), task, queue.DefaultPriority)
+}
+
+// GetTicketExportProgress gets the progress of the ticket export task with the given UUID, or nil if there's no such task
+func GetTicketExportProgress(rc redis.Conn, orgID models.OrgID, uuid uuids.UUID) (*TicketExportProgress, error) {
+	encoded, err := redis.Bytes(rc.Do("GET", fmt.Sprintf(ticketExportProgressKey, orgID, uuid)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	progress := &TicketExportProgress{}
+	if err := json.Unmarshal(encoded, progress); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling progress")
+	}
+	return progress, nil
+}
+
+// writes exported tickets to a file in a particular file format
+type ticketExportWriter interface {
+	Write(*models.TicketExportRow) error
+	Finish() error
+	ContentType() string
+}
+
+var csvTicketExportHeader = []string{
+	"UUID", "Contact UUID", "Contact Name", "Status", "Topic", "Assignee", "Ticketer", "Body",
+	"Opened On", "Replied On", "First Response (Seconds)", "Closed On", "Last Activity On", "Events",
+}
+
+// writes one row per ticket, with the event history as a JSON encoded column
+type csvTicketExportWriter struct {
+	csv    *csv.Writer
+	header bool
+}
+
+func newCSVTicketExportWriter(w io.Writer) *csvTicketExportWriter {
+	return &csvTicketExportWriter{csv: csv.NewWriter(w)}
+}
+
+func (w *csvTicketExportWriter) Write(row *models.TicketExportRow) error {
+	if !w.header {
+		if err := w.csv.Write(csvTicketExportHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	firstResponse := ""
+	if d := row.FirstResponse(); d != nil {
+		firstResponse = strconv.Itoa(int(d.Seconds()))
+	}
+
+	return w.csv.Write([]string{
+		string(row.UUID),
+		string(row.ContactUUID),
+		row.ContactName,
+		string(row.Status),
+		row.Topic,
+		row.Assignee,
+		row.Ticketer,
+		row.Body,
+		formatExportTime(&row.OpenedOn),
+		formatExportTime(row.RepliedOn),
+		firstResponse,
+		formatExportTime(row.ClosedOn),
+		formatExportTime(&row.LastActivityOn),
+		string(jsonx.MustMarshal(row.Events)),
+	})
+}
+
+func (w *csvTicketExportWriter) Finish() error {
+	// an export with no tickets still gets a header
+	if !w.header {
+		if err := w.csv.Write(csvTicketExportHeader); err != nil {
+			return err
+		}
+	}
+
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func (w *csvTicketExportWriter) ContentType() string { return "text/csv" }
+
+// writes one JSON object per line per ticket, with the event history nested
+type jsonlTicketExportWriter struct {
+	w io.Writer
+}
+
+func newJSONLTicketExportWriter(w io.Writer) *jsonlTicketExportWriter {
+	return &jsonlTicketExportWriter{w: w}
+}
+
+type jsonlTicketExportRow struct {
+	*models.TicketExportRow
+
+	FirstResponseSeconds *int `json:"first_response_seconds"`
+}
+
+func (w *jsonlTicketExportWriter) Write(row *models.TicketExportRow) error {
+	r := &jsonlTicketExportRow{TicketExportRow: row}
+	if d := row.FirstResponse(); d != nil {
+		secs := int(d.Seconds())
+		r.FirstResponseSeconds = &secs
+	}
+
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(append(encoded, '\n'))
+	return err
+}
+
+func (w *jsonlTicketExportWriter) Finish() error { return nil }
+
+func (w *jsonlTicketExportWriter) ContentType() string { return "application/x-ndjson" }
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,109 @@
+package tickets_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportTickets(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis | testsuite.ResetStorage)
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Zendesk, testdata.SupportTopic, "Where my pants", "", time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC), testdata.Agent)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Zendesk, testdata.SalesTopic, "Where my shoes", "", time.Date(2021, 9, 15, 10, 0, 0, 0, time.UTC), nil)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Zendesk, testdata.SalesTopic, "Where my hat", "", time.Date(2021, 10, 5, 10, 0, 0, 0, time.UTC), nil)
+	db.MustExec(`UPDATE tickets_ticket SET replied_on = opened_on + interval '5 minutes' WHERE id = $1`, ticket1.ID)
+
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// queue a CSV export of tickets opened in September
+	task := &tickets.ExportTicketsTask{
+		UUID:   uuids.UUID("2c06d3e5-7a5c-4e0b-8f8e-4c1e7c7c3c1a"),
+		UserID: testdata.Admin.ID,
+		Format: tickets.TicketExportFormatCSV,
+		Filter: &models.TicketFilter{OpenedAfter: &start, OpenedBefore: &end},
+	}
+	err := tickets.QueueExportTicketsTask(ctx, rc, testdata.Org1.ID, task)
+	require.NoError(t, err)
+
+	progress, err := tickets.GetTicketExportProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, &tickets.TicketExportProgress{Status: tickets.TicketExportStatusQueued}, progress)
+
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err = tickets.GetTicketExportProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, tickets.TicketExportStatusCompleted, progress.Status)
+	assert.Equal(t, 2, progress.Total)
+	assert.Equal(t, 2, progress.Exported)
+	assert.Equal(t, "_test_media_storage/media/1/ticket_exports/2c06d3e5-7a5c-4e0b-8f8e-4c1e7c7c3c1a.csv", progress.URL)
+
+	content, err := os.ReadFile(progress.URL)
+	require.NoError(t, err)
+
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(records))
+	assert.Equal(t, []string{"UUID", "Contact UUID", "Contact Name", "Status", "Topic", "Assignee", "Ticketer", "Body", "Opened On", "Replied On", "First Response (Seconds)", "Closed On", "Last Activity On", "Events"}, records[0])
+	assert.Equal(t, []string{string(ticket1.UUID), string(testdata.Cathy.UUID), "Cathy", "O", "Support", "agent1@nyaruka.com", "Zendesk (Nyaruka)", "Where my pants", "2021-09-01T10:00:00Z", "2021-09-01T10:05:00Z", "300", ""}, records[1][:12])
+	assert.Equal(t, "[]", records[1][13])
+	assert.Equal(t, string(ticket2.UUID), records[2][0])
+	assert.Equal(t, "", records[2][10])
+
+	// and the user who requested it was notified
+	assertdb.Query(t, db, `SELECT count(*) FROM notifications_notification WHERE notification_type = 'export:finished' AND user_id = $1 AND scope = $2`, testdata.Admin.ID, task.UUID).Returns(1)
+
+	// export the same tickets as JSONL
+	task = &tickets.ExportTicketsTask{
+		UUID:   uuids.UUID("9f1e3b1f-2c3b-4d8e-8f5a-0e6b8a2f7d3c"),
+		UserID: testdata.Admin.ID,
+		Format: tickets.TicketExportFormatJSONL,
+		Filter: &models.TicketFilter{OpenedAfter: &start, OpenedBefore: &end},
+	}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err = tickets.GetTicketExportProgress(rc, testdata.Org1.ID, task.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, tickets.TicketExportStatusCompleted, progress.Status)
+	assert.Equal(t, "_test_media_storage/media/1/ticket_exports/9f1e3b1f-2c3b-4d8e-8f5a-0e6b8a2f7d3c.jsonl", progress.URL)
+
+	content, err = os.ReadFile(progress.URL)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Equal(t, 2, len(lines))
+
+	exported := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &exported))
+	assert.Equal(t, string(ticket1.UUID), exported["uuid"])
+	assert.Equal(t, "Support", exported["topic"])
+	assert.Equal(t, float64(300), exported["first_response_seconds"])
+	assert.Equal(t, []interface{}{}, exported["events"])
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &exported))
+	assert.Equal(t, string(ticket2.UUID), exported["uuid"])
+	assert.Nil(t, exported["first_response_seconds"])
+
+	assertdb.Query(t, db, `SELECT count(*) FROM notifications_notification WHERE notification_type = 'export:finished'`).Returns(2)
+}
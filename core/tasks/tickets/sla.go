@@ -0,0 +1,123 @@
+package tickets
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	mailroom.RegisterCron("check_ticket_slas", time.Minute, false, CheckTicketSLAs)
+}
+
+// CheckTicketSLAs looks for open tickets which have breached the SLA policy of their topic, records the breaches and
+// escalates the tickets according to the policy
+func CheckTicketSLAs(ctx context.Context, rt *runtime.Runtime) error {
+	log := logrus.WithField("comp", "ticket_sla")
+	start := time.Now()
+
+	orgIDs, err := models.GetOrgIDsWithOpenTickets(ctx, rt.DB)
+	if err != nil {
+		return errors.Wrap(err, "error fetching orgs with open tickets")
+	}
+
+	numBreached := 0
+
+	for _, orgID := range orgIDs {
+		// an org with a bad policy shouldn't stop us checking other orgs
+		num, err := checkOrgTicketSLAs(ctx, rt, orgID)
+		if err != nil {
+			log.WithError(err).WithField("org_id", orgID).Error("error checking ticket SLAs")
+		}
+		numBreached += num
+	}
+
+	log.WithField("elapsed", time.Since(start)).WithField("breached", numBreached).Info("ticket SLAs checked")
+	return nil
+}
+
+func checkOrgTicketSLAs(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) (int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error loading org assets")
+	}
+
+	policies, err := oa.Org().TicketSLAPolicies()
+	if err != nil {
+		return 0, err
+	}
+
+	numBreached := 0
+
+	for topicUUID, policy := range policies {
+		topic := oa.TopicByUUID(topicUUID)
+		if topic == nil {
+			continue
+		}
+
+		for _, breach := range []models.TicketSLABreach{models.TicketSLABreachFirstResponse, models.TicketSLABreachIdle} {
+			limit := policy.Limit(breach)
+			if limit <= 0 {
+				continue
+			}
+
+			tickets, err := models.LoadTicketsBreachingSLA(ctx, rt.DB, orgID, topic.ID(), breach, limit)
+			if err != nil {
+				return numBreached, errors.Wrapf(err, "error loading tickets breaching %s SLA", breach)
+			}
+			if len(tickets) == 0 {
+				continue
+			}
+
+			if err := escalateTickets(ctx, rt, oa, policy, tickets, breach, limit); err != nil {
+				return numBreached, errors.Wrapf(err, "error escalating tickets breaching %s SLA", breach)
+			}
+
+			numBreached += len(tickets)
+		}
+	}
+
+	return numBreached, nil
+}
+
+// records the breach on each ticket, takes the action configured by the policy and then marks the tickets as having
+// breached in their current window
+func escalateTickets(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, policy *models.TicketSLAPolicy, tickets []*models.Ticket, breach models.TicketSLABreach, limit time.Duration) error {
+	// check the policy can be applied before recording anything, otherwise we'd record the breach again every time
+	var assignee *models.User
+	if policy.Action == models.TicketSLAActionAssign {
+		assignee = oa.UserByEmail(policy.Assignee)
+		if assignee == nil {
+			return errors.Errorf("no such user with email %s", policy.Assignee)
+		}
+	}
+
+	events, err := models.TicketsRecordSLABreach(ctx, rt.DB, tickets, breach, limit)
+	if err != nil {
+		return err
+	}
+
+	note := string(events[tickets[0]].Note())
+
+	switch policy.Action {
+	case models.TicketSLAActionAssign:
+		_, err = models.TicketsAssign(ctx, rt.DB, oa, models.NilUserID, tickets, assignee.ID(), note)
+
+	case models.TicketSLAActionNote:
+		_, err = models.TicketsAddNote(ctx, rt.DB, oa, models.NilUserID, tickets, note)
+
+	case models.TicketSLAActionNotify:
+		err = models.NotificationsFromTicketEvents(ctx, rt.DB, oa, events)
+	}
+	if err != nil {
+		return err
+	}
+
+	// assigning and adding notes are ticket activity, so this has to happen afterwards for idle breaches to include it
+	return models.TicketsSetSLABreached(ctx, rt.DB, tickets, breach)
+}
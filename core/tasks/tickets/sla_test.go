@@ -0,0 +1,122 @@
+package tickets_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTicketSLAs(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// support tickets get reassigned if not replied to within an hour, sales tickets get a notification if idle for a day
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_sla": {
+		"0a8f2e00-fef6-402c-bd79-d789446ec0e0": {"first_response": 3600, "action": "assign", "assignee": "admin1@nyaruka.com"},
+		"9ef2ff21-064a-41f1-8560-ccc990b4f937": {"idle": 86400, "action": "notify"}
+	}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	now := time.Now()
+
+	ticket1 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-2*time.Hour), testdata.Agent)
+	ticket2 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Where my shoes", "", now.Add(-30*time.Minute), nil)
+	ticket3 := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Where my hat", "", now.Add(-48*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket3.ID, now.Add(-25*time.Hour))
+
+	err := tickets.CheckTicketSLAs(ctx, rt)
+	require.NoError(t, err)
+
+	// first ticket breached and was reassigned
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B'`, ticket1.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'A' AND note = 'No response within SLA of 1h0m0s'`, ticket1.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT assignee_id FROM tickets_ticket WHERE id = $1`, ticket1.ID).Returns(int64(testdata.Admin.ID))
+
+	// second ticket is still within its SLA
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1`, ticket2.ID).Returns(0)
+
+	// third ticket breached and is unassigned so all assignable users are notified
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B'`, ticket3.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM notifications_notification WHERE notification_type = 'tickets:activity'`).Returns(3)
+
+	// checking again doesn't find any new breaches
+	err = tickets.CheckTicketSLAs(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE event_type = 'B'`).Returns(2)
+}
+
+func TestCheckTicketSLAsEscalatedOncePerWindow(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	// support tickets get a note if idle for an hour
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_sla": {
+		"0a8f2e00-fef6-402c-bd79-d789446ec0e0": {"idle": 3600, "action": "note"}
+	}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	now := time.Now()
+	dates.SetNowSource(dates.NewFixedNowSource(now))
+
+	ticket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", now.Add(-3*time.Hour), nil)
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket.ID, now.Add(-2*time.Hour))
+
+	err := tickets.CheckTicketSLAs(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B'`, ticket.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'N'`, ticket.ID).Returns(1)
+
+	// adding the note was activity on the ticket, but it's part of the window which already breached so the ticket isn't
+	// escalated again when another hour passes
+	dates.SetNowSource(dates.NewFixedNowSource(now.Add(2 * time.Hour)))
+
+	err = tickets.CheckTicketSLAs(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B'`, ticket.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'N'`, ticket.ID).Returns(1)
+
+	// until there's some other activity on the ticket which starts a new window
+	db.MustExec(`UPDATE tickets_ticket SET last_activity_on = $2 WHERE id = $1`, ticket.ID, now.Add(30*time.Minute))
+
+	err = tickets.CheckTicketSLAs(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'B'`, ticket.ID).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'N'`, ticket.ID).Returns(2)
+}
+
+func TestCheckTicketSLAsWithInvalidAssignee(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// support tickets get reassigned to a user who doesn't exist
+	db.MustExec(`UPDATE orgs_org SET config = '{"ticket_sla": {
+		"0a8f2e00-fef6-402c-bd79-d789446ec0e0": {"first_response": 3600, "action": "assign", "assignee": "nobody@nyaruka.com"}
+	}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	ticket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Where my pants", "", time.Now().Add(-2*time.Hour), nil)
+
+	// the policy can't be applied so nothing is recorded, rather than a breach being recorded every time we check
+	for i := 0; i < 2; i++ {
+		err := tickets.CheckTicketSLAs(ctx, rt)
+		require.NoError(t, err)
+	}
+
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1`, ticket.ID).Returns(0)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/utils/cron"
+	"github.com/nyaruka/mailroom/utils/tracing"
 	"github.com/nyaruka/mailroom/web"
 	"github.com/pkg/errors"
 
@@ -28,14 +29,25 @@ type InitFunction func(*runtime.Runtime, *sync.WaitGroup, chan bool) error
 
 var initFunctions = make([]InitFunction, 0)
 
-func addInitFunction(initFunc InitFunction) {
+// AddInitFunction adds a function that will be called when mailroom starts
+func AddInitFunction(initFunc InitFunction) {
 	initFunctions = append(initFunctions, initFunc)
 }
 
 // RegisterCron registers a new cron function to run every interval
 func RegisterCron(name string, interval time.Duration, allInstances bool, fn cron.Function) {
-	addInitFunction(func(rt *runtime.Runtime, wg *sync.WaitGroup, quit chan bool) error {
-		cron.Start(rt, wg, name, interval, allInstances, fn, time.Minute*5, quit)
+	registerCronSchedule(name, cron.Every(interval), allInstances, fn)
+}
+
+// RegisterCronExpression registers a new cron function to run according to the given cron expression, e.g.
+// "0 */6 * * *" to run every six hours. Panics if the expression isn't valid.
+func RegisterCronExpression(name string, expression string, allInstances bool, fn cron.Function) {
+	registerCronSchedule(name, cron.MustParseExpression(expression), allInstances, fn)
+}
+
+func registerCronSchedule(name string, schedule cron.Schedule, allInstances bool, fn cron.Function) {
+	AddInitFunction(func(rt *runtime.Runtime, wg *sync.WaitGroup, quit chan bool) error {
+		cron.Start(rt, wg, name, schedule, allInstances, fn, time.Minute*5, quit)
 		return nil
 	})
 }
@@ -45,11 +57,20 @@ type TaskFunction func(ctx context.Context, rt *runtime.Runtime, task *queue.Tas
 
 var taskFunctions = make(map[string]TaskFunction)
 
+var retryableTasks = make(map[string]bool)
+
 // AddTaskFunction adds an task function that will be called for a type of task
 func AddTaskFunction(taskType string, taskFunc TaskFunction) {
 	taskFunctions[taskType] = taskFunc
 }
 
+// AddRetryableTaskFunction adds a task function for a type of task which is idempotent and so can be safely retried
+// from the start if it fails. Failed tasks of other types are moved straight to the dead letter queue.
+func AddRetryableTaskFunction(taskType string, taskFunc TaskFunction) {
+	AddTaskFunction(taskType, taskFunc)
+	retryableTasks[taskType] = true
+}
+
 // Mailroom is a service for handling RapidPro events
 type Mailroom struct {
 	ctx    context.Context
@@ -63,6 +84,8 @@ type Mailroom struct {
 	handlerForeman *Foreman
 
 	webserver *web.Server
+
+	stopTracing func(context.Context) error
 }
 
 // NewMailroom creates and returns a new mailroom instance
@@ -73,8 +96,8 @@ func NewMailroom(config *runtime.Config) *Mailroom {
 		wg:   &sync.WaitGroup{},
 	}
 	mr.ctx, mr.cancel = context.WithCancel(context.Background())
-	mr.batchForeman = NewForeman(mr.rt, mr.wg, queue.BatchQueue, config.BatchWorkers)
-	mr.handlerForeman = NewForeman(mr.rt, mr.wg, queue.HandlerQueue, config.HandlerWorkers)
+	mr.batchForeman = NewForeman(mr.rt, mr.wg, queue.BatchQueue, config.BatchWorkers, true)
+	mr.handlerForeman = NewForeman(mr.rt, mr.wg, queue.HandlerQueue, config.HandlerWorkers, false)
 
 	return mr
 }
@@ -86,6 +109,14 @@ func (mr *Mailroom) Start() error {
 	log := logrus.WithFields(logrus.Fields{"state": "starting"})
 
 	var err error
+	mr.stopTracing, err = tracing.Start(c)
+	if err != nil {
+		return err
+	}
+	if c.TracingExporter != "none" && c.TracingExporter != "" {
+		log.WithField("exporter", c.TracingExporter).Info("tracing ok")
+	}
+
 	mr.rt.DB, err = openAndCheckDBConnection(c.DB, c.DBPoolSize)
 	if err != nil {
 		log.WithError(err).Error("db not reachable")
@@ -114,8 +145,9 @@ func (mr *Mailroom) Start() error {
 	}
 
 	// create our storage (S3 or file system)
-	if mr.rt.Config.AWSAccessKeyID != "" {
-		s3Client, err := storage.NewS3Client(&storage.S3Options{
+	var s3Client storage.S3Client
+	if c.AWSAccessKeyID != "" {
+		s3Client, err = storage.NewS3Client(&storage.S3Options{
 			AWSAccessKeyID:     c.AWSAccessKeyID,
 			AWSSecretAccessKey: c.AWSSecretAccessKey,
 			Endpoint:           c.S3Endpoint,
@@ -127,13 +159,13 @@ func (mr *Mailroom) Start() error {
 		if err != nil {
 			return err
 		}
-		mr.rt.MediaStorage = storage.NewS3(s3Client, mr.rt.Config.S3MediaBucket, c.S3Region, 32)
-		mr.rt.SessionStorage = storage.NewS3(s3Client, mr.rt.Config.S3SessionBucket, c.S3Region, 32)
+		mr.rt.MediaStorage = storage.NewS3(s3Client, c.S3MediaBucket, c.S3Region, 32)
 	} else {
 		mr.rt.MediaStorage = storage.NewFS("_storage")
-		mr.rt.SessionStorage = storage.NewFS("_storage")
 	}
 
+	mr.rt.SessionStorage = newSessionStorage(c, s3Client)
+
 	// test our media storage
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	err = mr.rt.MediaStorage.Test(ctx)
@@ -216,10 +248,31 @@ func (mr *Mailroom) Stop() error {
 		mr.rt.ES.Stop()
 	}
 
+	// flush any pending spans
+	if mr.stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := mr.stopTracing(ctx); err != nil {
+			logrus.WithError(err).Error("error flushing traces")
+		}
+	}
+
 	logrus.Info("mailroom stopped")
 	return nil
 }
 
+// creates the storage for session output based on the configured session storage mode. Sessions will still be read
+// from S3 when in db mode if we have S3 credentials, as older sessions may have been written there.
+func newSessionStorage(c *runtime.Config, s3Client storage.S3Client) storage.Storage {
+	if c.SessionStorage != "fs" && s3Client != nil {
+		return storage.NewS3(s3Client, c.S3SessionBucket, c.S3Region, 32)
+	}
+	if c.SessionStorage == "s3" {
+		logrus.Warn("no S3 credentials configured, session output will be written to the file system")
+	}
+	return storage.NewFS(c.SessionStoragePath)
+}
+
 func openAndCheckDBConnection(url string, maxOpenConns int) (*sqlx.DB, error) {
 	db, err := sqlx.Open("postgres", url)
 	if err != nil {
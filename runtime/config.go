@@ -13,7 +13,9 @@ import (
 )
 
 func init() {
-	utils.RegisterValidatorAlias("session_storage", "eq=db|eq=s3", func(e validator.FieldError) string { return "is not a valid session storage mode" })
+	utils.RegisterValidatorAlias("session_storage", "eq=db|eq=s3|eq=fs", func(e validator.FieldError) string { return "is not a valid session storage mode" })
+	utils.RegisterValidatorAlias("tracing_exporter", "eq=none|eq=stdout|eq=otlp", func(e validator.FieldError) string { return "is not a valid tracing exporter" })
+	utils.RegisterValidatorAlias("session_compression", "eq=none|eq=gzip|eq=zstd", func(e validator.FieldError) string { return "is not a valid session compression type" })
 }
 
 // Config is our top level configuration object
@@ -32,8 +34,11 @@ type Config struct {
 	AttachmentDomain string `help:"the domain that will be used for relative attachment"`
 
 	BatchWorkers         int  `help:"the number of go routines that will be used to handle batch events"`
+	BatchTaskRetries     int  `help:"the number of times to retry a failed batch task of an idempotent type before moving it to the dead letter queue"`
+	BatchTaskBackoff     int  `help:"the initial backoff in milliseconds when retrying a failed batch task"`
 	HandlerWorkers       int  `help:"the number of go routines that will be used to handle messages"`
 	RetryPendingMessages bool `help:"whether to requeue pending messages older than five minutes to retry"`
+	ShutdownDrainTimeout int  `help:"the timeout in milliseconds to wait on shutdown for in-flight tasks to finish before they are cancelled and requeued"`
 
 	WebhooksTimeout              int     `help:"the timeout in milliseconds for webhook calls from engine"`
 	WebhooksMaxRetries           int     `help:"the number of times to retry a failed webhook call"`
@@ -47,7 +52,10 @@ type Config struct {
 	MaxStepsPerSprint    int    `help:"the maximum number of steps allowed per engine sprint"`
 	MaxResumesPerSession int    `help:"the maximum number of resumes allowed per engine session"`
 	MaxValueLength       int    `help:"the maximum size in characters for contact field values and run result values"`
-	SessionStorage       string `validate:"omitempty,session_storage"         help:"where to store session output (s3|db)"`
+	SessionStorage       string `validate:"omitempty,session_storage"         help:"where to store session output (db|s3|fs)"`
+
+	SessionStoragePath        string `help:"the directory session output will be written to when using fs session storage"`
+	SessionStorageCompression string `validate:"omitempty,session_compression" help:"the compression applied to session output written to s3 or fs storage (none|gzip|zstd)"`
 
 	S3Endpoint       string `help:"the S3 endpoint we will write attachments to"`
 	S3Region         string `help:"the S3 region we will write attachments to"`
@@ -64,8 +72,15 @@ type Config struct {
 	LibratoUsername string `help:"the username that will be used to authenticate to Librato"`
 	LibratoToken    string `help:"the token that will be used to authenticate to Librato"`
 
-	FCMKey            string `help:"the FCM API key used to notify Android relayers to sync"`
-	MailgunSigningKey string `help:"the signing key used to validate requests from mailgun"`
+	TracingExporter   string  `validate:"omitempty,tracing_exporter" help:"the exporter to use for OpenTelemetry traces (none|stdout|otlp)"`
+	TracingEndpoint   string  `help:"the host and port of the OTLP HTTP endpoint traces are exported to"`
+	TracingInsecure   bool    `help:"whether to export traces to the OTLP endpoint over plain HTTP"`
+	TracingSampleRate float64 `help:"the fraction of new traces which are sampled, from 0 to 1"`
+
+	FCMKey                     string `help:"the FCM API key used to notify Android relayers to sync"`
+	MailgunSigningKey          string `help:"the signing key used to validate requests from mailgun"`
+	SMTPReceiveAddress         string `help:"the address to listen on for ticket reply emails sent over SMTP, e.g. :2525, leave empty to disable"`
+	SMTPReceiveAllowedNetworks string `help:"comma separated list of IP addresses and networks of the mail relays allowed to send ticket reply emails over SMTP"`
 
 	InstanceName string `help:"the unique name of this instance used for analytics"`
 	LogLevel     string `help:"the logging level courier should use"`
@@ -90,8 +105,11 @@ func NewDefaultConfig() *Config {
 		Port:    8090,
 
 		BatchWorkers:         4,
+		BatchTaskRetries:     3,
+		BatchTaskBackoff:     10000,
 		HandlerWorkers:       32,
 		RetryPendingMessages: true,
+		ShutdownDrainTimeout: 30000,
 
 		WebhooksTimeout:              15000,
 		WebhooksMaxRetries:           2,
@@ -107,6 +125,9 @@ func NewDefaultConfig() *Config {
 		MaxValueLength:       640,
 		SessionStorage:       "db",
 
+		SessionStoragePath:        "_storage",
+		SessionStorageCompression: "none",
+
 		S3Endpoint:       "https://s3.amazonaws.com",
 		S3Region:         "us-east-1",
 		S3MediaBucket:    "mailroom-media",
@@ -119,6 +140,13 @@ func NewDefaultConfig() *Config {
 		AWSAccessKeyID:     "",
 		AWSSecretAccessKey: "",
 
+		TracingExporter:   "none",
+		TracingEndpoint:   "localhost:4318",
+		TracingInsecure:   false,
+		TracingSampleRate: 1.0,
+
+		SMTPReceiveAllowedNetworks: `127.0.0.1,::1`,
+
 		InstanceName: hostname,
 		LogLevel:     "error",
 		UUIDSeed:     0,
@@ -137,12 +165,24 @@ func (c *Config) Validate() error {
 	if _, _, err := c.ParseDisallowedNetworks(); err != nil {
 		return errors.Wrap(err, "unable to parse 'DisallowedNetworks'")
 	}
+	if _, _, err := c.ParseSMTPReceiveAllowedNetworks(); err != nil {
+		return errors.Wrap(err, "unable to parse 'SMTPReceiveAllowedNetworks'")
+	}
 	return nil
 }
 
 // ParseDisallowedNetworks parses the list of IPs and IP networks (written in CIDR notation)
 func (c *Config) ParseDisallowedNetworks() ([]net.IP, []*net.IPNet, error) {
-	addrs, err := csv.NewReader(strings.NewReader(c.DisallowedNetworks)).Read()
+	return parseNetworks(c.DisallowedNetworks)
+}
+
+// ParseSMTPReceiveAllowedNetworks parses the list of IPs and IP networks (written in CIDR notation) of allowed relays
+func (c *Config) ParseSMTPReceiveAllowedNetworks() ([]net.IP, []*net.IPNet, error) {
+	return parseNetworks(c.SMTPReceiveAllowedNetworks)
+}
+
+func parseNetworks(list string) ([]net.IP, []*net.IPNet, error) {
+	addrs, err := csv.NewReader(strings.NewReader(list)).Read()
 	if err != nil && err != io.EOF {
 		return nil, nil, err
 	}
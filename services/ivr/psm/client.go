@@ -0,0 +1,78 @@
+package psm
+
+// CallRequest is the request payload to create a new call
+type CallRequest struct {
+	To               string `json:"to"`
+	From             string `json:"from"`
+	HandleURL        string `json:"handle_url"`
+	StatusURL        string `json:"status_url"`
+	MachineDetection bool   `json:"machine_detection,omitempty"`
+}
+
+// CallResponse is the response from creating a new call
+// {
+//  "call_id": "a2b5c0e6-9f6c-4a4e-8a5b-8d9c1f7e2b3d",
+//  "status": "queued"
+// }
+type CallResponse struct {
+	CallID string `json:"call_id" validate:"required"`
+	Status string `json:"status"`
+}
+
+// CallbackRequest is the JSON payload PSM posts to our handle URL
+type CallbackRequest struct {
+	CallID       string `json:"call_id"`
+	URN          string `json:"urn"`
+	Digits       string `json:"digits"`
+	TimedOut     bool   `json:"timed_out"`
+	RecordingURL string `json:"recording_url"`
+	DialStatus   string `json:"dial_status"`
+	DialDuration int    `json:"dial_duration"`
+}
+
+type Say struct {
+	Command  string `json:"command"`
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+}
+
+type Play struct {
+	Command string `json:"command"`
+	URL     string `json:"url"`
+}
+
+type Gather struct {
+	Command     string        `json:"command"`
+	MaxDigits   int           `json:"max_digits,omitempty"`
+	FinishOnKey string        `json:"finish_on_key,omitempty"`
+	Timeout     int           `json:"timeout"`
+	Action      string        `json:"action"`
+	Commands    []interface{} `json:"commands"`
+}
+
+type Record struct {
+	Command   string `json:"command"`
+	MaxLength int    `json:"max_length"`
+	Action    string `json:"action"`
+}
+
+type Dial struct {
+	Command string `json:"command"`
+	Number  string `json:"number"`
+	Action  string `json:"action"`
+}
+
+type Redirect struct {
+	Command string `json:"command"`
+	URL     string `json:"url"`
+}
+
+type Hangup struct {
+	Command string `json:"command"`
+}
+
+// Response is the list of commands we return to PSM for it to execute on a call
+type Response struct {
+	Message  string        `json:"message,omitempty"`
+	Commands []interface{} `json:"commands"`
+}
@@ -3,55 +3,131 @@ package psm
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/routers/waits/hints"
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
-	"github.com/sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
-	"strconv"
 
 	"github.com/buger/jsonparser"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+// IgnoreSignatures controls whether we ignore signatures (public for testing overriding)
+var IgnoreSignatures = false
+
+var dialStatusMap = map[string]flows.DialStatus{
+	"answered":  flows.DialStatusAnswered,
+	"completed": flows.DialStatusAnswered,
+	"busy":      flows.DialStatusBusy,
+	"no-answer": flows.DialStatusNoAnswer,
+	"failed":    flows.DialStatusFailed,
+	"canceled":  flows.DialStatusFailed,
+}
+
 const (
 	psmChannelType = models.ChannelType("PSM")
+
+	callPath   = "/calls"
+	hangupPath = "/calls/%s/hangup"
+
+	signatureHeader = "X-PSM-Signature"
+
+	statusFailed = "failed"
+
+	gatherTimeout = 30
+	recordTimeout = 600
+
+	baseURLConfig   = "base_url"
+	authTokenConfig = "auth_token"
 )
 
 type service struct {
-	channel *models.Channel
+	httpClient *http.Client
+	baseURL    string
+	authToken  string
+	address    string
 }
 
 func init() {
-	ivr.RegisterServiceType(psmChannelType, NewClientFromChannel)
+	ivr.RegisterServiceType(psmChannelType, NewServiceFromChannel)
+}
+
+// NewServiceFromChannel creates a new PSM IVR service for the passed in channel. Older PSM channels only receive
+// incoming calls and have no API base URL or auth token, so these are only required to make outgoing calls.
+func NewServiceFromChannel(httpClient *http.Client, channel *models.Channel) (ivr.Service, error) {
+	baseURL := channel.ConfigValue(baseURLConfig, "")
+	authToken := channel.ConfigValue(authTokenConfig, "")
+
+	return NewService(httpClient, baseURL, authToken, channel.Address()), nil
 }
 
-// NewClientFromChannel creates a new Twilio IVR service for the passed in account and auth token
-func NewClientFromChannel(httpClient *http.Client, channel *models.Channel) (ivr.Service, error) {
+// NewService creates a new PSM IVR service for the given API base URL, auth token and channel address
+func NewService(httpClient *http.Client, baseURL string, authToken string, address string) ivr.Service {
 	return &service{
-		channel: channel,
-	}, nil
+		httpClient: httpClient,
+		baseURL:    baseURL,
+		authToken:  authToken,
+		address:    address,
+	}
 }
 
 func readBody(r *http.Request) ([]byte, error) {
-	if r.Body == http.NoBody {
+	if r.Body == http.NoBody || r.Body == nil {
 		return nil, nil
 	}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 	return body, nil
 }
 
+// readCallback reads the JSON callback payload from the passed in request, which may be empty. Status callbacks
+// are posted as form fields and so have no payload.
+func readCallback(r *http.Request) (*CallbackRequest, error) {
+	callback := &CallbackRequest{}
+	if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		return callback, nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading body from request")
+	}
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, callback); err != nil {
+			return nil, errors.Wrapf(err, "invalid json body")
+		}
+	}
+	return callback, nil
+}
+
+// DownloadMedia downloads the media at the given URL, which for recordings requires our auth token
 func (s *service) DownloadMedia(url string) (*http.Response, error) {
-	return http.Get(url)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if s.authToken != "" {
+		req.Header.Set("Authorization", "Token "+s.authToken)
+	}
+	return s.httpClient.Do(req)
 }
 
 func (s *service) CheckStartRequest(r *http.Request) models.ConnectionError {
@@ -64,7 +140,13 @@ func (s *service) CheckStartRequest(r *http.Request) models.ConnectionError {
 }
 
 func (s *service) CallIDForRequest(r *http.Request) (string, error) {
-	return "", nil
+	callback, err := readCallback(r)
+	if err != nil {
+		return "", err
+	}
+
+	// incoming calls from older PSM channels don't include a call id
+	return callback.CallID, nil
 }
 
 func (s *service) URNForRequest(r *http.Request) (urns.URN, error) {
@@ -89,29 +171,114 @@ func (s *service) PreprocessStatus(ctx context.Context, rt *runtime.Runtime, r *
 	return nil, nil
 }
 
+// PreprocessResume has nothing to do for PSM as it posts gathered digits and recordings directly to the resume URL
 func (s *service) PreprocessResume(ctx context.Context, rt *runtime.Runtime, conn *models.ChannelConnection, r *http.Request) ([]byte, error) {
 	return nil, nil
 }
 
 // RequestCall causes this service to request a new outgoing call for this provider
 func (s *service) RequestCall(number urns.URN, handleURL string, statusURL string, machineDetection bool) (ivr.CallID, *httpx.Trace, error) {
-	return "", nil, nil
+	if err := s.checkAPIConfig(); err != nil {
+		return ivr.NilCallID, nil, err
+	}
+
+	callR := &CallRequest{
+		To:               number.Path(),
+		From:             s.address,
+		HandleURL:        handleURL,
+		StatusURL:        statusURL,
+		MachineDetection: machineDetection,
+	}
+
+	trace, err := s.postRequest(s.baseURL+callPath, callR)
+	if err != nil {
+		return ivr.NilCallID, trace, errors.Wrapf(err, "error trying to start call")
+	}
+
+	if trace.Response.StatusCode != http.StatusCreated {
+		return ivr.NilCallID, trace, errors.Errorf("received non 201 status for call start: %d", trace.Response.StatusCode)
+	}
+
+	// parse the response from PSM
+	call := &CallResponse{}
+	if err := utils.UnmarshalAndValidate(trace.ResponseBody, call); err != nil {
+		return ivr.NilCallID, trace, errors.Wrap(err, "unable parse PSM response")
+	}
+	if call.Status == statusFailed {
+		return ivr.NilCallID, trace, errors.Errorf("call status returned as failed")
+	}
+
+	return ivr.CallID(call.CallID), trace, nil
 }
 
 // HangupCall asks PSM to hang up the call that is passed in
 func (s *service) HangupCall(callID string) (*httpx.Trace, error) {
-	return nil, nil
+	if err := s.checkAPIConfig(); err != nil {
+		return nil, err
+	}
+
+	trace, err := s.postRequest(s.baseURL+fmt.Sprintf(hangupPath, callID), map[string]string{})
+	if err != nil {
+		return trace, errors.Wrapf(err, "error trying to hangup call")
+	}
+
+	if trace.Response.StatusCode != http.StatusOK {
+		return trace, errors.Errorf("received non 200 status for call hangup: %d", trace.Response.StatusCode)
+	}
+
+	return trace, nil
 }
 
-// InputForRequest returns the input for the passed in request, if any
-func (s *service) InputForRequest(r *http.Request) (string, utils.Attachment, error) {
-	return "", utils.Attachment(""), nil
+// ResumeForRequest returns the resume (input or dial) for the passed in request, if any
+func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
+	// this could be a timeout or empty, in which case we return an empty input
+	if r.Form.Get("timeout") == "true" || r.Form.Get("empty") == "true" {
+		return ivr.InputResume{}, nil
+	}
+
+	callback, err := readCallback(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// otherwise grab the right field based on our wait type
+	waitType := r.Form.Get("wait_type")
+	switch waitType {
+	case "gather":
+		if callback.TimedOut {
+			return ivr.InputResume{}, nil
+		}
+		return ivr.InputResume{Input: callback.Digits}, nil
+
+	case "record":
+		if callback.RecordingURL == "" {
+			return ivr.InputResume{}, nil
+		}
+		return ivr.InputResume{Attachment: utils.Attachment("audio:" + callback.RecordingURL)}, nil
+
+	case "dial":
+		status := dialStatusMap[callback.DialStatus]
+		if status == "" {
+			return nil, errors.Errorf("unknown PSM dial_status in callback: %s", callback.DialStatus)
+		}
+		return ivr.DialResume{Status: status, Duration: callback.DialDuration}, nil
+
+	default:
+		return nil, errors.Errorf("unknown wait_type: %s", waitType)
+	}
 }
 
 // StatusForRequest returns the call status for the passed in request, and if it's an error the reason,
 // and if available, the current call duration
 func (s *service) StatusForRequest(r *http.Request) (models.ConnectionStatus, models.ConnectionError, int) {
+	r.ParseForm()
 	status := r.Form.Get("CallStatus")
+
+	// resumes don't include a status if the call is still in progress
+	if status == "" && r.Form.Get("action") == "resume" {
+		return models.ConnectionStatusInProgress, "", 0
+	}
+
 	switch status {
 
 	case "queued", "ringing":
@@ -137,17 +304,81 @@ func (s *service) StatusForRequest(r *http.Request) (models.ConnectionStatus, mo
 
 // ValidateRequestSignature validates the signature on the passed in request, returning an error if it is invaled
 func (s *service) ValidateRequestSignature(r *http.Request) error {
+	// shortcut for testing, and channels without an auth token can't have signed requests
+	if IgnoreSignatures || s.authToken == "" {
+		return nil
+	}
+
+	actual := r.Header.Get(signatureHeader)
+	if actual == "" {
+		return errors.Errorf("missing request signature header")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return errors.Wrapf(err, "error reading body from request")
+	}
+
+	path := r.URL.RequestURI()
+	proxyPath := r.Header.Get("X-Forwarded-Path")
+	if proxyPath != "" {
+		path = proxyPath
+	}
+
+	url := fmt.Sprintf("https://%s%s", r.Host, path)
+	expected := calculateSignature(url, body, s.authToken)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal(expected, []byte(actual)) {
+		return errors.Errorf("invalid request signature: %s", actual)
+	}
+
 	return nil
 }
 
-// WriteSessionResponse writes a TWIML response for the events in the passed in session
+// WriteSessionResponse writes a PSM response for the events in the passed in session
 func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, conn *models.ChannelConnection, session *models.Session, number urns.URN, resumeURL string, req *http.Request, w http.ResponseWriter) error {
+	// for errored sessions we should just output our error body
+	if session.Status() == models.SessionStatusFailed {
+		return errors.Errorf("cannot write IVR response for failed session")
+	}
+
+	// otherwise look for any say events
+	sprint := session.Sprint()
+	if sprint == nil {
+		return errors.Errorf("cannot write IVR response for session with no sprint")
+	}
+
+	// get our response
+	response, err := ResponseForSprint(rt.Config, number, resumeURL, sprint.Events(), true)
+	if err != nil {
+		return errors.Wrap(err, "unable to build response for IVR call")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte(response))
+	if err != nil {
+		return errors.Wrap(err, "error writing IVR response")
+	}
+
 	return nil
 }
 
 // WriteErrorResponse writes an error / unavailable response
 func (s *service) WriteErrorResponse(w http.ResponseWriter, err error) error {
-	return nil
+	r := &Response{
+		Message:  err.Error(),
+		Commands: []interface{}{Say{Command: "say", Text: ivr.ErrorMessage}, Hangup{Command: "hangup"}},
+	}
+
+	body, err := jsonx.Marshal(r)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling error response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	return err
 }
 
 // WriteEmptyResponse writes an empty (but valid) response
@@ -160,6 +391,7 @@ func (s *service) WriteEmptyResponse(w http.ResponseWriter, msg string) error {
 		return errors.Wrapf(err, "error marshalling message")
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(body)
 	return err
 }
@@ -197,6 +429,108 @@ func (s *service) EventForCallDataRequest(r *http.Request) (models.ChannelEventT
 	return "", 0
 }
 
-func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
-	return nil, nil
+// checks that we have the API base URL and auth token needed to make calls
+func (s *service) checkAPIConfig() error {
+	if s.baseURL == "" || s.authToken == "" {
+		return errors.Errorf("missing %s or %s in channel config", baseURLConfig, authTokenConfig)
+	}
+	return nil
+}
+
+func (s *service) postRequest(sendURL string, body interface{}) (*httpx.Trace, error) {
+	req, _ := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(jsonx.MustMarshal(body)))
+	req.Header.Set("Authorization", "Token "+s.authToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	return httpx.DoTrace(s.httpClient, req, nil, nil, -1)
+}
+
+// calculateSignature calculates the signature PSM sends for a request to the given URL with the given body, which
+// is the base64 encoded HMAC-SHA256 of the URL followed by the body, keyed with the channel's auth token
+func calculateSignature(url string, body []byte, authToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write([]byte(url))
+	mac.Write(body)
+	hash := mac.Sum(nil)
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(hash)))
+	base64.StdEncoding.Encode(encoded, hash)
+
+	return encoded
+}
+
+// ResponseForSprint builds the PSM response for the passed in sprint events
+func ResponseForSprint(cfg *runtime.Config, number urns.URN, resumeURL string, es []flows.Event, indent bool) (string, error) {
+	r := &Response{}
+	commands := make([]interface{}, 0)
+	hasWait := false
+
+	for _, e := range es {
+		switch event := e.(type) {
+		case *events.IVRCreatedEvent:
+			if len(event.Msg.Attachments()) == 0 {
+				say := Say{Command: "say", Text: event.Msg.Text()}
+				if event.Msg.TextLanguage != envs.NilLanguage {
+					country := envs.DeriveCountryFromTel(number.Path())
+					say.Language = envs.NewLocale(event.Msg.TextLanguage, country).ToBCP47()
+				}
+				commands = append(commands, say)
+			} else {
+				for _, a := range event.Msg.Attachments() {
+					a = models.NormalizeAttachment(cfg, a)
+					commands = append(commands, Play{Command: "play", URL: a.URL()})
+				}
+			}
+
+		case *events.MsgWaitEvent:
+			hasWait = true
+			switch hint := event.Hint.(type) {
+			case *hints.DigitsHint:
+				resumeURL = resumeURL + "&wait_type=gather"
+				gather := Gather{
+					Command:     "gather",
+					FinishOnKey: hint.TerminatedBy,
+					Timeout:     gatherTimeout,
+					Action:      resumeURL,
+					Commands:    commands,
+				}
+				if hint.Count != nil {
+					gather.MaxDigits = *hint.Count
+				}
+				commands = []interface{}{gather, Redirect{Command: "redirect", URL: resumeURL + "&timeout=true"}}
+
+			case *hints.AudioHint:
+				resumeURL = resumeURL + "&wait_type=record"
+				commands = append(commands, Record{Command: "record", MaxLength: recordTimeout, Action: resumeURL})
+				commands = append(commands, Redirect{Command: "redirect", URL: resumeURL + "&empty=true"})
+
+			default:
+				return "", errors.Errorf("unable to use hint in IVR call, unknown type: %s", event.Hint.Type())
+			}
+
+		case *events.DialWaitEvent:
+			hasWait = true
+			commands = append(commands, Dial{Command: "dial", Number: event.URN.Path(), Action: resumeURL + "&wait_type=dial"})
+		}
+	}
+
+	if !hasWait {
+		// no wait? call is over, hang up
+		commands = append(commands, Hangup{Command: "hangup"})
+	}
+	r.Commands = commands
+
+	var body []byte
+	var err error
+	if indent {
+		body, err = jsonx.MarshalPretty(r)
+	} else {
+		body, err = jsonx.Marshal(r)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal PSM response")
+	}
+
+	return string(body), nil
 }
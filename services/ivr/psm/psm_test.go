@@ -0,0 +1,259 @@
+package psm_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/routers/waits/hints"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/services/ivr/psm"
+	"github.com/nyaruka/mailroom/testsuite"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseForSprint(t *testing.T) {
+	_, rt, _, _ := testsuite.Get()
+
+	urn := urns.URN("tel:+12067799294")
+	expiresOn := time.Now().Add(time.Hour)
+	channelRef := assets.NewChannelReference(assets.ChannelUUID(uuids.New()), "PSM Channel")
+
+	resumeURL := "http://temba.io/resume?session=1"
+
+	// set our attachment domain for testing
+	rt.Config.AttachmentDomain = "mailroom.io"
+	defer func() { rt.Config.AttachmentDomain = "" }()
+
+	tcs := []struct {
+		events   []flows.Event
+		expected string
+	}{
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "hello world", nil, nil, nil, flows.NilMsgTopic)),
+			},
+			`{"commands":[{"command":"say","text":"hello world"},{"command":"hangup"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "hello world", "eng", "")),
+			},
+			`{"commands":[{"command":"say","text":"hello world","language":"en-US"},{"command":"hangup"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "hello world", []utils.Attachment{utils.Attachment("audio:/recordings/foo.wav")}, nil, nil, flows.NilMsgTopic)),
+			},
+			`{"commands":[{"command":"play","url":"https://mailroom.io/recordings/foo.wav"},{"command":"hangup"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "hello world", nil, nil, nil, flows.NilMsgTopic)),
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "goodbye", nil, nil, nil, flows.NilMsgTopic)),
+			},
+			`{"commands":[{"command":"say","text":"hello world"},{"command":"say","text":"goodbye"},{"command":"hangup"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "enter a number", nil, nil, nil, flows.NilMsgTopic)),
+				events.NewMsgWait(nil, nil, hints.NewFixedDigitsHint(1)),
+			},
+			`{"commands":[{"command":"gather","max_digits":1,"timeout":30,"action":"http://temba.io/resume?session=1&wait_type=gather","commands":[{"command":"say","text":"enter a number"}]},{"command":"redirect","url":"http://temba.io/resume?session=1&wait_type=gather&timeout=true"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "enter a number, then press #", nil, nil, nil, flows.NilMsgTopic)),
+				events.NewMsgWait(nil, nil, hints.NewTerminatedDigitsHint("#")),
+			},
+			`{"commands":[{"command":"gather","finish_on_key":"#","timeout":30,"action":"http://temba.io/resume?session=1&wait_type=gather","commands":[{"command":"say","text":"enter a number, then press #"}]},{"command":"redirect","url":"http://temba.io/resume?session=1&wait_type=gather&timeout=true"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewIVRCreated(flows.NewMsgOut(urn, channelRef, "say something", nil, nil, nil, flows.NilMsgTopic)),
+				events.NewMsgWait(nil, nil, hints.NewAudioHint()),
+			},
+			`{"commands":[{"command":"say","text":"say something"},{"command":"record","max_length":600,"action":"http://temba.io/resume?session=1&wait_type=record"},{"command":"redirect","url":"http://temba.io/resume?session=1&wait_type=record&empty=true"}]}`,
+		},
+		{
+			[]flows.Event{
+				events.NewDialWait(urns.URN(`tel:+1234567890`), &expiresOn),
+			},
+			`{"commands":[{"command":"dial","number":"+1234567890","action":"http://temba.io/resume?session=1&wait_type=dial"}]}`,
+		},
+	}
+
+	for i, tc := range tcs {
+		response, err := psm.ResponseForSprint(rt.Config, urn, resumeURL, tc.events, false)
+		assert.NoError(t, err, "%d: unexpected error")
+		assert.Equal(t, tc.expected, response, "%d: unexpected response", i)
+	}
+}
+
+func TestRequestCall(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://psm.example.com/api/calls": {
+			httpx.NewMockResponse(201, nil, `{"call_id": "CA123", "status": "queued"}`),
+			httpx.NewMockResponse(201, nil, `{"call_id": "CA124", "status": "failed"}`),
+			httpx.NewMockResponse(400, nil, `{"error": "invalid number"}`),
+			httpx.NewMockResponse(201, nil, `{"status": "queued"}`),
+		},
+		"https://psm.example.com/api/calls/CA123/hangup": {
+			httpx.NewMockResponse(200, nil, `{"call_id": "CA123", "status": "completed"}`),
+			httpx.NewMockResponse(404, nil, `{"error": "no such call"}`),
+		},
+	}))
+
+	s := psm.NewService(http.DefaultClient, "https://psm.example.com/api", "sesame", "+12065551212")
+
+	callID, trace, err := s.RequestCall(urns.URN("tel:+12067799294"), "https://mailroom.io/mr/ivr/c/1234/handle?action=start", "https://mailroom.io/mr/ivr/c/1234/status", true)
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.CallID("CA123"), callID)
+	assert.Equal(t, "Token sesame", trace.Request.Header.Get("Authorization"))
+	assert.Contains(t, string(trace.RequestTrace), `{"to":"+12067799294","from":"+12065551212","handle_url":"https://mailroom.io/mr/ivr/c/1234/handle?action=start","status_url":"https://mailroom.io/mr/ivr/c/1234/status","machine_detection":true}`)
+
+	_, _, err = s.RequestCall(urns.URN("tel:+12067799294"), "https://mailroom.io/mr/ivr/c/1234/handle?action=start", "https://mailroom.io/mr/ivr/c/1234/status", false)
+	assert.EqualError(t, err, "call status returned as failed")
+
+	_, trace, err = s.RequestCall(urns.URN("tel:+12067799294"), "https://mailroom.io/mr/ivr/c/1234/handle?action=start", "https://mailroom.io/mr/ivr/c/1234/status", false)
+	assert.EqualError(t, err, "received non 201 status for call start: 400")
+	assert.NotNil(t, trace)
+
+	_, _, err = s.RequestCall(urns.URN("tel:+12067799294"), "https://mailroom.io/mr/ivr/c/1234/handle?action=start", "https://mailroom.io/mr/ivr/c/1234/status", false)
+	assert.Error(t, err)
+
+	trace, err = s.HangupCall("CA123")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, trace.Response.StatusCode)
+
+	_, err = s.HangupCall("CA123")
+	assert.EqualError(t, err, "received non 200 status for call hangup: 404")
+
+	// channels without an API base URL or auth token can only receive calls
+	s = psm.NewService(http.DefaultClient, "", "", "+12065551212")
+
+	_, _, err = s.RequestCall(urns.URN("tel:+12067799294"), "https://mailroom.io/mr/ivr/c/1234/handle?action=start", "https://mailroom.io/mr/ivr/c/1234/status", false)
+	assert.EqualError(t, err, "missing base_url or auth_token in channel config")
+
+	_, err = s.HangupCall("CA123")
+	assert.EqualError(t, err, "missing base_url or auth_token in channel config")
+}
+
+func TestValidateRequestSignature(t *testing.T) {
+	s := psm.NewService(http.DefaultClient, "https://psm.example.com/api", "sesame", "+12065551212")
+
+	makeRequest := func(signature string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://mailroom.io/mr/ivr/c/8eb5e3c4-4d74-4f39-a4b0-0ea7a4a1c1a2/status", strings.NewReader(`{"call_id":"CA123","status":"completed","duration":32}`))
+		if signature != "" {
+			r.Header.Set("X-PSM-Signature", signature)
+		}
+		return r
+	}
+
+	assert.NoError(t, s.ValidateRequestSignature(makeRequest("Q89ddjh67P0WVXy9oCJLpy9IuS+jYz6+/PcYuBuBMYo=")))
+	assert.EqualError(t, s.ValidateRequestSignature(makeRequest("")), "missing request signature header")
+	assert.EqualError(t, s.ValidateRequestSignature(makeRequest("sesame")), "invalid request signature: sesame")
+
+	// body should still be readable after validation
+	r := makeRequest("Q89ddjh67P0WVXy9oCJLpy9IuS+jYz6+/PcYuBuBMYo=")
+	require.NoError(t, s.ValidateRequestSignature(r))
+	callID, err := s.CallIDForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "CA123", callID)
+
+	// channels without an auth token don't have signed requests
+	s = psm.NewService(http.DefaultClient, "", "", "+12065551212")
+	assert.NoError(t, s.ValidateRequestSignature(makeRequest("")))
+}
+
+func TestCallbacks(t *testing.T) {
+	s := psm.NewService(http.DefaultClient, "https://psm.example.com/api", "sesame", "+12065551212")
+
+	makeRequest := func(query string, body string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://mailroom.io/mr/ivr/c/1234/handle?"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.ParseForm()
+		return r
+	}
+
+	// call ids, which incoming calls from older channels don't include
+	callID, err := s.CallIDForRequest(makeRequest("", `{"call_id": "CA123", "status": "completed"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "CA123", callID)
+
+	callID, err = s.CallIDForRequest(makeRequest("", `{"urn": "12067799294"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "", callID)
+
+	// status callbacks are posted as form fields
+	makeFormRequest := func(query string, form url.Values) *http.Request {
+		r, _ := http.NewRequest("POST", "https://mailroom.io/mr/ivr/c/1234/handle?"+query, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	callID, err = s.CallIDForRequest(makeFormRequest("", url.Values{"CallStatus": []string{"completed"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, "", callID)
+
+	// start requests
+	assert.Equal(t, models.ConnectionErrorMachine, s.CheckStartRequest(makeFormRequest("action=start", url.Values{"AnsweredBy": []string{"machine_start"}})))
+	assert.Equal(t, models.ConnectionErrorMachine, s.CheckStartRequest(makeFormRequest("action=start", url.Values{"AnsweredBy": []string{"fax"}})))
+	assert.Equal(t, models.ConnectionError(""), s.CheckStartRequest(makeFormRequest("action=start", url.Values{"AnsweredBy": []string{"human"}})))
+
+	// statuses
+	status, errorReason, duration := s.StatusForRequest(makeFormRequest("", url.Values{"CallStatus": []string{"completed"}, "CallDuration": []string{"32"}}))
+	assert.Equal(t, models.ConnectionStatusCompleted, status)
+	assert.Equal(t, models.ConnectionError(""), errorReason)
+	assert.Equal(t, 32, duration)
+
+	status, _, _ = s.StatusForRequest(makeFormRequest("", url.Values{"CallStatus": []string{"in-progress"}}))
+	assert.Equal(t, models.ConnectionStatusInProgress, status)
+
+	status, errorReason, _ = s.StatusForRequest(makeFormRequest("", url.Values{"CallStatus": []string{"busy"}}))
+	assert.Equal(t, models.ConnectionStatusErrored, status)
+	assert.Equal(t, models.ConnectionErrorBusy, errorReason)
+
+	status, _, _ = s.StatusForRequest(makeRequest("action=resume&wait_type=gather", `{"call_id": "CA123", "digits": "12"}`))
+	assert.Equal(t, models.ConnectionStatusInProgress, status)
+
+	// resumes
+	resume, err := s.ResumeForRequest(makeRequest("action=resume&wait_type=gather", `{"call_id": "CA123", "digits": "12"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{Input: "12"}, resume)
+
+	resume, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=gather", `{"call_id": "CA123", "timed_out": true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{}, resume)
+
+	resume, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=gather&timeout=true", ``))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{}, resume)
+
+	resume, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=record", `{"call_id": "CA123", "recording_url": "https://psm.example.com/recordings/1.wav"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{Attachment: utils.Attachment("audio:https://psm.example.com/recordings/1.wav")}, resume)
+
+	resume, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=dial", `{"call_id": "CA123", "dial_status": "answered", "dial_duration": 45}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.DialResume{Status: flows.DialStatusAnswered, Duration: 45}, resume)
+
+	_, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=dial", `{"call_id": "CA123", "dial_status": "xxx"}`))
+	assert.EqualError(t, err, "unknown PSM dial_status in callback: xxx")
+
+	_, err = s.ResumeForRequest(makeRequest("action=resume&wait_type=xxx", `{"call_id": "CA123"}`))
+	assert.EqualError(t, err, "unknown wait_type: xxx")
+}
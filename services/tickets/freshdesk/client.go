@@ -0,0 +1,178 @@
+package freshdesk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// StatusOpen is the Freshdesk status of an open ticket
+	StatusOpen = 2
+
+	// StatusPending is the Freshdesk status of a ticket waiting on the requester
+	StatusPending = 3
+
+	// StatusResolved is the Freshdesk status of a resolved ticket
+	StatusResolved = 4
+
+	// StatusClosed is the Freshdesk status of a closed ticket
+	StatusClosed = 5
+
+	// PriorityLow is the Freshdesk priority we give new tickets
+	PriorityLow = 1
+)
+
+// Client is a client for the Freshdesk REST API
+type Client struct {
+	httpClient  *http.Client
+	httpRetries *httpx.RetryConfig
+	subdomain   string
+	apiKey      string
+}
+
+// NewClient creates a new Freshdesk REST client
+func NewClient(httpClient *http.Client, httpRetries *httpx.RetryConfig, subdomain, apiKey string) *Client {
+	return &Client{
+		httpClient:  httpClient,
+		httpRetries: httpRetries,
+		subdomain:   subdomain,
+		apiKey:      apiKey,
+	}
+}
+
+// BasicAuth returns the encoded basic auth credentials for the given API key, which Freshdesk expects as the
+// username with any value as the password
+func BasicAuth(apiKey string) string {
+	return base64.StdEncoding.EncodeToString([]byte(apiKey + ":X"))
+}
+
+type errorResponse struct {
+	Description string `json:"description"`
+	Message     string `json:"message"`
+	Errors      []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (c *Client) post(endpoint string, payload interface{}, response interface{}) (*httpx.Trace, error) {
+	return c.request("POST", endpoint, payload, response)
+}
+
+func (c *Client) put(endpoint string, payload interface{}, response interface{}) (*httpx.Trace, error) {
+	return c.request("PUT", endpoint, payload, response)
+}
+
+func (c *Client) request(method, endpoint string, payload interface{}, response interface{}) (*httpx.Trace, error) {
+	url := fmt.Sprintf("https://%s.freshdesk.com/api/v2/%s", c.subdomain, endpoint)
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Basic %s", BasicAuth(c.apiKey)),
+	}
+	var body io.Reader
+
+	if payload != nil {
+		data, err := jsonx.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+		headers["Content-Type"] = "application/json"
+	}
+
+	req, err := httpx.NewRequest(method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := httpx.DoTrace(c.httpClient, req, c.httpRetries, nil, -1)
+	if err != nil {
+		return trace, err
+	}
+
+	if trace.Response.StatusCode >= 400 {
+		response := &errorResponse{}
+		jsonx.Unmarshal(trace.ResponseBody, response)
+
+		switch {
+		case len(response.Errors) > 0:
+			return trace, errors.Errorf("%s: %s %s", response.Description, response.Errors[0].Field, response.Errors[0].Message)
+		case response.Description != "":
+			return trace, errors.New(response.Description)
+		case response.Message != "":
+			return trace, errors.New(response.Message)
+		}
+		return trace, errors.Errorf("freshdesk returned non-2XX response: %d", trace.Response.StatusCode)
+	}
+
+	if response != nil {
+		return trace, jsonx.Unmarshal(trace.ResponseBody, response)
+	}
+	return trace, nil
+}
+
+// Ticket see https://developers.freshdesk.com/api/#tickets
+type Ticket struct {
+	ID               int64  `json:"id,omitempty"`
+	Name             string `json:"name,omitempty"`
+	Email            string `json:"email,omitempty"`
+	Phone            string `json:"phone,omitempty"`
+	UniqueExternalID string `json:"unique_external_id,omitempty"`
+	Subject          string `json:"subject,omitempty"`
+	Description      string `json:"description,omitempty"`
+	Status           int    `json:"status,omitempty"`
+	Priority         int    `json:"priority,omitempty"`
+}
+
+// CreateTicket see https://developers.freshdesk.com/api/#create_ticket
+func (c *Client) CreateTicket(ticket *Ticket) (*Ticket, *httpx.Trace, error) {
+	response := &Ticket{}
+
+	trace, err := c.post("tickets", ticket, response)
+	if err != nil {
+		return nil, trace, err
+	}
+
+	return response, trace, nil
+}
+
+// UpdateTicketStatus see https://developers.freshdesk.com/api/#update_ticket
+func (c *Client) UpdateTicketStatus(id int64, status int) (*Ticket, *httpx.Trace, error) {
+	payload := &Ticket{Status: status}
+	response := &Ticket{}
+
+	trace, err := c.put(fmt.Sprintf("tickets/%d", id), payload, response)
+	if err != nil {
+		return nil, trace, err
+	}
+
+	return response, trace, nil
+}
+
+// Note see https://developers.freshdesk.com/api/#conversations
+type Note struct {
+	ID       int64  `json:"id,omitempty"`
+	TicketID int64  `json:"ticket_id,omitempty"`
+	Body     string `json:"body"`
+	Private  bool   `json:"private"`
+	Incoming bool   `json:"incoming"`
+}
+
+// AddNote see https://developers.freshdesk.com/api/#add_note_to_a_ticket
+func (c *Client) AddNote(ticketID int64, note *Note) (*Note, *httpx.Trace, error) {
+	response := &Note{}
+
+	trace, err := c.post(fmt.Sprintf("tickets/%d/notes", ticketID), note, response)
+	if err != nil {
+		return nil, trace, err
+	}
+
+	return response, trace, nil
+}
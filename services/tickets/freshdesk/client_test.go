@@ -0,0 +1,102 @@
+package freshdesk_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/services/tickets/freshdesk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTicket(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://nyaruka.freshdesk.com/api/v2/tickets": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(400, nil, `{"description": "Validation failed", "errors": [{"field": "email", "message": "It should be in the 'valid email address' format", "code": "invalid_value"}]}`),
+			httpx.NewMockResponse(401, nil, `{"code": "invalid_credentials", "message": "You have to be logged in to perform this action."}`),
+			httpx.NewMockResponse(200, nil, `xx`), // non-JSON response
+			httpx.NewMockResponse(201, nil, `{"id": 123, "subject": "General", "status": 2}`),
+		},
+	}))
+
+	client := freshdesk.NewClient(http.DefaultClient, nil, "nyaruka", "123456789")
+	ticket := &freshdesk.Ticket{
+		Name:        "Bob",
+		Email:       "bob@acme.com",
+		Subject:     "General",
+		Description: "Where are my cookies?",
+		Status:      freshdesk.StatusOpen,
+		Priority:    freshdesk.PriorityLow,
+	}
+
+	_, _, err := client.CreateTicket(ticket)
+	assert.EqualError(t, err, "unable to connect to server")
+
+	_, _, err = client.CreateTicket(ticket)
+	assert.EqualError(t, err, "Validation failed: email It should be in the 'valid email address' format")
+
+	_, _, err = client.CreateTicket(ticket)
+	assert.EqualError(t, err, "You have to be logged in to perform this action.")
+
+	_, _, err = client.CreateTicket(ticket)
+	assert.EqualError(t, err, "invalid character 'x' looking for beginning of value")
+
+	created, trace, err := client.CreateTicket(ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(123), created.ID)
+	assert.Equal(t, "POST /api/v2/tickets HTTP/1.1\r\nHost: nyaruka.freshdesk.com\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 119\r\nAuthorization: Basic MTIzNDU2Nzg5Olg=\r\nContent-Type: application/json\r\nAccept-Encoding: gzip\r\n\r\n{\"name\":\"Bob\",\"email\":\"bob@acme.com\",\"subject\":\"General\",\"description\":\"Where are my cookies?\",\"status\":2,\"priority\":1}", string(trace.RequestTrace))
+	assert.Equal(t, "HTTP/1.0 201 Created\r\nContent-Length: 46\r\n\r\n", string(trace.ResponseTrace))
+}
+
+func TestUpdateTicketStatus(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://nyaruka.freshdesk.com/api/v2/tickets/123": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(404, nil, ``),
+			httpx.NewMockResponse(200, nil, `{"id": 123, "subject": "General", "status": 5}`),
+		},
+	}))
+
+	client := freshdesk.NewClient(http.DefaultClient, nil, "nyaruka", "123456789")
+
+	_, _, err := client.UpdateTicketStatus(123, freshdesk.StatusClosed)
+	assert.EqualError(t, err, "unable to connect to server")
+
+	_, _, err = client.UpdateTicketStatus(123, freshdesk.StatusClosed)
+	assert.EqualError(t, err, "freshdesk returned non-2XX response: 404")
+
+	ticket, trace, err := client.UpdateTicketStatus(123, freshdesk.StatusClosed)
+	assert.NoError(t, err)
+	assert.Equal(t, freshdesk.StatusClosed, ticket.Status)
+	assert.Equal(t, "PUT /api/v2/tickets/123 HTTP/1.1\r\nHost: nyaruka.freshdesk.com\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 12\r\nAuthorization: Basic MTIzNDU2Nzg5Olg=\r\nContent-Type: application/json\r\nAccept-Encoding: gzip\r\n\r\n{\"status\":5}", string(trace.RequestTrace))
+}
+
+func TestAddNote(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://nyaruka.freshdesk.com/api/v2/tickets/123/notes": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(404, nil, `{"code": "access_denied", "message": "You are not authorized to perform this action."}`),
+			httpx.NewMockResponse(201, nil, `{"id": 456, "ticket_id": 123, "body": "It's urgent", "private": false, "incoming": true}`),
+		},
+	}))
+
+	client := freshdesk.NewClient(http.DefaultClient, nil, "nyaruka", "123456789")
+	note := &freshdesk.Note{Body: "It's urgent", Incoming: true}
+
+	_, _, err := client.AddNote(123, note)
+	assert.EqualError(t, err, "unable to connect to server")
+
+	_, _, err = client.AddNote(123, note)
+	assert.EqualError(t, err, "You are not authorized to perform this action.")
+
+	added, trace, err := client.AddNote(123, note)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(456), added.ID)
+	assert.Equal(t, int64(123), added.TicketID)
+	assert.Equal(t, "HTTP/1.0 201 Created\r\nContent-Length: 88\r\n\r\n", string(trace.ResponseTrace))
+}
@@ -0,0 +1,160 @@
+package freshdesk
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	typeFreshdesk = "freshdesk"
+
+	configSubdomain = "subdomain"
+	configAPIKey    = "api_key"
+	configSecret    = "secret"
+)
+
+func init() {
+	models.RegisterTicketService(typeFreshdesk, NewService)
+}
+
+type service struct {
+	client   *Client
+	ticketer *flows.Ticketer
+	redactor utils.Redactor
+}
+
+// NewService creates a new Freshdesk ticket service
+func NewService(rtCfg *runtime.Config, httpClient *http.Client, httpRetries *httpx.RetryConfig, ticketer *flows.Ticketer, config map[string]string) (models.TicketService, error) {
+	subdomain := config[configSubdomain]
+	apiKey := config[configAPIKey]
+	secret := config[configSecret]
+
+	if subdomain != "" && apiKey != "" && secret != "" {
+		return &service{
+			client:   NewClient(httpClient, httpRetries, subdomain, apiKey),
+			ticketer: ticketer,
+			redactor: utils.NewRedactor(flows.RedactionMask, apiKey, BasicAuth(apiKey)),
+		}, nil
+	}
+	return nil, errors.New("missing subdomain or api_key or secret in freshdesk config")
+}
+
+// Open opens a ticket which for Freshdesk means creating a ticket with the contact as the requester
+func (s *service) Open(session flows.Session, topic *flows.Topic, body string, assignee *flows.User, logHTTP flows.HTTPLogCallback) (*flows.Ticket, error) {
+	ticket := flows.OpenTicket(s.ticketer, topic, body, assignee)
+	contact := session.Contact()
+
+	subject := body
+	if topic != nil {
+		subject = topic.Name()
+	}
+
+	fdTicket := &Ticket{
+		Name:             tickets.GetContactDisplay(session.Environment(), contact),
+		UniqueExternalID: string(contact.UUID()),
+		Subject:          subject,
+		Description:      html.EscapeString(body),
+		Status:           StatusOpen,
+		Priority:         PriorityLow,
+	}
+
+	// use the contact's email and phone if they have them so that agents can see them in Freshdesk
+	for _, urn := range contact.URNs() {
+		switch urn.URN().Scheme() {
+		case urns.EmailScheme:
+			if fdTicket.Email == "" {
+				fdTicket.Email = urn.URN().Path()
+			}
+		case urns.TelScheme:
+			if fdTicket.Phone == "" {
+				fdTicket.Phone = urn.URN().Path()
+			}
+		}
+	}
+
+	created, trace, err := s.client.CreateTicket(fdTicket)
+	if trace != nil {
+		logHTTP(flows.NewHTTPLog(trace, flows.HTTPStatusFromCode, s.redactor))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling Freshdesk")
+	}
+
+	ticket.SetExternalID(strconv.FormatInt(created.ID, 10))
+	return ticket, nil
+}
+
+// Forward forwards a message from the contact as a public note on the Freshdesk ticket, with any attachments
+// included as links
+func (s *service) Forward(ticket *models.Ticket, msgUUID flows.MsgUUID, text string, attachments []utils.Attachment, logHTTP flows.HTTPLogCallback) error {
+	id, err := parseTicketID(string(ticket.ExternalID()))
+	if err != nil {
+		return err
+	}
+
+	lines := []string{html.EscapeString(text)}
+	for _, attachment := range attachments {
+		lines = append(lines, html.EscapeString(attachment.URL()))
+	}
+
+	note := &Note{Body: strings.Join(lines, "<br>"), Private: false, Incoming: true}
+
+	_, trace, err := s.client.AddNote(id, note)
+	if trace != nil {
+		logHTTP(flows.NewHTTPLog(trace, flows.HTTPStatusFromCode, s.redactor))
+	}
+	if err != nil {
+		return errors.Wrap(err, "error calling Freshdesk")
+	}
+	return nil
+}
+
+// Close closes the given tickets in Freshdesk
+func (s *service) Close(tickets []*models.Ticket, logHTTP flows.HTTPLogCallback) error {
+	return s.updateStatus(tickets, StatusClosed, logHTTP)
+}
+
+// Reopen reopens the given tickets in Freshdesk
+func (s *service) Reopen(tickets []*models.Ticket, logHTTP flows.HTTPLogCallback) error {
+	return s.updateStatus(tickets, StatusOpen, logHTTP)
+}
+
+func (s *service) updateStatus(tickets []*models.Ticket, status int, logHTTP flows.HTTPLogCallback) error {
+	for _, t := range tickets {
+		// tickets which failed to open in Freshdesk won't have an external ID
+		id, err := parseTicketID(string(t.ExternalID()))
+		if err != nil {
+			continue
+		}
+
+		_, trace, err := s.client.UpdateTicketStatus(id, status)
+		if trace != nil {
+			logHTTP(flows.NewHTTPLog(trace, flows.HTTPStatusFromCode, s.redactor))
+		}
+		if err != nil {
+			return errors.Wrap(err, "error calling Freshdesk")
+		}
+	}
+	return nil
+}
+
+// parses a Freshdesk ticket ID from our local external ID field
+func parseTicketID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("%s is not a valid freshdesk ticket id", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,154 @@
+package freshdesk_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/assets/static"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/test"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/services/tickets/freshdesk"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAndForward(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	session, _, err := test.CreateTestSession("", envs.RedactionPolicyNone)
+	require.NoError(t, err)
+
+	defer uuids.SetGenerator(uuids.DefaultGenerator)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	uuids.SetGenerator(uuids.NewSeededGenerator(12345))
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://nyaruka.freshdesk.com/api/v2/tickets": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(201, nil, `{"id": 123, "subject": "General", "status": 2}`),
+		},
+		"https://nyaruka.freshdesk.com/api/v2/tickets/123/notes": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(201, nil, `{"id": 456, "ticket_id": 123}`),
+		},
+	}))
+
+	ticketer := flows.NewTicketer(static.NewTicketer(assets.TicketerUUID(uuids.New()), "Support", "freshdesk"))
+
+	_, err = freshdesk.NewService(rt.Config, http.DefaultClient, nil, ticketer, map[string]string{})
+	assert.EqualError(t, err, "missing subdomain or api_key or secret in freshdesk config")
+
+	svc, err := freshdesk.NewService(
+		rt.Config,
+		http.DefaultClient,
+		nil,
+		ticketer,
+		map[string]string{
+			"subdomain": "nyaruka",
+			"api_key":   "123456789",
+			"secret":    "sesame",
+		},
+	)
+	require.NoError(t, err)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+	defaultTopic := oa.SessionAssets().Topics().FindByName("General")
+
+	logger := &flows.HTTPLogger{}
+	_, err = svc.Open(session, defaultTopic, "Where are my cookies?", nil, logger.Log)
+	assert.EqualError(t, err, "error calling Freshdesk: unable to connect to server")
+
+	logger = &flows.HTTPLogger{}
+	ticket, err := svc.Open(session, defaultTopic, "Where are my cookies?", nil, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, "General", ticket.Topic().Name())
+	assert.Equal(t, "Where are my cookies?", ticket.Body())
+	assert.Equal(t, "123", ticket.ExternalID())
+	assert.Equal(t, 1, len(logger.Logs))
+	test.AssertSnapshot(t, "open_ticket", logger.Logs[0].Request)
+
+	dbTicket := models.NewTicket(ticket.UUID(), testdata.Org1.ID, testdata.Cathy.ID, testdata.Zendesk.ID, "123", testdata.DefaultTopic.ID, "Where are my cookies?", models.NilUserID, nil)
+
+	logger = &flows.HTTPLogger{}
+	err = svc.Forward(dbTicket, flows.MsgUUID("4fa340ae-1fb0-4666-98db-2177fe9bf31c"), "It's urgent", nil, logger.Log)
+	assert.EqualError(t, err, "error calling Freshdesk: unable to connect to server")
+
+	logger = &flows.HTTPLogger{}
+	attachments := []utils.Attachment{
+		"image/jpg:https://link.to/image.jpg",
+		"video/mp4:https://link.to/video.mp4",
+	}
+	err = svc.Forward(dbTicket, flows.MsgUUID("4fa340ae-1fb0-4666-98db-2177fe9bf31c"), "It's urgent", attachments, logger.Log)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(logger.Logs))
+	test.AssertSnapshot(t, "forward_message", logger.Logs[0].Request)
+
+	// can't forward to a ticket that doesn't have a Freshdesk ID
+	dbTicket = models.NewTicket(ticket.UUID(), testdata.Org1.ID, testdata.Cathy.ID, testdata.Zendesk.ID, "", testdata.DefaultTopic.ID, "Where are my cookies?", models.NilUserID, nil)
+	err = svc.Forward(dbTicket, flows.MsgUUID("4fa340ae-1fb0-4666-98db-2177fe9bf31c"), "It's urgent", nil, logger.Log)
+	assert.EqualError(t, err, " is not a valid freshdesk ticket id")
+}
+
+func TestCloseAndReopen(t *testing.T) {
+	_, rt, _, _ := testsuite.Get()
+
+	defer uuids.SetGenerator(uuids.DefaultGenerator)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	uuids.SetGenerator(uuids.NewSeededGenerator(12345))
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		"https://nyaruka.freshdesk.com/api/v2/tickets/123": {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(200, nil, `{"id": 123, "status": 5}`),
+			httpx.NewMockResponse(200, nil, `{"id": 123, "status": 2}`),
+		},
+		"https://nyaruka.freshdesk.com/api/v2/tickets/124": {
+			httpx.NewMockResponse(200, nil, `{"id": 124, "status": 5}`),
+		},
+	}))
+
+	ticketer := flows.NewTicketer(static.NewTicketer(assets.TicketerUUID(uuids.New()), "Support", "freshdesk"))
+	svc, err := freshdesk.NewService(
+		rt.Config,
+		http.DefaultClient,
+		nil,
+		ticketer,
+		map[string]string{
+			"subdomain": "nyaruka",
+			"api_key":   "123456789",
+			"secret":    "sesame",
+		},
+	)
+	require.NoError(t, err)
+
+	ticket1 := models.NewTicket("88bfa1dc-be33-45c2-b469-294ecb0eba90", testdata.Org1.ID, testdata.Cathy.ID, testdata.Zendesk.ID, "123", testdata.DefaultTopic.ID, "Where my cookies?", models.NilUserID, nil)
+	ticket2 := models.NewTicket("645eee60-7e84-4a9e-ade3-4fce01ae28f1", testdata.Org1.ID, testdata.Bob.ID, testdata.Zendesk.ID, "124", testdata.DefaultTopic.ID, "Where my shoes?", models.NilUserID, nil)
+	ticket3 := models.NewTicket("fb88a3a6-8faa-4d1e-a5b8-d59f7a0e3d2a", testdata.Org1.ID, testdata.Bob.ID, testdata.Zendesk.ID, "", testdata.DefaultTopic.ID, "Where my hat?", models.NilUserID, nil)
+
+	logger := &flows.HTTPLogger{}
+	err = svc.Close([]*models.Ticket{ticket1, ticket2, ticket3}, logger.Log)
+	assert.EqualError(t, err, "error calling Freshdesk: unable to connect to server")
+
+	// tickets without Freshdesk IDs are skipped
+	logger = &flows.HTTPLogger{}
+	err = svc.Close([]*models.Ticket{ticket1, ticket2, ticket3}, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(logger.Logs))
+	test.AssertSnapshot(t, "close_tickets", logger.Logs[0].Request)
+
+	logger = &flows.HTTPLogger{}
+	err = svc.Reopen([]*models.Ticket{ticket1}, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(logger.Logs))
+	assert.Contains(t, logger.Logs[0].Request, `{"status":2}`)
+}
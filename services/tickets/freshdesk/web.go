@@ -0,0 +1,123 @@
+package freshdesk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	base := "/mr/tickets/types/freshdesk"
+
+	web.RegisterJSONRoute(http.MethodPost, base+`/webhook/{ticketer:[a-f0-9\-]+}`, web.WithHTTPLogs(handleWebhook))
+}
+
+// Webhook requests are sent by Freshdesk automation rules, authenticated with basic auth using freshdesk as the
+// username and the ticketer secret as the password, e.g.
+//
+//   {
+//     "event": "reply",
+//     "id": {{ticket.id}},
+//     "text": "{{ticket.latest_public_comment}}"
+//   }
+//
+//   {
+//     "event": "status_changed",
+//     "id": {{ticket.id}},
+//     "status": "{{ticket.status}}"
+//   }
+//
+type webhookRequest struct {
+	Event       string   `json:"event"  validate:"required"`
+	ID          int64    `json:"id"     validate:"required"`
+	Status      string   `json:"status"`
+	Text        string   `json:"text"`
+	Attachments []string `json:"attachments"`
+}
+
+func handleWebhook(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *models.HTTPLogger) (interface{}, int, error) {
+	ticketerUUID := assets.TicketerUUID(chi.URLParam(r, "ticketer"))
+
+	// look up our ticketer
+	ticketer, _, err := tickets.FromTicketerUUID(ctx, rt, ticketerUUID, typeFreshdesk)
+	if err != nil || ticketer == nil {
+		return errors.Errorf("no such ticketer %s", ticketerUUID), http.StatusNotFound, nil
+	}
+
+	// check authentication
+	username, password, _ := r.BasicAuth()
+	if username != "freshdesk" || password != ticketer.Config(configSecret) {
+		return map[string]string{"status": "unauthorized"}, http.StatusUnauthorized, nil
+	}
+
+	// parse request payload
+	request := &webhookRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	// lookup ticket
+	ticket, err := models.LookupTicketByExternalID(ctx, rt.DB, ticketer.ID(), fmt.Sprintf("%d", request.ID))
+	if err != nil || ticket == nil {
+		// we don't return an error here, because ticket might just belong to a different ticketer
+		return map[string]string{"status": "ignored"}, http.StatusOK, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, ticket.OrgID())
+	if err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	switch request.Event {
+	case "reply":
+		// reopen ticket if necessary
+		if ticket.Status() != models.TicketStatusOpen {
+			if err := tickets.Reopen(ctx, rt, oa, ticket, false, l); err != nil {
+				return errors.Wrapf(err, "error reopening ticket: %s", ticket.UUID()), http.StatusInternalServerError, nil
+			}
+		}
+
+		// fetch files
+		files := make([]*tickets.File, len(request.Attachments))
+		for i, attachment := range request.Attachments {
+			files[i], err = tickets.FetchFile(attachment, nil)
+			if err != nil {
+				return errors.Wrapf(err, "error fetching ticket file '%s'", attachment), http.StatusBadRequest, nil
+			}
+		}
+
+		_, err = tickets.SendReply(ctx, rt, ticket, request.Text, files)
+
+	case "status_changed":
+		switch strings.ToLower(request.Status) {
+		case "resolved", "closed":
+			if ticket.Status() == models.TicketStatusOpen {
+				err = tickets.Close(ctx, rt, oa, ticket, false, l)
+			}
+		case "open":
+			if ticket.Status() != models.TicketStatusOpen {
+				err = tickets.Reopen(ctx, rt, oa, ticket, false, l)
+			}
+		}
+
+	default:
+		return errors.Errorf("unknown event type: %s", request.Event), http.StatusBadRequest, nil
+	}
+
+	if err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	return map[string]string{"status": "handled"}, http.StatusOK, nil
+}
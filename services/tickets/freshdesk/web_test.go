@@ -0,0 +1,23 @@
+package freshdesk_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/web"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	ticketer := testdata.InsertTicketer(db, testdata.Org1, "freshdesk", "Freshdesk", map[string]interface{}{"subdomain": "nyaruka", "api_key": "123456789", "secret": "sesame"})
+
+	// create a freshdesk ticket for Cathy
+	ticket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, ticketer, testdata.DefaultTopic, "Have you seen my cookies?", "123", time.Now(), nil)
+
+	web.RunWebTests(t, ctx, rt, "testdata/webhook.json", map[string]string{"ticketer_uuid": string(ticketer.UUID), "cathy_ticket_uuid": string(ticket.UUID)})
+}
@@ -0,0 +1,260 @@
+package mailgun
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets"
+	"github.com/nyaruka/mailroom/utils/smtpd"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	mailroom.AddInitFunction(startSMTPServer)
+}
+
+// starts our SMTP server for receiving ticket replies, if an address to listen on has been configured
+func startSMTPServer(rt *runtime.Runtime, wg *sync.WaitGroup, quit chan bool) error {
+	if rt.Config.SMTPReceiveAddress == "" {
+		return nil
+	}
+
+	allowedIPs, allowedNets, err := rt.Config.ParseSMTPReceiveAllowedNetworks()
+	if err != nil {
+		return errors.Wrap(err, "error parsing allowed smtp networks")
+	}
+
+	server := smtpd.NewServer(rt.Config.SMTPReceiveAddress, rt.Config.Domain, newSMTPHandler(rt))
+	server.AllowClient = newSMTPClientFilter(allowedIPs, allowedNets)
+	server.CheckRecipient = checkSMTPRecipient
+	if err := server.Start(); err != nil {
+		logrus.WithError(err).Error("error starting smtp server")
+		return err
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-quit
+		server.Stop()
+	}()
+
+	return nil
+}
+
+// creates a filter which only allows SMTP connections from relays with the given IPs or in the given networks, as our
+// SMTP server has no authentication of its own
+func newSMTPClientFilter(ips []net.IP, nets []*net.IPNet) func(net.Addr) bool {
+	return func(addr net.Addr) bool {
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		for _, ip := range ips {
+			if ip.Equal(tcpAddr.IP) {
+				return true
+			}
+		}
+		for _, n := range nets {
+			if n.Contains(tcpAddr.IP) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// rejects recipients which aren't ticket addresses before the relay sends us the message
+func checkSMTPRecipient(address string) error {
+	if !addressRegex.MatchString(address) {
+		return errors.Errorf("invalid recipient: %s", address)
+	}
+	return nil
+}
+
+// creates a handler which routes each email received over SMTP to its ticket, in the same way as emails received via
+// the mailgun webhook. Each recipient is handled independently, and the message is only rejected if it couldn't be
+// handled for any of them, as a rejection makes the relay retry the message for all of its recipients.
+func newSMTPHandler(rt *runtime.Runtime) smtpd.Handler {
+	return func(from string, to []string, data []byte) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		var lastErr error
+		handled := 0
+		for _, recipient := range to {
+			if err := receiveSMTP(ctx, rt, from, recipient, data); err != nil {
+				logrus.WithError(err).WithField("recipient", recipient).Error("error handling ticket email received over smtp")
+				lastErr = err
+			} else {
+				handled++
+			}
+		}
+
+		if handled == 0 {
+			return lastErr
+		}
+		return nil
+	}
+}
+
+// handles an email received over SMTP for a single recipient
+func receiveSMTP(ctx context.Context, rt *runtime.Runtime, from, recipient string, data []byte) error {
+	email, err := parseEmail(from, recipient, data)
+	if err != nil {
+		return errors.Wrap(err, "error parsing email")
+	}
+
+	l := &models.HTTPLogger{}
+
+	response, status, err := receive(ctx, rt, email, l)
+
+	if err := l.Insert(ctx, rt.DB); err != nil {
+		return errors.Wrap(err, "error writing HTTP logs")
+	}
+
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return response.(error)
+	}
+
+	logrus.WithField("recipient", recipient).WithField("response", response).Info("ticket email received over smtp")
+	return nil
+}
+
+// parses a raw email into the parts we need to route it to a ticket
+func parseEmail(sender, recipient string, data []byte) (*inboundEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading message")
+	}
+
+	email := &inboundEmail{
+		Recipient: recipient,
+		Sender:    sender,
+		From:      msg.Header.Get("From"),
+		MessageID: msg.Header.Get("Message-Id"),
+	}
+
+	text, files, err := parseEmailPart(msg.Header, msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	email.Text = stripQuotedReply(text)
+	email.Files = files
+	return email, nil
+}
+
+// the subset of a MIME header we need to parse a part
+type mimeHeader interface {
+	Get(string) string
+}
+
+// parses a single part of an email, recursing into multipart parts, and returns the first plain text body found and
+// any attachments
+func parseEmailPart(header mimeHeader, body io.Reader) (string, []*tickets.File, error) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error parsing content type: %s", contentType)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		text := ""
+		files := make([]*tickets.File, 0)
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", nil, errors.Wrap(err, "error reading multipart part")
+			}
+
+			partText, partFiles, err := parseEmailPart(part.Header, part)
+			if err != nil {
+				return "", nil, err
+			}
+			if text == "" {
+				text = partText
+			}
+			files = append(files, partFiles...)
+		}
+		return text, files, nil
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error decoding part")
+	}
+
+	// anything with a filename or which isn't text is treated as an attachment
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	if disposition == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/") {
+		return "", []*tickets.File{{URL: filename, ContentType: mediaType, Body: io.NopCloser(bytes.NewReader(content))}}, nil
+	}
+	if mediaType == "text/plain" {
+		return string(content), nil, nil
+	}
+	return "", nil, nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+var replyHeaderRegex = regexp.MustCompile(`(?i)^(On .+ wrote:|-+\s*Original Message\s*-+)$`)
+
+// strips the quoted previous message from a reply, i.e. everything from the first line like "On ... wrote:" or the
+// first quoted line
+func stripQuotedReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	kept := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, ">") || replyHeaderRegex.MatchString(trimmed) {
+			break
+		}
+		kept = append(kept, line)
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -0,0 +1,152 @@
+package mailgun
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/utils/smtpd"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testReplyEmail = "From: Bob <bob@acme.com>\r\n" +
+	"To: ticket+%s@mr.nyaruka.com\r\n" +
+	"Message-Id: <34567@mail.acme.com>\r\n" +
+	"Subject: Re: [RapidPro-Tickets] New ticket\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"We'll look for your cookies=\r\n" +
+	" now\r\n" +
+	"\r\n" +
+	"On Mon, Jan 1, 2024 at 10:00 AM Cathy via RapidPro wrote:\r\n" +
+	"> Have you seen my cookies?\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>We'll look for your cookies now</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/jpeg\r\n" +
+	"Content-Disposition: attachment; filename=\"cookies.jpg\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8g\r\n" +
+	"d29ybGQ=\r\n" +
+	"--outer--\r\n"
+
+func TestParseEmail(t *testing.T) {
+	email, err := parseEmail("bob@acme.com", "ticket+1234@mr.nyaruka.com", []byte(fmt.Sprintf(testReplyEmail, "1234")))
+	require.NoError(t, err)
+
+	assert.Equal(t, "ticket+1234@mr.nyaruka.com", email.Recipient)
+	assert.Equal(t, "bob@acme.com", email.Sender)
+	assert.Equal(t, "Bob <bob@acme.com>", email.From)
+	assert.Equal(t, "<34567@mail.acme.com>", email.MessageID)
+	assert.Equal(t, "We'll look for your cookies now", email.Text)
+	require.Equal(t, 1, len(email.Files))
+	assert.Equal(t, "cookies.jpg", email.Files[0].URL)
+	assert.Equal(t, "image/jpeg", email.Files[0].ContentType)
+
+	content, err := io.ReadAll(email.Files[0].Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+
+	// a simple plain text email
+	email, err = parseEmail("bob@acme.com", "ticket+1234@mr.nyaruka.com", []byte("From: bob@acme.com\r\nSubject: Hi\r\n\r\nClose\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "Close", email.Text)
+	assert.Equal(t, 0, len(email.Files))
+
+	_, err = parseEmail("bob@acme.com", "ticket+1234@mr.nyaruka.com", []byte("Content-Type: multipart/mixed; boundary=\"x\r\n\r\nHi"))
+	assert.EqualError(t, err, "error parsing content type: multipart/mixed; boundary=\"x: mime: invalid media parameter")
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	tcs := []struct {
+		text     string
+		stripped string
+	}{
+		{"Hello", "Hello"},
+		{"  Hello\r\nthere  \r\n\r\n", "Hello\nthere"},
+		{"Hello\n\n> Have you seen my cookies?\n> No", "Hello"},
+		{"Hello\n\nOn Mon, Jan 1, 2024 at 10:00 AM Cathy wrote:\n\nHave you seen my cookies?", "Hello"},
+		{"Hello\n\n-----Original Message-----\nFrom: Cathy", "Hello"},
+		{"> all quoted", ""},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.stripped, stripQuotedReply(tc.text), "stripped mismatch for text %q", tc.text)
+	}
+}
+
+func TestSMTPReceive(t *testing.T) {
+	_, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
+
+	// create a mailgun ticket for Cathy
+	ticket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Mailgun, testdata.DefaultTopic, "Have you seen my cookies?", "", time.Now(), nil)
+
+	allowedIPs, allowedNets, err := rt.Config.ParseSMTPReceiveAllowedNetworks()
+	require.NoError(t, err)
+
+	server := smtpd.NewServer("localhost:0", "mr.nyaruka.com", newSMTPHandler(rt))
+	server.AllowClient = newSMTPClientFilter(allowedIPs, allowedNets)
+	server.CheckRecipient = checkSMTPRecipient
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	addr := server.ListenAddr().String()
+	recipient := fmt.Sprintf("ticket+%s@mr.nyaruka.com", ticket.UUID)
+
+	// an email for an address which isn't a ticket address is rejected
+	err = smtp.SendMail(addr, nil, "bob@acme.com", []string{"foo@mr.nyaruka.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid recipient: foo@mr.nyaruka.com")
+
+	// a reply from the configured address is forwarded to the contact with its attachment, and a recipient which can't
+	// be handled doesn't cause the message to be rejected, as that would make the relay resend it to both
+	err = smtp.SendMail(addr, nil, "bob@acme.com", []string{recipient, "ticket+a7b9d2c1-5d6e-4f3a-8b2c-1d0e9f8a7b6c@mr.nyaruka.com"}, []byte(fmt.Sprintf(testReplyEmail, ticket.UUID)))
+	assert.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE direction = 'O' AND contact_id = $1 AND text = 'We''ll look for your cookies now' AND array_length(attachments, 1) = 1`, testdata.Cathy.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT config->>'last-message-id' FROM tickets_ticket WHERE id = $1`, ticket.ID).Returns("<34567@mail.acme.com>")
+
+	// but if no recipient can be handled, it is rejected
+	err = smtp.SendMail(addr, nil, "bob@acme.com", []string{"ticket+a7b9d2c1-5d6e-4f3a-8b2c-1d0e9f8a7b6c@mr.nyaruka.com"}, []byte(fmt.Sprintf(testReplyEmail, ticket.UUID)))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "554")
+}
+
+func TestSMTPClientFilter(t *testing.T) {
+	cfg := runtime.NewDefaultConfig()
+	cfg.SMTPReceiveAllowedNetworks = `127.0.0.1,::1,10.1.0.0/16`
+
+	ips, nets, err := cfg.ParseSMTPReceiveAllowedNetworks()
+	require.NoError(t, err)
+
+	allow := newSMTPClientFilter(ips, nets)
+
+	assert.True(t, allow(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}))
+	assert.True(t, allow(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}))
+	assert.True(t, allow(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}))
+	assert.False(t, allow(&net.TCPAddr{IP: net.ParseIP("10.2.0.1"), Port: 1234}))
+	assert.False(t, allow(&net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 1234}))
+	assert.False(t, allow(&net.UnixAddr{Name: "/tmp/smtp.sock", Net: "unix"}))
+}
@@ -81,10 +81,34 @@ func handleReceive(ctx context.Context, rt *runtime.Runtime, r *http.Request, l
 		files[i] = &tickets.File{URL: header.Filename, ContentType: header.Header.Get("Content-Type"), Body: file}
 	}
 
+	email := &inboundEmail{
+		Recipient: request.Recipient,
+		Sender:    request.Sender,
+		From:      request.From,
+		MessageID: request.MessageID,
+		Text:      request.StrippedText,
+		Files:     files,
+	}
+
+	return receive(ctx, rt, email, l)
+}
+
+// an email received for a ticket, either via the mailgun webhook or our own SMTP server
+type inboundEmail struct {
+	Recipient string
+	Sender    string
+	From      string
+	MessageID string
+	Text      string
+	Files     []*tickets.File
+}
+
+// routes a received email to its ticket, as either a close command or a reply to the contact
+func receive(ctx context.Context, rt *runtime.Runtime, email *inboundEmail, l *models.HTTPLogger) (interface{}, int, error) {
 	// recipient is in the format ticket+<ticket-uuid>@... parse it out
-	match := addressRegex.FindAllStringSubmatch(request.Recipient, -1)
+	match := addressRegex.FindAllStringSubmatch(email.Recipient, -1)
 	if len(match) != 1 || len(match[0]) != 2 {
-		return errors.Errorf("invalid recipient: %s", request.Recipient), http.StatusBadRequest, nil
+		return errors.Errorf("invalid recipient: %s", email.Recipient), http.StatusBadRequest, nil
 	}
 
 	// look up the ticket and ticketer
@@ -96,10 +120,10 @@ func handleReceive(ctx context.Context, rt *runtime.Runtime, r *http.Request, l
 
 	// check that this sender is allowed to send to this ticket
 	configuredAddress := ticketer.Config(configToAddress)
-	if request.Sender != configuredAddress {
-		body := fmt.Sprintf("The address %s is not allowed to reply to this ticket\n", request.Sender)
+	if email.Sender != configuredAddress {
+		body := fmt.Sprintf("The address %s is not allowed to reply to this ticket\n", email.Sender)
 
-		mailgun.send(mailgun.noReplyAddress(), request.From, "Ticket reply rejected", body, nil, nil, l.Ticketer(ticketer))
+		mailgun.send(mailgun.noReplyAddress(), email.From, "Ticket reply rejected", body, nil, nil, l.Ticketer(ticketer))
 
 		return &receiveResponse{Action: "rejected", TicketUUID: ticket.UUID()}, http.StatusOK, nil
 	}
@@ -110,7 +134,7 @@ func handleReceive(ctx context.Context, rt *runtime.Runtime, r *http.Request, l
 	}
 
 	// check if reply is actually a command
-	if strings.ToLower(strings.TrimSpace(request.StrippedText)) == "close" {
+	if strings.ToLower(strings.TrimSpace(email.Text)) == "close" {
 		err = tickets.Close(ctx, rt, oa, ticket, true, l)
 		if err != nil {
 			return errors.Wrapf(err, "error closing ticket: %s", ticket.UUID()), http.StatusInternalServerError, nil
@@ -120,7 +144,7 @@ func handleReceive(ctx context.Context, rt *runtime.Runtime, r *http.Request, l
 	}
 
 	// update our ticket config
-	err = models.UpdateTicketConfig(ctx, rt.DB, ticket, map[string]string{ticketConfigLastMessageID: request.MessageID})
+	err = models.UpdateTicketConfig(ctx, rt.DB, ticket, map[string]string{ticketConfigLastMessageID: email.MessageID})
 	if err != nil {
 		return errors.Wrapf(err, "error updating ticket: %s", ticket.UUID()), http.StatusInternalServerError, nil
 	}
@@ -133,7 +157,7 @@ func handleReceive(ctx context.Context, rt *runtime.Runtime, r *http.Request, l
 		}
 	}
 
-	msg, err := tickets.SendReply(ctx, rt, ticket, request.StrippedText, files)
+	msg, err := tickets.SendReply(ctx, rt, ticket, email.Text, email.Files)
 	if err != nil {
 		return err, http.StatusInternalServerError, nil
 	}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/pkg/errors"
+)
+
+// SignatureHeader is the header which contains the signature of the request body, both on requests we make and
+// on requests we receive
+const SignatureHeader = "X-Mailroom-Signature"
+
+// Client is a basic client for posting signed JSON payloads to a webhook
+type Client struct {
+	httpClient  *http.Client
+	httpRetries *httpx.RetryConfig
+	url         string
+	secret      string
+}
+
+// NewClient creates a new webhook client
+func NewClient(httpClient *http.Client, httpRetries *httpx.RetryConfig, url, secret string) *Client {
+	return &Client{
+		httpClient:  httpClient,
+		httpRetries: httpRetries,
+		url:         url,
+		secret:      secret,
+	}
+}
+
+// Post posts the given JSON payload to the webhook, signed with our secret
+func (c *Client) Post(payload []byte) (*httpx.Trace, error) {
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		SignatureHeader: Sign(c.secret, payload),
+	}
+
+	req, err := httpx.NewRequest("POST", c.url, bytes.NewReader(payload), headers)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := httpx.DoTrace(c.httpClient, req, c.httpRetries, nil, -1)
+	if err != nil {
+		return trace, err
+	}
+
+	if trace.Response.StatusCode/100 != 2 {
+		return trace, errors.Errorf("webhook returned non-2XX response: %d", trace.Response.StatusCode)
+	}
+
+	return trace, nil
+}
+
+// Sign calculates the hex encoded HMAC-SHA256 signature of the given body using the given secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that the given signature is valid for the given body
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
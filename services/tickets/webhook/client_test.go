@@ -0,0 +1,52 @@
+package webhook_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/services/tickets/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	webhookURL = "https://helpdesk.example.com/mailroom"
+	secret     = "sesame"
+)
+
+func TestPost(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		webhookURL: {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(400, nil, `{"error": "no such ticket"}`),
+			httpx.NewMockResponse(200, nil, `{"external_id": "HD-123"}`),
+		},
+	}))
+
+	client := webhook.NewClient(http.DefaultClient, nil, webhookURL, secret)
+	payload := []byte(`{"event": "ticket_opened"}`)
+
+	_, err := client.Post(payload)
+	assert.EqualError(t, err, "unable to connect to server")
+
+	_, err = client.Post(payload)
+	assert.EqualError(t, err, "webhook returned non-2XX response: 400")
+
+	trace, err := client.Post(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", trace.Request.Header.Get("Content-Type"))
+	assert.Equal(t, webhook.Sign(secret, payload), trace.Request.Header.Get("X-Mailroom-Signature"))
+	assert.Equal(t, `{"external_id": "HD-123"}`, string(trace.ResponseBody))
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type": "close"}`)
+
+	assert.Equal(t, "76a4f34aaa12ab9e9f2d880fa2241d3f65e504bca4e428eb4c6a1dfe97a16df1", webhook.Sign("sesame", body))
+	assert.True(t, webhook.Verify("sesame", body, webhook.Sign("sesame", body)))
+	assert.False(t, webhook.Verify("sesame", body, webhook.Sign("other", body)))
+	assert.False(t, webhook.Verify("sesame", body, ""))
+}
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	typeWebhook = "webhook"
+
+	configURL             = "url"
+	configSecret          = "secret"
+	configPayloadTemplate = "payload_template"
+
+	ticketConfigContactUUID    = "contact-uuid"
+	ticketConfigContactDisplay = "contact-display"
+
+	eventTicketOpened   = "ticket_opened"
+	eventMsgForwarded   = "msg_forwarded"
+	eventTicketClosed   = "ticket_closed"
+	eventTicketReopened = "ticket_reopened"
+)
+
+// the default payload is the entire template context as JSON
+const defaultPayloadTemplate = `{{json .}}`
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := jsonx.Marshal(v)
+		return string(b), err
+	},
+}
+
+func init() {
+	models.RegisterTicketService(typeWebhook, NewService)
+}
+
+type service struct {
+	client      *Client
+	ticketer    *flows.Ticketer
+	template    *template.Template
+	callbackURL string
+	redactor    utils.Redactor
+}
+
+// NewService creates a new generic webhook ticket service
+func NewService(rtCfg *runtime.Config, httpClient *http.Client, httpRetries *httpx.RetryConfig, ticketer *flows.Ticketer, config map[string]string) (models.TicketService, error) {
+	url := config[configURL]
+	secret := config[configSecret]
+	payloadTemplate := config[configPayloadTemplate]
+
+	if url == "" || secret == "" {
+		return nil, errors.New("missing url or secret config")
+	}
+
+	if payloadTemplate == "" {
+		payloadTemplate = defaultPayloadTemplate
+	}
+	tpl, err := template.New("payload").Funcs(templateFuncs).Parse(payloadTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payload_template config")
+	}
+
+	return &service{
+		client:      NewClient(httpClient, httpRetries, url, secret),
+		ticketer:    ticketer,
+		template:    tpl,
+		callbackURL: fmt.Sprintf("https://%s/mr/tickets/types/webhook/%s/event", rtCfg.Domain, ticketer.UUID()),
+		redactor:    utils.NewRedactor(flows.RedactionMask, secret),
+	}, nil
+}
+
+// Open opens a ticket by notifying the webhook, which can optionally respond with an external ID for the ticket
+func (s *service) Open(session flows.Session, topic *flows.Topic, body string, assignee *flows.User, logHTTP flows.HTTPLogCallback) (*flows.Ticket, error) {
+	ticket := flows.OpenTicket(s.ticketer, topic, body, assignee)
+
+	topicName := ""
+	if topic != nil {
+		topicName = topic.Name()
+	}
+
+	context := s.templateContext(eventTicketOpened, map[string]interface{}{
+		"uuid":        ticket.UUID(),
+		"external_id": "",
+		"topic":       topicName,
+		"body":        body,
+	}, string(session.Contact().UUID()), tickets.GetContactDisplay(session.Environment(), session.Contact()))
+
+	trace, err := s.post(context, logHTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &struct {
+		ExternalID string `json:"external_id"`
+	}{}
+
+	// responding with an external ID is optional so ignore any response we can't parse
+	if len(trace.ResponseBody) > 0 && jsonx.Unmarshal(trace.ResponseBody, response) == nil && response.ExternalID != "" {
+		ticket.SetExternalID(response.ExternalID)
+	}
+
+	return ticket, nil
+}
+
+// Forward forwards a message from the contact to the webhook
+func (s *service) Forward(ticket *models.Ticket, msgUUID flows.MsgUUID, text string, attachments []utils.Attachment, logHTTP flows.HTTPLogCallback) error {
+	attachmentURLs := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		attachmentURLs[i] = attachment.URL()
+	}
+
+	context := s.ticketContext(eventMsgForwarded, ticket)
+	context["msg"] = map[string]interface{}{
+		"uuid":        msgUUID,
+		"text":        text,
+		"attachments": attachmentURLs,
+	}
+
+	_, err := s.post(context, logHTTP)
+	return err
+}
+
+// Close notifies the webhook of each ticket that has been closed
+func (s *service) Close(tickets []*models.Ticket, logHTTP flows.HTTPLogCallback) error {
+	for _, ticket := range tickets {
+		if _, err := s.post(s.ticketContext(eventTicketClosed, ticket), logHTTP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reopen notifies the webhook of each ticket that has been reopened
+func (s *service) Reopen(tickets []*models.Ticket, logHTTP flows.HTTPLogCallback) error {
+	for _, ticket := range tickets {
+		if _, err := s.post(s.ticketContext(eventTicketReopened, ticket), logHTTP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluates our payload template with the given context and posts the result to the webhook
+func (s *service) post(context map[string]interface{}, logHTTP flows.HTTPLogCallback) (*httpx.Trace, error) {
+	payload := &bytes.Buffer{}
+	if err := s.template.Execute(payload, context); err != nil {
+		return nil, errors.Wrap(err, "error evaluating payload template")
+	}
+
+	trace, err := s.client.Post(payload.Bytes())
+	if trace != nil {
+		logHTTP(flows.NewHTTPLog(trace, flows.HTTPStatusFromCode, s.redactor))
+	}
+	if err != nil {
+		return trace, errors.Wrap(err, "error calling ticket webhook")
+	}
+	return trace, nil
+}
+
+func (s *service) ticketContext(event string, ticket *models.Ticket) map[string]interface{} {
+	return s.templateContext(event, map[string]interface{}{
+		"uuid":        ticket.UUID(),
+		"external_id": string(ticket.ExternalID()),
+		"body":        ticket.Body(),
+	}, ticket.Config(ticketConfigContactUUID), ticket.Config(ticketConfigContactDisplay))
+}
+
+func (s *service) templateContext(event string, ticket map[string]interface{}, contactUUID, contactDisplay string) map[string]interface{} {
+	return map[string]interface{}{
+		"event":        event,
+		"ticketer":     s.ticketer.UUID(),
+		"ticket":       ticket,
+		"contact":      map[string]interface{}{"uuid": contactUUID, "display": contactDisplay},
+		"callback_url": s.callbackURL,
+	}
+}
@@ -0,0 +1,146 @@
+package webhook_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/goflow/assets/static"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/test"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets/webhook"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConfig() *runtime.Config {
+	cfg := runtime.NewDefaultConfig()
+	cfg.Domain = "mailroom.io"
+	return cfg
+}
+
+func TestOpenAndForward(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	session, _, err := test.CreateTestSession("", envs.RedactionPolicyNone)
+	require.NoError(t, err)
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		webhookURL: {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(201, nil, `{"external_id": "HD-123"}`),
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(200, nil, ``),
+		},
+	}))
+
+	ticketer := flows.NewTicketer(static.NewTicketer("f9ea0b17-56b6-4ee2-8bc8-7c9e4a6a3a9c", "Support", "webhook"))
+
+	_, err = webhook.NewService(newConfig(), http.DefaultClient, nil, ticketer, map[string]string{})
+	assert.EqualError(t, err, "missing url or secret config")
+
+	_, err = webhook.NewService(newConfig(), http.DefaultClient, nil, ticketer, map[string]string{
+		"url":              webhookURL,
+		"secret":           secret,
+		"payload_template": `{"subject": {{json .ticket.body}`,
+	})
+	assert.EqualError(t, err, "invalid payload_template config: template: payload:1: bad character U+007D '}'")
+
+	svc, err := webhook.NewService(newConfig(), http.DefaultClient, nil, ticketer, map[string]string{
+		"url":              webhookURL,
+		"secret":           secret,
+		"payload_template": `{"subject": {{json .ticket.body}}, "requester": {{json .contact.display}}, "callback": {{json .callback_url}}}`,
+	})
+	require.NoError(t, err)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+	defaultTopic := oa.SessionAssets().Topics().FindByName("General")
+
+	logger := &flows.HTTPLogger{}
+	_, err = svc.Open(session, defaultTopic, "Where are my cookies?", nil, logger.Log)
+	assert.EqualError(t, err, "error calling ticket webhook: unable to connect to server")
+
+	logger = &flows.HTTPLogger{}
+	ticket, err := svc.Open(session, defaultTopic, "Where are my cookies?", nil, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, "General", ticket.Topic().Name())
+	assert.Equal(t, "Where are my cookies?", ticket.Body())
+	assert.Equal(t, "HD-123", ticket.ExternalID())
+	assert.Equal(t, 1, len(logger.Logs))
+	test.AssertSnapshot(t, "open_ticket", logger.Logs[0].Request)
+
+	// forwarding uses the default template which includes everything
+	svc, err = webhook.NewService(newConfig(), http.DefaultClient, nil, ticketer, map[string]string{
+		"url":    webhookURL,
+		"secret": secret,
+	})
+	require.NoError(t, err)
+
+	dbTicket := models.NewTicket("88bfa1dc-be33-45c2-b469-294ecb0eba90", testdata.Org1.ID, testdata.Cathy.ID, testdata.RocketChat.ID, "HD-123", testdata.DefaultTopic.ID, "Where are my cookies?", models.NilUserID, map[string]interface{}{
+		"contact-uuid":    string(testdata.Cathy.UUID),
+		"contact-display": "Cathy",
+	})
+
+	logger = &flows.HTTPLogger{}
+	err = svc.Forward(dbTicket, flows.MsgUUID("4fa340ae-1fb0-4666-98db-2177fe9bf31c"), "It's urgent", nil, logger.Log)
+	assert.EqualError(t, err, "error calling ticket webhook: unable to connect to server")
+
+	logger = &flows.HTTPLogger{}
+	attachments := []utils.Attachment{"image/jpg:https://link.to/image.jpg"}
+	err = svc.Forward(dbTicket, flows.MsgUUID("4fa340ae-1fb0-4666-98db-2177fe9bf31c"), "It's urgent", attachments, logger.Log)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(logger.Logs))
+	test.AssertSnapshot(t, "forward_message", logger.Logs[0].Request)
+}
+
+func TestCloseAndReopen(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]httpx.MockResponse{
+		webhookURL: {
+			httpx.MockConnectionError,
+			httpx.NewMockResponse(200, nil, ``),
+			httpx.NewMockResponse(200, nil, ``),
+			httpx.NewMockResponse(200, nil, ``),
+		},
+	}))
+
+	ticketer := flows.NewTicketer(static.NewTicketer("f9ea0b17-56b6-4ee2-8bc8-7c9e4a6a3a9c", "Support", "webhook"))
+	svc, err := webhook.NewService(newConfig(), http.DefaultClient, nil, ticketer, map[string]string{
+		"url":    webhookURL,
+		"secret": secret,
+	})
+	require.NoError(t, err)
+
+	ticket1 := models.NewTicket("88bfa1dc-be33-45c2-b469-294ecb0eba90", testdata.Org1.ID, testdata.Cathy.ID, testdata.RocketChat.ID, "HD-123", testdata.DefaultTopic.ID, "Where are my cookies?", models.NilUserID, map[string]interface{}{
+		"contact-uuid":    string(testdata.Cathy.UUID),
+		"contact-display": "Cathy",
+	})
+	ticket2 := models.NewTicket("645eee60-7e84-4a9e-ade3-4fce01ae28f1", testdata.Org1.ID, testdata.Bob.ID, testdata.RocketChat.ID, "HD-124", testdata.DefaultTopic.ID, "Where my shoes?", models.NilUserID, nil)
+
+	logger := &flows.HTTPLogger{}
+	err = svc.Close([]*models.Ticket{ticket1, ticket2}, logger.Log)
+	assert.EqualError(t, err, "error calling ticket webhook: unable to connect to server")
+
+	logger = &flows.HTTPLogger{}
+	err = svc.Close([]*models.Ticket{ticket1, ticket2}, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(logger.Logs))
+	test.AssertSnapshot(t, "close_tickets", logger.Logs[0].Request)
+
+	logger = &flows.HTTPLogger{}
+	err = svc.Reopen([]*models.Ticket{ticket2}, logger.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(logger.Logs))
+	assert.Contains(t, logger.Logs[0].Request, `"event":"ticket_reopened"`)
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tickets"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	base := "/mr/tickets/types/webhook"
+
+	web.RegisterJSONRoute(http.MethodPost, base+"/{ticketer:[a-f0-9\\-]+}/event", web.WithHTTPLogs(handleEvent))
+}
+
+// Events posted to us by the help desk, signed in the same way as the requests we make to it, e.g.
+//
+//   {
+//     "type": "reply",
+//     "ticket_uuid": "88bfa1dc-be33-45c2-b469-294ecb0eba90",
+//     "text": "We can help",
+//     "attachments": ["https://helpdesk.com/files/image.jpg"]
+//   }
+//
+type eventRequest struct {
+	Type        string           `json:"type"        validate:"required,eq=reply|eq=close|eq=reopen"`
+	TicketUUID  flows.TicketUUID `json:"ticket_uuid" validate:"required,uuid"`
+	Text        string           `json:"text"`
+	Attachments []string         `json:"attachments"`
+}
+
+func handleEvent(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *models.HTTPLogger) (interface{}, int, error) {
+	ticketerUUID := assets.TicketerUUID(chi.URLParam(r, "ticketer"))
+
+	// look up ticketer
+	ticketer, _, err := tickets.FromTicketerUUID(ctx, rt, ticketerUUID, typeWebhook)
+	if err != nil {
+		return errors.Errorf("no such ticketer %s", ticketerUUID), http.StatusNotFound, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, web.MaxRequestBytes))
+	if err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	// check signature
+	if !Verify(ticketer.Config(configSecret), body, r.Header.Get(SignatureHeader)) {
+		return map[string]string{"status": "unauthorized"}, http.StatusUnauthorized, nil
+	}
+
+	request := &eventRequest{}
+	if err := utils.UnmarshalAndValidate(body, request); err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	// look up ticket
+	ticket, _, _, err := tickets.FromTicketUUID(ctx, rt, request.TicketUUID, typeWebhook)
+	if err != nil || ticket.TicketerID() != ticketer.ID() {
+		return errors.Errorf("no such ticket %s", request.TicketUUID), http.StatusNotFound, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, ticket.OrgID())
+	if err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	switch request.Type {
+	case "reply":
+		// fetch files
+		files := make([]*tickets.File, len(request.Attachments))
+		for i, attachment := range request.Attachments {
+			files[i], err = tickets.FetchFile(attachment, nil)
+			if err != nil {
+				return errors.Wrapf(err, "error fetching ticket file '%s'", attachment), http.StatusBadRequest, nil
+			}
+		}
+
+		_, err = tickets.SendReply(ctx, rt, ticket, request.Text, files)
+
+	case "close":
+		err = tickets.Close(ctx, rt, oa, ticket, false, l)
+
+	case "reopen":
+		err = tickets.Reopen(ctx, rt, oa, ticket, false, l)
+	}
+
+	if err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	return map[string]string{"status": "handled"}, http.StatusOK, nil
+}
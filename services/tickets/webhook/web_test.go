@@ -0,0 +1,41 @@
+package webhook_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/services/tickets/webhook"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/web"
+)
+
+func TestEvent(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
+
+	ticketer := testdata.InsertTicketer(db, testdata.Org1, "webhook", "Help Desk", map[string]interface{}{"url": webhookURL, "secret": secret})
+
+	// create a webhook ticket for Cathy
+	ticket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, ticketer, testdata.DefaultTopic, "Have you seen my cookies?", "HD-123", time.Now(), nil)
+
+	// requests must be signed so calculate signatures for each of the bodies used in the test cases
+	bodies := map[string]string{
+		"missing_type":   fmt.Sprintf(`{"ticket_uuid":"%s"}`, ticket.UUID),
+		"unknown_ticket": `{"type":"close","ticket_uuid":"88bfa1dc-be33-45c2-b469-294ecb0eba90"}`,
+		"reply":          fmt.Sprintf(`{"type":"reply","ticket_uuid":"%s","text":"We can help"}`, ticket.UUID),
+		"close":          fmt.Sprintf(`{"type":"close","ticket_uuid":"%s"}`, ticket.UUID),
+		"reopen":         fmt.Sprintf(`{"type":"reopen","ticket_uuid":"%s"}`, ticket.UUID),
+	}
+	substitutions := map[string]string{
+		"ticketer_uuid":     string(ticketer.UUID),
+		"cathy_ticket_uuid": string(ticket.UUID),
+	}
+	for name, body := range bodies {
+		substitutions[name+"_signature"] = webhook.Sign(secret, []byte(body))
+	}
+
+	web.RunWebTests(t, ctx, rt, "testdata/event.json", substitutions)
+}
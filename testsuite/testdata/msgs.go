@@ -46,6 +46,11 @@ func InsertErroredOutgoingMsg(db *sqlx.DB, org *Org, channel *Channel, contact *
 	return insertOutgoingMsg(db, org, channel, contact, text, nil, models.MsgStatusErrored, highPriority, errorCount, &nextAttempt)
 }
 
+// InsertHeldOutgoingMsg inserts a PENDING(P) outgoing message which is being held until the given time
+func InsertHeldOutgoingMsg(db *sqlx.DB, org *Org, channel *Channel, contact *Contact, text string, releaseOn time.Time) *flows.MsgOut {
+	return insertOutgoingMsg(db, org, channel, contact, text, nil, models.MsgStatusPending, false, 0, &releaseOn)
+}
+
 func insertOutgoingMsg(db *sqlx.DB, org *Org, channel *Channel, contact *Contact, text string, attachments []utils.Attachment, status models.MsgStatus, highPriority bool, errorCount int, nextAttempt *time.Time) *flows.MsgOut {
 	var channelRef *assets.ChannelReference
 	var channelID models.ChannelID
@@ -9,6 +9,7 @@ import (
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/null"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -39,6 +40,17 @@ type Ticketer struct {
 	UUID assets.TicketerUUID
 }
 
+// InsertTicketer inserts a ticketer
+func InsertTicketer(db *sqlx.DB, org *Org, ticketerType, name string, config map[string]interface{}) *Ticketer {
+	uuid := assets.TicketerUUID(uuids.New())
+	var id models.TicketerID
+	must(db.Get(&id,
+		`INSERT INTO tickets_ticketer(uuid, org_id, ticketer_type, name, config, is_system, is_active, created_on, modified_on, created_by_id, modified_by_id)
+		VALUES($1, $2, $3, $4, $5, FALSE, TRUE, NOW(), NOW(), 1, 1) RETURNING id`, uuid, org.ID, ticketerType, name, null.NewMap(config),
+	))
+	return &Ticketer{id, uuid}
+}
+
 // InsertOpenTicket inserts an open ticket
 func InsertOpenTicket(db *sqlx.DB, org *Org, contact *Contact, ticketer *Ticketer, topic *Topic, body, externalID string, openedOn time.Time, assignee *User) *Ticket {
 	return insertTicket(db, org, contact, ticketer, models.TicketStatusOpen, topic, body, externalID, openedOn, assignee)
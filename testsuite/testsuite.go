@@ -187,6 +187,7 @@ DELETE FROM request_logs_httplog;
 DELETE FROM tickets_ticketdailycount;
 DELETE FROM tickets_ticketevent;
 DELETE FROM tickets_ticket;
+DELETE FROM tickets_ticketer WHERE id >= 30000;
 DELETE FROM triggers_trigger_contacts WHERE trigger_id >= 30000;
 DELETE FROM triggers_trigger_groups WHERE trigger_id >= 30000;
 DELETE FROM triggers_trigger WHERE id >= 30000;
@@ -214,6 +215,7 @@ DELETE FROM contacts_contactgroup WHERE id >= 30000;
 
 ALTER SEQUENCE flows_flow_id_seq RESTART WITH 30000;
 ALTER SEQUENCE tickets_ticket_id_seq RESTART WITH 1;
+ALTER SEQUENCE tickets_ticketer_id_seq RESTART WITH 30000;
 ALTER SEQUENCE msgs_msg_id_seq RESTART WITH 1;
 ALTER SEQUENCE flows_flowrun_id_seq RESTART WITH 1;
 ALTER SEQUENCE flows_flowsession_id_seq RESTART WITH 1;
@@ -0,0 +1,123 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Type is a type of compression
+type Type string
+
+// supported compression types
+const (
+	TypeNone Type = "none"
+	TypeGzip Type = "gzip"
+	TypeZstd Type = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Extension returns the file extension that should be appended to compressed files of this type
+func (t Type) Extension() string {
+	switch t {
+	case TypeGzip:
+		return ".gz"
+	case TypeZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// ContentType returns the content type of compressed data of this type, or the passed in type if not compressed
+func (t Type) ContentType(uncompressed string) string {
+	switch t {
+	case TypeGzip:
+		return "application/gzip"
+	case TypeZstd:
+		return "application/zstd"
+	}
+	return uncompressed
+}
+
+// Compress compresses the given data using the given compression type
+func Compress(t Type, data []byte) ([]byte, error) {
+	b := &bytes.Buffer{}
+
+	switch t {
+	case TypeGzip:
+		w := gzip.NewWriter(b)
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.Wrap(err, "error writing gzip data")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "error closing gzip writer")
+		}
+	case TypeZstd:
+		w, err := zstd.NewWriter(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating zstd writer")
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.Wrap(err, "error writing zstd data")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "error closing zstd writer")
+		}
+	case TypeNone, "":
+		return data, nil
+	default:
+		return nil, errors.Errorf("unknown compression type: %s", t)
+	}
+
+	return b.Bytes(), nil
+}
+
+// Detect sniffs the compression type of the given data from its magic bytes
+func Detect(data []byte) Type {
+	if bytes.HasPrefix(data, gzipMagic) {
+		return TypeGzip
+	} else if bytes.HasPrefix(data, zstdMagic) {
+		return TypeZstd
+	}
+	return TypeNone
+}
+
+// Decompress decompresses the given data, detecting the compression type used. Data which isn't compressed is
+// returned as is.
+func Decompress(data []byte) ([]byte, error) {
+	switch Detect(data) {
+	case TypeGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating gzip reader")
+		}
+		defer r.Close()
+
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading gzip data")
+		}
+		return out, nil
+	case TypeZstd:
+		r, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating zstd reader")
+		}
+		defer r.Close()
+
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading zstd data")
+		}
+		return out, nil
+	}
+
+	return data, nil
+}
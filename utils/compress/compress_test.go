@@ -0,0 +1,44 @@
+package compress_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/mailroom/utils/compress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	data := []byte(`{"uuid": "8a7fc501-177b-4567-a0aa-81c48e6de1c5", "status": "waiting", "runs": [{"status": "waiting"}, {"status": "waiting"}]}`)
+
+	tcs := []struct {
+		typ         compress.Type
+		extension   string
+		contentType string
+	}{
+		{compress.TypeNone, "", "application/json"},
+		{compress.TypeGzip, ".gz", "application/gzip"},
+		{compress.TypeZstd, ".zst", "application/zstd"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.extension, tc.typ.Extension(), "extension mismatch for %s", tc.typ)
+		assert.Equal(t, tc.contentType, tc.typ.ContentType("application/json"), "content type mismatch for %s", tc.typ)
+
+		compressed, err := compress.Compress(tc.typ, data)
+		require.NoError(t, err)
+		assert.Equal(t, tc.typ, compress.Detect(compressed), "detect mismatch for %s", tc.typ)
+
+		decompressed, err := compress.Decompress(compressed)
+		require.NoError(t, err)
+		assert.Equal(t, data, decompressed, "round trip mismatch for %s", tc.typ)
+	}
+
+	_, err := compress.Compress("xz", data)
+	assert.EqualError(t, err, "unknown compression type: xz")
+
+	// truncated compressed data should error
+	compressed, _ := compress.Compress(compress.TypeGzip, data)
+	_, err = compress.Decompress(compressed[:10])
+	assert.Error(t, err)
+}
@@ -7,31 +7,38 @@ import (
 	"time"
 
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/metrics"
 	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	cronDuration = metrics.NewHistogram("mailroom_cron_duration_seconds", "the time taken by cron runs", metrics.DefaultBuckets, "cron")
+	cronErrors   = metrics.NewCounter("mailroom_cron_errors_total", "the number of cron runs which errored or panicked", "cron")
+)
+
 // Function is the function that will be called on our schedule
 type Function func(context.Context, *runtime.Runtime) error
 
-// Start calls the passed in function every interval, making sure it acquires a
-// lock so that only one process is running at once. Note that across processes
-// crons may be called more often than duration as there is no inter-process
-// coordination of cron fires. (this might be a worthy addition)
-func Start(rt *runtime.Runtime, wg *sync.WaitGroup, name string, interval time.Duration, allInstances bool, cronFunc Function, timeout time.Duration, quit chan bool) {
+// Start calls the passed in function on the given schedule, making sure it acquires a lock so that only one process
+// is running at once. The last and next fire times are stored in Redis so that instances coordinate fires, and
+// restarts neither fire early nor skip a fire that was missed whilst stopped.
+func Start(rt *runtime.Runtime, wg *sync.WaitGroup, name string, schedule Schedule, allInstances bool, cronFunc Function, timeout time.Duration, quit chan bool) {
 	wg.Add(1) // add ourselves to the wait group
 
 	lockName := fmt.Sprintf("lock:%s_lock", name) // for historical reasons...
+	statusKey := name
 
-	// for jobs that run on all instances, the lock key is specific to this instance
+	// for jobs that run on all instances, the lock key and status are specific to this instance
 	if allInstances {
 		lockName = fmt.Sprintf("%s:%s", lockName, rt.Config.InstanceName)
+		statusKey = fmt.Sprintf("%s:%s", name, rt.Config.InstanceName)
 	}
 
 	locker := redisx.NewLocker(lockName, time.Minute*5)
 
 	wait := time.Duration(0)
-	lastFire := time.Now()
 
 	log := logrus.WithField("cron", name).WithField("lockName", lockName)
 
@@ -48,55 +55,95 @@ func Start(rt *runtime.Runtime, wg *sync.WaitGroup, name string, interval time.D
 				return
 
 			case <-time.After(wait):
-				lastFire = time.Now()
-
-				// try to get lock but don't retry - if lock is taken then task is still running or running on another instance
-				lock, err := locker.Grab(rt.RP, 0)
-				if err != nil {
-					break
-				}
-				log := log.WithField("lock", lock)
-
-				if lock == "" {
-					log.Debug("lock already present, sleeping")
-					break
+				nextFire := tick(rt, locker, statusKey, schedule, cronFunc, log)
+				if nextFire.IsZero() {
+					log.WithField("schedule", schedule.String()).Error("cron schedule has no next fire time")
+					return
 				}
 
-				// ok, got the lock, run our cron function
-				start := time.Now()
-				err = fireCron(rt, cronFunc, lockName, lock)
-				if err != nil {
-					log.WithError(err).Error("error while running cron")
+				wait = time.Until(nextFire)
+				if wait < time.Duration(0) {
+					wait = time.Duration(0)
 				}
-				elapsed := time.Since(start)
+			}
+		}
+	}()
+}
 
-				// release our lock
-				err = locker.Release(rt.RP, lock)
-				if err != nil {
-					log.WithError(err).Error("error releasing lock")
-				}
+// tick fires the cron if we can get the lock and it is due, and returns when it should next fire
+func tick(rt *runtime.Runtime, locker *redisx.Locker, statusKey string, schedule Schedule, cronFunc Function, log *logrus.Entry) time.Time {
+	now := time.Now()
 
-				// if cron too longer than a minute, log
-				if elapsed > time.Minute {
-					logrus.WithField("cron", name).WithField("elapsed", elapsed).Error("cron took too long")
-				}
-			}
+	// try to get lock but don't retry - if lock is taken then task is still running or running on another instance
+	lock, err := locker.Grab(rt.RP, 0)
+	if err != nil || lock == "" {
+		log.Debug("lock already present, sleeping")
+		return nextFireAfter(rt, statusKey, schedule, now, log)
+	}
+	log = log.WithField("lock", lock)
 
-			// calculate our next fire time
-			nextFire := NextFire(lastFire, interval)
-			wait = time.Until(nextFire)
-			if wait < time.Duration(0) {
-				wait = time.Duration(0)
-			}
+	defer func() {
+		// release our lock
+		if err := locker.Release(rt.RP, lock); err != nil {
+			log.WithError(err).Error("error releasing lock")
 		}
 	}()
+
+	// check this fire hasn't already happened on another instance or before we were restarted
+	status, err := getStatus(rt, statusKey)
+	if err != nil {
+		log.WithError(err).Error("error reading cron status")
+	} else if status != nil && now.Before(status.NextFire) {
+		return status.NextFire
+	}
+
+	// ok, got the lock, run our cron function
+	err = fireCron(rt, cronFunc)
+	if err != nil {
+		log.WithError(err).Error("error while running cron")
+		cronErrors.Inc(statusKey)
+	}
+	elapsed := time.Since(now)
+	cronDuration.ObserveDuration(elapsed, statusKey)
+
+	// if cron too longer than a minute, log
+	if elapsed > time.Minute {
+		log.WithField("elapsed", elapsed).Error("cron took too long")
+	}
+
+	status = &Status{
+		Name:         statusKey,
+		Schedule:     schedule.String(),
+		LastStart:    now,
+		LastDuration: int(elapsed / time.Millisecond),
+		NextFire:     schedule.Next(now),
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	if err := setStatus(rt, status); err != nil {
+		log.WithError(err).Error("error writing cron status")
+	}
+
+	return status.NextFire
 }
 
-// fireCron is just a wrapper around the cron function we will call for the purposes of
-// catching and logging panics
-func fireCron(rt *runtime.Runtime, cronFunc Function, lockName string, lockValue string) error {
-	log := logrus.WithField("lockValue", lockValue).WithField("func", cronFunc)
+// nextFireAfter returns when a cron that didn't fire at the given time should next try, using the stored next fire
+// time if another instance has recorded one
+func nextFireAfter(rt *runtime.Runtime, statusKey string, schedule Schedule, now time.Time, log *logrus.Entry) time.Time {
+	status, err := getStatus(rt, statusKey)
+	if err != nil {
+		log.WithError(err).Error("error reading cron status")
+	} else if status != nil && status.NextFire.After(now) {
+		return status.NextFire
+	}
+	return schedule.Next(now)
+}
 
+// fireCron is just a wrapper around the cron function we will call for the purposes of
+// catching panics and returning them as errors
+func fireCron(rt *runtime.Runtime, cronFunc Function) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
 	defer cancel()
 
@@ -104,7 +151,7 @@ func fireCron(rt *runtime.Runtime, cronFunc Function, lockName string, lockValue
 		// catch any panics and recover
 		panicLog := recover()
 		if panicLog != nil {
-			log.Errorf("panic running cron: %s", panicLog)
+			err = errors.Errorf("panic running cron: %s", panicLog)
 		}
 	}()
 
@@ -10,6 +10,7 @@ import (
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/utils/cron"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,7 +50,7 @@ func TestCron(t *testing.T) {
 	align()
 
 	// start a job that takes ~100 ms and runs every 250ms
-	cron.Start(rt, wg, "test1", time.Millisecond*250, false, createCronFunc(&running, &fired, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(rt, wg, "test1", cron.Every(time.Millisecond*250), false, createCronFunc(&running, &fired, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
 
 	// wait a bit, should only have fired three times (initial time + three repeats)
 	time.Sleep(time.Millisecond * 875) // time for 3 delays between tasks plus half of another delay
@@ -68,7 +69,7 @@ func TestCron(t *testing.T) {
 	align()
 
 	// simulate the job taking 400ms to run on the second fire, thus skipping the third fire
-	cron.Start(rt, wg, "test2", time.Millisecond*250, false, createCronFunc(&running, &fired, map[int]time.Duration{1: time.Millisecond * 400}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(rt, wg, "test2", cron.Every(time.Millisecond*250), false, createCronFunc(&running, &fired, map[int]time.Duration{1: time.Millisecond * 400}, time.Millisecond*100), time.Minute, quit)
 
 	time.Sleep(time.Millisecond * 875)
 	assert.Equal(t, 3, fired)
@@ -92,8 +93,8 @@ func TestCron(t *testing.T) {
 
 	align()
 
-	cron.Start(&rt1, wg, "test3", time.Millisecond*250, false, createCronFunc(&running, &fired1, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
-	cron.Start(&rt2, wg, "test3", time.Millisecond*250, false, createCronFunc(&running, &fired2, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(&rt1, wg, "test3", cron.Every(time.Millisecond*250), false, createCronFunc(&running, &fired1, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(&rt2, wg, "test3", cron.Every(time.Millisecond*250), false, createCronFunc(&running, &fired2, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
 
 	// same number of fires as if only a single instance was running it...
 	time.Sleep(time.Millisecond * 875)
@@ -110,8 +111,8 @@ func TestCron(t *testing.T) {
 	align()
 
 	// unless we start the cron with allInstances = true
-	cron.Start(&rt1, wg, "test4", time.Millisecond*250, true, createCronFunc(&running1, &fired1, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
-	cron.Start(&rt2, wg, "test4", time.Millisecond*250, true, createCronFunc(&running2, &fired2, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(&rt1, wg, "test4", cron.Every(time.Millisecond*250), true, createCronFunc(&running1, &fired1, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
+	cron.Start(&rt2, wg, "test4", cron.Every(time.Millisecond*250), true, createCronFunc(&running2, &fired2, map[int]time.Duration{}, time.Millisecond*100), time.Minute, quit)
 
 	// now both instances fire 4 times
 	time.Sleep(time.Millisecond * 875)
@@ -121,6 +122,82 @@ func TestCron(t *testing.T) {
 	close(quit)
 }
 
+func TestCronStatus(t *testing.T) {
+	_, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	fired := 0
+	cronFunc := func(ctx context.Context, rt *runtime.Runtime) error {
+		fired++
+		return errors.New("boom")
+	}
+
+	wg := &sync.WaitGroup{}
+	quit := make(chan bool)
+
+	cron.Start(rt, wg, "test1", cron.Every(time.Hour), false, cronFunc, time.Minute, quit)
+
+	time.Sleep(time.Millisecond * 100)
+	close(quit)
+	wg.Wait()
+
+	assert.Equal(t, 1, fired)
+
+	// restarting shouldn't fire again as the next fire time is recorded
+	quit = make(chan bool)
+	cron.Start(rt, wg, "test1", cron.Every(time.Hour), false, cronFunc, time.Minute, quit)
+
+	time.Sleep(time.Millisecond * 100)
+	close(quit)
+	wg.Wait()
+
+	assert.Equal(t, 1, fired)
+
+	statuses, err := cron.GetStatuses(rc)
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "test1", statuses[0].Name)
+	assert.Equal(t, "@every 1h0m0s", statuses[0].Schedule)
+	assert.Equal(t, "boom", statuses[0].LastError)
+	assert.Equal(t, statuses[0].LastStart.Add(time.Hour), statuses[0].NextFire)
+}
+
+func TestParseExpression(t *testing.T) {
+	tcs := []struct {
+		expression string
+		last       time.Time
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2021, 11, 1, 12, 30, 15, 0, time.UTC), time.Date(2021, 11, 1, 12, 31, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2021, 11, 1, 12, 30, 15, 0, time.UTC), time.Date(2021, 11, 1, 18, 0, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2021, 11, 1, 23, 0, 0, 0, time.UTC), time.Date(2021, 11, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2021, 11, 1, 18, 0, 0, 0, time.UTC), time.Date(2021, 11, 2, 0, 0, 0, 0, time.UTC)},
+		{"15,45 9-17 * * 1-5", time.Date(2021, 11, 5, 17, 50, 0, 0, time.UTC), time.Date(2021, 11, 8, 9, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC), time.Date(2021, 11, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC), time.Date(2021, 11, 5, 0, 0, 0, 0, time.UTC)},  // either day field matches
+		{"0 12 * * 7", time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC), time.Date(2021, 11, 7, 12, 0, 0, 0, time.UTC)}, // 7 is sunday
+		{"0 0 30 2 *", time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC), time.Time{}},                                   // never
+	}
+
+	for _, tc := range tcs {
+		schedule, err := cron.ParseExpression(tc.expression)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expression, schedule.String())
+		assert.Equal(t, tc.expected, schedule.Next(tc.last), "next fire mismatch for '%s' after %s", tc.expression, tc.last)
+	}
+
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := cron.ParseExpression(expression)
+		assert.Error(t, err, "expected error for '%s'", expression)
+	}
+
+	assert.Panics(t, func() { cron.MustParseExpression("x") })
+}
+
 func TestNextFire(t *testing.T) {
 	tcs := []struct {
 		last     time.Time
@@ -0,0 +1,173 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule determines when a cron should fire
+type Schedule interface {
+	// Next returns the next fire time after the given time
+	Next(time.Time) time.Time
+
+	fmt.Stringer
+}
+
+// Every returns a schedule which fires every interval
+func Every(interval time.Duration) Schedule {
+	return &intervalSchedule{interval: interval}
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s *intervalSchedule) Next(last time.Time) time.Time { return NextFire(last, s.interval) }
+func (s *intervalSchedule) String() string                { return "@every " + s.interval.String() }
+
+// field ranges of cron expressions: minute, hour, day of month, month, day of week
+var fieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// expressionSchedule is a schedule parsed from a standard five field cron expression, e.g. "0 */6 * * *". Each
+// field is stored as a bitset of the values it matches.
+type expressionSchedule struct {
+	expression string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+
+	// whether day of month and day of week were restricted, if both are then a day matching either is a match
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// ParseExpression parses the given five field cron expression (minute, hour, day of month, month, day of week), e.g.
+// "0 */6 * * *". Fields can be *, numbers, ranges like 1-5 and steps like */15 or 0-30/10, or lists of these. Times
+// are evaluated in UTC.
+func ParseExpression(expression string) (Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression '%s' must have 5 fields", expression)
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseField(field, fieldRanges[i][0], fieldRanges[i][1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression '%s'", expression)
+		}
+		bits[i] = b
+	}
+
+	// sunday can be 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &expressionSchedule{
+		expression:         expression,
+		minutes:            bits[0],
+		hours:              bits[1],
+		days:               bits[2],
+		months:             bits[3],
+		weekdays:           bits[4],
+		daysRestricted:     !strings.HasPrefix(fields[2], "*"),
+		weekdaysRestricted: !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// MustParseExpression is like ParseExpression but panics if the expression is invalid
+func MustParseExpression(expression string) Schedule {
+	s, err := ParseExpression(expression)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, errors.Errorf("invalid step in '%s'", part)
+			}
+			rng, step = part[:i], s
+		}
+
+		start, end := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value in '%s'", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value in '%s'", part)
+				}
+			} else if step > 1 {
+				// a step from a single value runs to the end of the range, e.g. 5/15
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, errors.Errorf("'%s' is outside of range %d-%d", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *expressionSchedule) String() string { return s.expression }
+
+// Next returns the first matching minute after the given time, or the zero time if there isn't one in the next
+// five years (e.g. the expression is for February 30th)
+func (s *expressionSchedule) Next(last time.Time) time.Time {
+	t := last.In(time.UTC).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		} else if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		} else if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		} else if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *expressionSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+
+	// like standard cron, if both day fields are restricted then either can match
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
@@ -0,0 +1,74 @@
+package cron
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// hash of cron name to its status
+const statusesKey = "cron_status"
+
+// Status is the state of a cron, as recorded after it last fired
+type Status struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	LastStart    time.Time `json:"last_start"`
+	LastDuration int       `json:"last_duration_ms"`
+	LastError    string    `json:"last_error,omitempty"`
+	NextFire     time.Time `json:"next_fire"`
+}
+
+// GetStatuses returns the statuses of all crons that have fired, across all instances, ordered by name
+func GetStatuses(rc redis.Conn) ([]*Status, error) {
+	values, err := redis.StringMap(rc.Do("hgetall", statusesKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading cron statuses")
+	}
+
+	statuses := make([]*Status, 0, len(values))
+	for _, v := range values {
+		status := &Status{}
+		if err := json.Unmarshal([]byte(v), status); err != nil {
+			return nil, errors.Wrap(err, "error unmarshalling cron status")
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses, nil
+}
+
+func getStatus(rt *runtime.Runtime, name string) (*Status, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	value, err := redis.Bytes(rc.Do("hget", statusesKey, name))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	return status, json.Unmarshal(value, status)
+}
+
+func setStatus(rt *runtime.Runtime, status *Status) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("hset", statusesKey, status.Name, value)
+	return err
+}
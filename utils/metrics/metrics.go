@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultBuckets are the default histogram buckets in seconds, suitable for most request and task durations
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// a metric which can be gathered into a prometheus metric family
+type collector interface {
+	gather() *dto.MetricFamily
+}
+
+var (
+	registry      = make(map[string]collector)
+	registryMutex = &sync.Mutex{}
+)
+
+func register(name string, c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic("metric already registered: " + name)
+	}
+	registry[name] = c
+}
+
+// Gather returns all registered metrics as metric families, sorted by name
+func Gather() []*dto.MetricFamily {
+	registryMutex.Lock()
+	families := make([]*dto.MetricFamily, 0, len(registry))
+	for _, c := range registry {
+		families = append(families, c.gather())
+	}
+	registryMutex.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families
+}
+
+// Write writes the given metric families to the passed in writer in the prometheus text format, skipping any which
+// have no values yet
+func Write(w io.Writer, families []*dto.MetricFamily) error {
+	for _, family := range families {
+		if len(family.Metric) == 0 {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewGaugeFamily is a utility to build a gauge metric family with a single unlabeled value
+func NewGaugeFamily(name, help string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Help:   proto.String(help),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(value)}}},
+	}
+}
+
+// NewCounterFamily is a utility to build a counter metric family with a single unlabeled value
+func NewCounterFamily(name, help string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Help:   proto.String(help),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(value)}}},
+	}
+}
+
+// base for our labeled metrics which keeps track of a value per distinct set of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	keys   []string
+	values map[string][]string
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, values: make(map[string][]string)}
+}
+
+// returns the key for the given label values, recording them if they are new
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic("incorrect number of label values for metric: " + v.name)
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	if _, exists := v.values[key]; !exists {
+		v.keys = append(v.keys, key)
+		v.values[key] = append([]string(nil), labelValues...)
+		sort.Strings(v.keys)
+	}
+	return key
+}
+
+func (v *vec) labelPairs(key string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, len(v.labels))
+	for i, label := range v.labels {
+		pairs[i] = &dto.LabelPair{Name: proto.String(label), Value: proto.String(v.values[key][i])}
+	}
+	return pairs
+}
+
+// Counter is a counter metric with a value for each distinct set of label values
+type Counter struct {
+	vec
+	counts map[string]float64
+}
+
+// NewCounter creates and registers a new counter with the given name and labels
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, labels), counts: make(map[string]float64)}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter for the given label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by the given amount
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.counts[c.key(labelValues)] += value
+}
+
+func (c *Counter) gather() *dto.MetricFamily {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	family := &dto.MetricFamily{
+		Name:   proto.String(c.name),
+		Help:   proto.String(c.help),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: make([]*dto.Metric, 0, len(c.keys)),
+	}
+	for _, key := range c.keys {
+		family.Metric = append(family.Metric, &dto.Metric{
+			Label:   c.labelPairs(key),
+			Counter: &dto.Counter{Value: proto.Float64(c.counts[key])},
+		})
+	}
+	return family
+}
+
+// Histogram is a histogram metric with observations tracked for each distinct set of label values
+type Histogram struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram creates and registers a new histogram with the given name, buckets and labels
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     newVec(name, help, labels),
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+	register(name, h)
+	return h
+}
+
+// Observe records the given value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := h.key(labelValues)
+	counts, exists := h.counts[key]
+	if !exists {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			counts[i]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+// ObserveDuration records the given duration in seconds for the given label values
+func (h *Histogram) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *Histogram) gather() *dto.MetricFamily {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	family := &dto.MetricFamily{
+		Name:   proto.String(h.name),
+		Help:   proto.String(h.help),
+		Type:   dto.MetricType_HISTOGRAM.Enum(),
+		Metric: make([]*dto.Metric, 0, len(h.keys)),
+	}
+	for _, key := range h.keys {
+		buckets := make([]*dto.Bucket, len(h.buckets))
+		for i, upper := range h.buckets {
+			buckets[i] = &dto.Bucket{UpperBound: proto.Float64(upper), CumulativeCount: proto.Uint64(h.counts[key][i])}
+		}
+
+		family.Metric = append(family.Metric, &dto.Metric{
+			Label: h.labelPairs(key),
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(h.totals[key]),
+				SampleSum:   proto.Float64(h.sums[key]),
+				Bucket:      buckets,
+			},
+		})
+	}
+	return family
+}
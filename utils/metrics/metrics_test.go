@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/utils/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	counter := metrics.NewCounter("test_calls_total", "the number of test calls", "status")
+	histogram := metrics.NewHistogram("test_duration_seconds", "the duration of test calls", []float64{0.1, 1}, "type")
+	metrics.NewCounter("test_unused_total", "a counter never incremented")
+
+	assert.Panics(t, func() { metrics.NewCounter("test_calls_total", "a duplicate") })
+	assert.Panics(t, func() { counter.Inc() })
+
+	counter.Inc("success")
+	counter.Inc("success")
+	counter.Add(3, "failure")
+
+	histogram.ObserveDuration(time.Millisecond*50, "foo")
+	histogram.Observe(0.5, "foo")
+	histogram.Observe(2, "foo")
+	histogram.Observe(0.2, "bar")
+
+	b := &bytes.Buffer{}
+	err := metrics.Write(b, append(metrics.Gather(), metrics.NewGaugeFamily("test_pool_idle", "idle connections in the pool", 4)))
+	require.NoError(t, err)
+
+	assert.Equal(t, `# HELP test_calls_total the number of test calls
+# TYPE test_calls_total counter
+test_calls_total{status="failure"} 3
+test_calls_total{status="success"} 2
+# HELP test_duration_seconds the duration of test calls
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{type="bar",le="0.1"} 0
+test_duration_seconds_bucket{type="bar",le="1"} 1
+test_duration_seconds_bucket{type="bar",le="+Inf"} 1
+test_duration_seconds_sum{type="bar"} 0.2
+test_duration_seconds_count{type="bar"} 1
+test_duration_seconds_bucket{type="foo",le="0.1"} 1
+test_duration_seconds_bucket{type="foo",le="1"} 2
+test_duration_seconds_bucket{type="foo",le="+Inf"} 3
+test_duration_seconds_sum{type="foo"} 2.55
+test_duration_seconds_count{type="foo"} 3
+# HELP test_pool_idle idle connections in the pool
+# TYPE test_pool_idle gauge
+test_pool_idle 4
+`, b.String())
+}
@@ -0,0 +1,258 @@
+package smtpd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is called for each message received, with the envelope sender, recipients and raw message data. Returning
+// an error rejects the message.
+type Handler func(from string, to []string, data []byte) error
+
+// Server is a minimal SMTP server for receiving mail from trusted relays. It supports only the commands needed to
+// deliver messages and doesn't support authentication or TLS, so relays should be restricted with AllowClient.
+type Server struct {
+	Addr            string
+	Domain          string
+	MaxMessageBytes int64
+	Timeout         time.Duration
+	Handler         Handler
+
+	// AllowClient if set is called with the address of each new connection, which is refused if it returns false
+	AllowClient func(net.Addr) bool
+
+	// CheckRecipient if set is called for each recipient, which is rejected if it returns an error
+	CheckRecipient func(string) error
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a new server which will listen on the given address
+func NewServer(addr, domain string, handler Handler) *Server {
+	return &Server{
+		Addr:            addr,
+		Domain:          domain,
+		MaxMessageBytes: 10 * 1024 * 1024,
+		Timeout:         time.Minute,
+		Handler:         handler,
+	}
+}
+
+// Start starts listening for connections
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "error listening on %s", s.Addr)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				// listener has been closed
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				logrus.WithError(err).Error("error accepting smtp connection")
+				continue
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	logrus.WithField("address", listener.Addr().String()).Info("smtp server started")
+	return nil
+}
+
+// Stop stops listening and waits for open connections to finish
+func (s *Server) Stop() {
+	s.listener.Close()
+	s.wg.Wait()
+
+	logrus.WithField("address", s.Addr).Info("smtp server stopped")
+}
+
+// ListenAddr returns the address the server is actually listening on
+func (s *Server) ListenAddr() net.Addr {
+	return s.listener.Addr()
+}
+
+// a single SMTP session on a connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+
+	from string
+	to   []string
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	ss := &session{server: s, conn: conn, text: textproto.NewConn(conn)}
+
+	if s.AllowClient != nil && !s.AllowClient(conn.RemoteAddr()) {
+		logrus.WithField("client", conn.RemoteAddr().String()).Warn("refused smtp connection from client not allowed to relay")
+		ss.reply(554, "%s access denied", s.Domain)
+		return
+	}
+
+	ss.reply(220, "%s ESMTP ready", s.Domain)
+
+	for {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				logrus.WithError(err).Debug("error reading smtp command")
+			}
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		if quit := ss.handle(strings.ToUpper(verb), arg); quit {
+			return
+		}
+	}
+}
+
+// handles a single command, returning whether the session should end
+func (ss *session) handle(verb, arg string) bool {
+	switch verb {
+	case "HELO":
+		ss.reset()
+		ss.reply(250, "%s", ss.server.Domain)
+	case "EHLO":
+		ss.reset()
+		ss.reply(250, "%s\n8BITMIME\nSIZE %d", ss.server.Domain, ss.server.MaxMessageBytes)
+	case "MAIL":
+		address, ok := parsePath(arg, "FROM:")
+		if !ok {
+			ss.reply(501, "syntax error in MAIL command")
+			return false
+		}
+		ss.reset()
+		ss.from = address
+		ss.reply(250, "OK")
+	case "RCPT":
+		if ss.from == "" {
+			ss.reply(503, "need MAIL before RCPT")
+			return false
+		}
+		address, ok := parsePath(arg, "TO:")
+		if !ok || address == "" {
+			ss.reply(501, "syntax error in RCPT command")
+			return false
+		}
+		if ss.server.CheckRecipient != nil {
+			if err := ss.server.CheckRecipient(address); err != nil {
+				ss.reply(550, "recipient rejected: %s", err.Error())
+				return false
+			}
+		}
+		ss.to = append(ss.to, address)
+		ss.reply(250, "OK")
+	case "DATA":
+		if len(ss.to) == 0 {
+			ss.reply(503, "need RCPT before DATA")
+			return false
+		}
+		ss.data()
+	case "RSET":
+		ss.reset()
+		ss.reply(250, "OK")
+	case "NOOP":
+		ss.reply(250, "OK")
+	case "QUIT":
+		ss.reply(221, "bye")
+		return true
+	default:
+		ss.reply(502, "command not implemented")
+	}
+	return false
+}
+
+func (ss *session) data() {
+	ss.reply(354, "end data with <CR><LF>.<CR><LF>")
+
+	dot := ss.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(dot, ss.server.MaxMessageBytes+1))
+	if err != nil {
+		ss.reply(451, "error reading message")
+		return
+	}
+
+	// if we hit our limit, drain the rest of the message before rejecting it
+	if int64(len(data)) > ss.server.MaxMessageBytes {
+		io.Copy(io.Discard, dot)
+		ss.reply(552, "message exceeds maximum size")
+		ss.reset()
+		return
+	}
+
+	if err := ss.server.Handler(ss.from, ss.to, data); err != nil {
+		logrus.WithError(err).WithField("from", ss.from).WithField("to", ss.to).Error("error handling smtp message")
+		ss.reply(554, "message rejected: %s", err.Error())
+	} else {
+		ss.reply(250, "OK message accepted")
+	}
+	ss.reset()
+}
+
+func (ss *session) reset() {
+	ss.from = ""
+	ss.to = nil
+}
+
+// writes a reply, with multiple lines of the message written as a multi-line reply
+func (ss *session) reply(code int, format string, args ...interface{}) {
+	lines := strings.Split(fmt.Sprintf(format, args...), "\n")
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		ss.text.PrintfLine("%d%s%s", code, sep, line)
+	}
+}
+
+// parses a path argument like FROM:<bob@example.com> SIZE=123, returning the address
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(path, '>')
+	if end < 0 {
+		return "", false
+	}
+	return path[1:end], true
+}
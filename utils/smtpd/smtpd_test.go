@@ -0,0 +1,97 @@
+package smtpd_test
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/mailroom/utils/smtpd"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	type received struct {
+		from string
+		to   []string
+		data string
+	}
+	messages := make([]received, 0)
+
+	server := smtpd.NewServer("localhost:0", "mr.example.com", func(from string, to []string, data []byte) error {
+		if strings.Contains(string(data), "reject me") {
+			return errors.New("boom")
+		}
+		messages = append(messages, received{from, to, string(data)})
+		return nil
+	})
+	server.MaxMessageBytes = 1024
+
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	addr := server.ListenAddr().String()
+
+	err := smtp.SendMail(addr, nil, "bob@example.com", []string{"ticket+123@mr.example.com", "other@mr.example.com"}, []byte("Subject: Hi\r\n\r\nHello there\r\n..dotted\r\n"))
+	assert.NoError(t, err)
+
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, "bob@example.com", messages[0].from)
+	assert.Equal(t, []string{"ticket+123@mr.example.com", "other@mr.example.com"}, messages[0].to)
+	assert.Equal(t, "Subject: Hi\n\nHello there\n..dotted\n", messages[0].data)
+
+	// handler errors are returned as rejections
+	err = smtp.SendMail(addr, nil, "bob@example.com", []string{"ticket+123@mr.example.com"}, []byte("Subject: Hi\r\n\r\nreject me\r\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "554")
+	assert.Contains(t, err.Error(), "message rejected: boom")
+
+	// as are messages which are too big
+	err = smtp.SendMail(addr, nil, "bob@example.com", []string{"ticket+123@mr.example.com"}, []byte("Subject: Hi\r\n\r\n"+strings.Repeat("x", 2000)+"\r\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "552")
+
+	assert.Equal(t, 1, len(messages))
+}
+
+func TestServerRestrictions(t *testing.T) {
+	messages := 0
+
+	server := smtpd.NewServer("localhost:0", "mr.example.com", func(from string, to []string, data []byte) error {
+		messages++
+		return nil
+	})
+	server.CheckRecipient = func(address string) error {
+		if !strings.HasPrefix(address, "ticket+") {
+			return errors.New("not a ticket address")
+		}
+		return nil
+	}
+
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	addr := server.ListenAddr().String()
+
+	// recipients can be rejected individually
+	err := smtp.SendMail(addr, nil, "bob@example.com", []string{"other@mr.example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "550")
+	assert.Contains(t, err.Error(), "recipient rejected: not a ticket address")
+	assert.Equal(t, 0, messages)
+
+	err = smtp.SendMail(addr, nil, "bob@example.com", []string{"ticket+123@mr.example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, messages)
+
+	// and clients which aren't allowed can't connect at all
+	server.AllowClient = func(net.Addr) bool { return false }
+
+	err = smtp.SendMail(addr, nil, "bob@example.com", []string{"ticket+123@mr.example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "access denied")
+	assert.Equal(t, 1, messages)
+}
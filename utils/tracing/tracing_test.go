@@ -0,0 +1,73 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/tracing"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStart(t *testing.T) {
+	cfg := runtime.NewDefaultConfig()
+
+	stop, err := tracing.Start(cfg)
+	require.NoError(t, err)
+	assert.NoError(t, stop(context.Background()))
+
+	cfg.TracingExporter = "stdout"
+	stop, err = tracing.Start(cfg)
+	require.NoError(t, err)
+	assert.NoError(t, stop(context.Background()))
+}
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	_, err := tracing.Start(runtime.NewDefaultConfig()) // sets our propagator
+	require.NoError(t, err)
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "parent")
+
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/hook?secret=123", nil)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	tracing.EndSpan(parent, errors.New("boom"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	// outgoing request is a child of our parent span and carries its trace context
+	assert.Equal(t, "HTTP POST", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("http.target", "/hook"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", 503))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, received.Get("traceparent"), parent.SpanContext().TraceID().String())
+
+	assert.Equal(t, "parent", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "boom", spans[1].Status().Description)
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/cron"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/crons", web.RequireAuthToken(handleCrons))
+}
+
+// Returns the status of each cron job, including when it last ran, how long it took, its last error and when it
+// will next run
+//
+//   {}
+//
+func handleCrons(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	statuses, err := cron.GetStatuses(rc)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error reading cron statuses")
+	}
+
+	return map[string]interface{}{"crons": statuses}, http.StatusOK, nil
+}
@@ -0,0 +1,26 @@
+package admin_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrons(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	_, err := rc.Do("hset", "cron_status",
+		"fire_schedules", `{"name": "fire_schedules", "schedule": "@every 1m0s", "last_start": "2021-11-01T12:00:01Z", "last_duration_ms": 123, "next_fire": "2021-11-01T12:01:01Z"}`,
+		"analytics:instance1", `{"name": "analytics:instance1", "schedule": "0 */6 * * *", "last_start": "2021-11-01T12:00:00Z", "last_duration_ms": 5, "last_error": "boom", "next_fire": "2021-11-01T18:00:00Z"}`,
+	)
+	require.NoError(t, err)
+
+	web.RunWebTests(t, ctx, rt, "testdata/crons.json", nil)
+}
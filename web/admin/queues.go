@@ -0,0 +1,327 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues", web.RequireAuthToken(handleQueues))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/peek", web.RequireAuthToken(handlePeek))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/drain", web.RequireAuthToken(handleDrain))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/prioritize", web.RequireAuthToken(handlePrioritize))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/limit", web.RequireAuthToken(handleLimit))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/dead", web.RequireAuthToken(handleDead))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/dead/replay", web.RequireAuthToken(handleDeadReplay))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/admin/queues/dead/purge", web.RequireAuthToken(handleDeadPurge))
+}
+
+var queueNames = []string{queue.BatchQueue, queue.HandlerQueue}
+
+type queueSummary struct {
+	Size        int               `json:"size"`
+	DelayedSize int               `json:"delayed_size"`
+	DeadSize    int               `json:"dead_size"`
+	Orgs        []*queue.OrgQueue `json:"orgs"`
+}
+
+// Returns the state of each task queue, including the number of queued tasks and active workers for each org, and
+// the number of tasks which are delayed or dead
+//
+//   {}
+//
+func handleQueues(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	response := make(map[string]*queueSummary, len(queueNames))
+
+	for _, name := range queueNames {
+		orgs, err := queue.OrgQueues(rc, name)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error reading org queues for %s", name)
+		}
+		delayedSize, err := queue.DelayedSize(rc, name)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error reading delayed size for %s", name)
+		}
+		deadSize, err := queue.DeadLetterSize(rc, name)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error reading dead letter size for %s", name)
+		}
+
+		summary := &queueSummary{DelayedSize: delayedSize, DeadSize: deadSize, Orgs: orgs}
+		for _, o := range orgs {
+			summary.Size += o.Size
+		}
+		response[name] = summary
+	}
+
+	return response, http.StatusOK, nil
+}
+
+// Returns the queued tasks for an org in the order they will be handled
+//
+//   {
+//     "queue": "batch",
+//     "org_id": 1,
+//     "offset": 0,
+//     "count": 10
+//   }
+//
+type peekRequest struct {
+	Queue  string       `json:"queue"  validate:"required,oneof=batch handler"`
+	OrgID  models.OrgID `json:"org_id" validate:"required"`
+	Offset int          `json:"offset" validate:"min=0"`
+	Count  int          `json:"count"  validate:"omitempty,min=1,max=1000"`
+}
+
+func handlePeek(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &peekRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if request.Count == 0 {
+		request.Count = 10
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	tasks, err := queue.PeekTasks(rc, request.Queue, int(request.OrgID), request.Offset, request.Count)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error peeking tasks")
+	}
+
+	return map[string]interface{}{"tasks": tasks}, http.StatusOK, nil
+}
+
+// Removes all queued tasks for an org
+//
+//   {
+//     "queue": "batch",
+//     "org_id": 1
+//   }
+//
+type drainRequest struct {
+	Queue string       `json:"queue"  validate:"required,oneof=batch handler"`
+	OrgID models.OrgID `json:"org_id" validate:"required"`
+}
+
+func handleDrain(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &drainRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	drained, err := queue.DrainOrgQueue(rc, request.Queue, int(request.OrgID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error draining queue")
+	}
+
+	return map[string]interface{}{"drained": drained}, http.StatusOK, nil
+}
+
+// Changes the priority of all queued tasks for an org, e.g. to let other tasks for that org jump ahead of them
+//
+//   {
+//     "queue": "batch",
+//     "org_id": 1,
+//     "priority": "low"
+//   }
+//
+type prioritizeRequest struct {
+	Queue    string       `json:"queue"    validate:"required,oneof=batch handler"`
+	OrgID    models.OrgID `json:"org_id"   validate:"required"`
+	Priority string       `json:"priority" validate:"required,oneof=high default low"`
+}
+
+var priorities = map[string]queue.Priority{
+	"high":    queue.HighPriority,
+	"default": queue.DefaultPriority,
+	"low":     queue.LowPriority,
+}
+
+func handlePrioritize(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &prioritizeRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	updated, err := queue.PrioritizeOrgQueue(rc, request.Queue, int(request.OrgID), priorities[request.Priority])
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error prioritizing queue")
+	}
+
+	return map[string]interface{}{"updated": updated}, http.StatusOK, nil
+}
+
+// Sets the maximum number of workers that can work on an org's tasks at once (0 for no limit), and the org's share
+// of workers relative to other orgs (1 by default)
+//
+//   {
+//     "queue": "batch",
+//     "org_id": 1,
+//     "max_workers": 2,
+//     "weight": 0.5
+//   }
+//
+type limitRequest struct {
+	Queue      string       `json:"queue"       validate:"required,oneof=batch handler"`
+	OrgID      models.OrgID `json:"org_id"      validate:"required"`
+	MaxWorkers int          `json:"max_workers" validate:"min=0"`
+	Weight     *float64     `json:"weight"      validate:"omitempty,gt=0"`
+}
+
+func handleLimit(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &limitRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	limits := &queue.OrgLimits{MaxWorkers: request.MaxWorkers, Weight: 1}
+	if request.Weight != nil {
+		limits.Weight = *request.Weight
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := queue.SetOrgLimits(rc, request.Queue, int(request.OrgID), limits); err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error setting org limits")
+	}
+
+	return limits, http.StatusOK, nil
+}
+
+// Returns the tasks in a queue's dead letter queue, oldest first
+//
+//   {
+//     "queue": "batch",
+//     "offset": 0,
+//     "count": 10
+//   }
+//
+type deadRequest struct {
+	Queue  string `json:"queue"  validate:"required,oneof=batch handler"`
+	Offset int    `json:"offset" validate:"min=0"`
+	Count  int    `json:"count"  validate:"omitempty,min=1,max=1000"`
+}
+
+func handleDead(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &deadRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if request.Count == 0 {
+		request.Count = 10
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	tasks, err := queue.ListDeadTasks(rc, request.Queue, request.Offset, request.Count)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error listing dead tasks")
+	}
+
+	return map[string]interface{}{"tasks": tasks}, http.StatusOK, nil
+}
+
+// Puts the given dead tasks back on their queue
+//
+//   {
+//     "queue": "batch",
+//     "ids": ["5f5d8e0a-8c3b-4f63-8a42-ef5dc4b9a2f4"]
+//   }
+//
+type deadReplayRequest struct {
+	Queue string   `json:"queue" validate:"required,oneof=batch handler"`
+	IDs   []string `json:"ids"   validate:"required"`
+}
+
+func handleDeadReplay(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &deadReplayRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	replayed := make([]string, 0, len(request.IDs))
+	for _, id := range request.IDs {
+		found, err := queue.ReplayDeadTask(rc, request.Queue, id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error replaying dead task %s", id)
+		}
+		if found {
+			replayed = append(replayed, id)
+		}
+	}
+
+	return map[string]interface{}{"replayed": replayed}, http.StatusOK, nil
+}
+
+// Deletes the given dead tasks, or if before is specified, all dead tasks which failed before that time
+//
+//   {
+//     "queue": "batch",
+//     "ids": ["5f5d8e0a-8c3b-4f63-8a42-ef5dc4b9a2f4"],
+//     "before": "2021-11-01T12:00:00Z"
+//   }
+//
+type deadPurgeRequest struct {
+	Queue  string     `json:"queue" validate:"required,oneof=batch handler"`
+	IDs    []string   `json:"ids"`
+	Before *time.Time `json:"before"`
+}
+
+func handleDeadPurge(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &deadPurgeRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if len(request.IDs) == 0 && request.Before == nil {
+		return errors.New("must specify ids or before"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	purged := 0
+	for _, id := range request.IDs {
+		found, err := queue.PurgeDeadTask(rc, request.Queue, id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error purging dead task %s", id)
+		}
+		if found {
+			purged++
+		}
+	}
+
+	if request.Before != nil {
+		count, err := queue.PurgeDeadTasks(rc, request.Queue, *request.Before)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error purging dead tasks")
+		}
+		purged += count
+	}
+
+	return map[string]interface{}{"purged": purged}, http.StatusOK, nil
+}
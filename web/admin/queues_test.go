@@ -0,0 +1,47 @@
+package admin_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueues(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+	defer uuids.SetGenerator(uuids.DefaultGenerator)
+
+	dates.SetNowSource(dates.NewSequentialNowSource(time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)))
+	uuids.SetGenerator(uuids.NewSeededGenerator(1234))
+
+	require.NoError(t, queue.AddTask(ctx, rc, queue.BatchQueue, queue.SendBroadcast, int(testdata.Org1.ID), map[string]int{"broadcast_id": 1}, queue.DefaultPriority))
+	require.NoError(t, queue.AddTask(ctx, rc, queue.BatchQueue, queue.SendBroadcast, int(testdata.Org1.ID), map[string]int{"broadcast_id": 2}, queue.DefaultPriority))
+	require.NoError(t, queue.AddTask(ctx, rc, queue.BatchQueue, queue.StartFlow, int(testdata.Org2.ID), map[string]int{"start_id": 3}, queue.DefaultPriority))
+	require.NoError(t, queue.AddTask(ctx, rc, queue.HandlerQueue, queue.HandleContactEvent, int(testdata.Org1.ID), map[string]int{"contact_id": 10000}, queue.DefaultPriority))
+
+	failed := &queue.Task{
+		Type:       queue.StartFlowBatch,
+		OrgID:      int(testdata.Org1.ID),
+		Task:       []byte(`{"start_id": 4}`),
+		QueuedOn:   time.Date(2021, 10, 31, 9, 0, 0, 0, time.UTC),
+		ErrorCount: 4,
+	}
+	require.NoError(t, queue.DeadLetterTask(rc, queue.BatchQueue, failed, errors.New("boom")))
+
+	require.NoError(t, queue.AddDelayedTask(ctx, rc, queue.BatchQueue, queue.StartFlow, int(testdata.Org2.ID), map[string]int{"start_id": 5}, time.Date(2021, 11, 2, 9, 0, 0, 0, time.UTC)))
+
+	web.RunWebTests(t, ctx, rt, "testdata/queues.json", nil)
+}
@@ -0,0 +1,213 @@
+package broadcast
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/status", web.RequireAuthToken(handleStatus))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/cancel", web.RequireAuthToken(handleCancel))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/pause", web.RequireAuthToken(handlePause))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/resume", web.RequireAuthToken(handleResume))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/compare", web.RequireAuthToken(handleCompare))
+}
+
+// Gets the status of a broadcast and, once its batches have been queued, its progress. Broadcasts being delivered at a
+// local time include the timezones which haven't yet been delivered to, and broadcasts which have been paused include
+// their control flag.
+//
+//   {
+//     "org_id": 1,
+//     "broadcast_id": 123
+//   }
+//
+//   {
+//     "status": "Q",
+//     "control": "pause",
+//     "progress": {
+//       "queued": 5000,
+//       "handled": 1200,
+//       "throttled_until": "2021-11-10T15:10:49.123456Z",
+//       "pending_timezones": {
+//         "Africa/Kigali": {"contacts": 3000, "deliver_on": "2021-11-11T09:00:00+02:00"}
+//       }
+//     }
+//   }
+//
+type statusRequest struct {
+	OrgID       models.OrgID       `json:"org_id"        validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id"  validate:"required"`
+}
+
+type statusResponse struct {
+	Status   models.BroadcastStatus `json:"status"`
+	Control  models.SendControl     `json:"control,omitempty"`
+	Progress *models.SendProgress   `json:"progress"`
+}
+
+func handleStatus(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &statusRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetBroadcastStatus(ctx, rt.DB, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such broadcast: %d", request.BroadcastID), http.StatusNotFound, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	control, err := models.GetSendControl(rc, models.BroadcastControlKey(request.BroadcastID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	progress, err := models.GetSendProgress(rc, models.BroadcastProgressKey(request.BroadcastID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &statusResponse{Status: status, Control: control, Progress: progress}, http.StatusOK, nil
+}
+
+// Cancels, pauses or resumes an in-progress broadcast. Batches of the broadcast (including the parts of it waiting to be
+// delivered at a local time) check for this before doing any work, so cancelled batches are dropped and paused batches
+// are parked until the broadcast is resumed. Cancelling also sets the status of the broadcast to F (failed).
+//
+//   {
+//     "org_id": 1,
+//     "broadcast_id": 123
+//   }
+//
+//   {
+//     "status": "F",
+//     "control": "cancel"
+//   }
+//
+type controlRequest struct {
+	OrgID       models.OrgID       `json:"org_id"        validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id"  validate:"required"`
+}
+
+type controlResponse struct {
+	Status  models.BroadcastStatus `json:"status"`
+	Control models.SendControl     `json:"control,omitempty"`
+}
+
+func handleCancel(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlBroadcast(ctx, rt, r, "cancel", models.SendControlCancel)
+}
+
+func handlePause(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlBroadcast(ctx, rt, r, "pause", models.SendControlPause)
+}
+
+func handleResume(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlBroadcast(ctx, rt, r, "resume", models.SendControlNone)
+}
+
+func controlBroadcast(ctx context.Context, rt *runtime.Runtime, r *http.Request, action string, control models.SendControl) (interface{}, int, error) {
+	request := &controlRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetBroadcastStatus(ctx, rt.DB, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such broadcast: %d", request.BroadcastID), http.StatusNotFound, nil
+	}
+	if status != models.BroadcastStatusPending && status != models.BroadcastStatusQueued {
+		return errors.Errorf("can't %s broadcast with status %s", action, status), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	// set our flag first so that batches stop as soon as possible, and resuming re-queues the batches which were
+	// parked while paused
+	if control == models.SendControlNone {
+		if _, err := msgio.ResumeSend(rc, models.BroadcastControlKey(request.BroadcastID)); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	} else if err := models.SetSendControl(rc, models.BroadcastControlKey(request.BroadcastID), control); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if control == models.SendControlCancel {
+		if err := models.MarkBroadcastCancelled(ctx, rt.DB, request.BroadcastID); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		status = models.BroadcastStatusFailed
+	}
+
+	return &controlResponse{Status: status, Control: control}, http.StatusOK, nil
+}
+
+// Compares the variants of a broadcast which was split into variants, including its holdout group. Response rates are
+// based on contacts sending a message after the broadcast was created. Comparison is best effort: variant assignments
+// are only kept in redis for 90 days so older broadcasts have no variants to compare, and large broadcasts are compared
+// using a sample of 50,000 contacts.
+//
+//   {
+//     "org_id": 1,
+//     "broadcast_id": 123
+//   }
+//
+//   {
+//     "variants": [
+//       {"name": "A", "contacts": 450, "responded": 210, "response_rate": 0.4666666666666667},
+//       {"name": "B", "contacts": 450, "responded": 180, "response_rate": 0.4},
+//       {"name": "holdout", "contacts": 100, "responded": 12, "response_rate": 0.12}
+//     ]
+//   }
+//
+type compareRequest struct {
+	OrgID       models.OrgID       `json:"org_id"        validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id"  validate:"required"`
+}
+
+type compareResponse struct {
+	Variants []*models.VariantStats `json:"variants"`
+}
+
+func handleCompare(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &compareRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetBroadcastStatus(ctx, rt.DB, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such broadcast: %d", request.BroadcastID), http.StatusNotFound, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	variants, err := models.GetBroadcastVariantStats(ctx, rt.DB, rc, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &compareResponse{Variants: variants}, http.StatusOK, nil
+}
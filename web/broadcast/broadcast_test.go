@@ -0,0 +1,73 @@
+package broadcast_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcast1 := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, []*testdata.Contact{testdata.Cathy}, nil)
+	bcast2 := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, []*testdata.Contact{testdata.Bob}, nil)
+
+	// second broadcast has had batches queued, the last of which is being throttled (web tests run at 2018-07-06 12:30)
+	require.NoError(t, models.RecordBatchQueued(rc, models.BroadcastProgressKey(bcast2), 100, time.Date(2018, 7, 6, 12, 30, 0, 0, time.UTC)))
+	require.NoError(t, models.RecordBatchQueued(rc, models.BroadcastProgressKey(bcast2), 50, time.Date(2018, 7, 6, 12, 30, 10, 0, time.UTC)))
+	require.NoError(t, models.RecordBatchHandled(rc, models.BroadcastProgressKey(bcast2), 100))
+
+	web.RunWebTests(t, ctx, rt, "testdata/status.json", map[string]string{
+		"bcast1_id": fmt.Sprintf("%d", bcast1),
+		"bcast2_id": fmt.Sprintf("%d", bcast2),
+	})
+}
+
+func TestControl(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcast1 := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, []*testdata.Contact{testdata.Cathy}, nil)
+	bcast2 := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, []*testdata.Contact{testdata.Bob}, nil)
+	db.MustExec(`UPDATE msgs_broadcast SET status = 'S' WHERE id = $1`, bcast2)
+
+	web.RunWebTests(t, ctx, rt, "testdata/control.json", map[string]string{
+		"bcast1_id": fmt.Sprintf("%d", bcast1),
+		"bcast2_id": fmt.Sprintf("%d", bcast2),
+	})
+}
+
+func TestCompare(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcast1 := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hello"}, models.NilScheduleID, nil, nil)
+	db.MustExec(`UPDATE msgs_broadcast SET created_on = NOW() - INTERVAL '1 hour' WHERE id = $1`, bcast1)
+
+	// broadcast was split with Cathy getting variant A, Bob variant B and George being in the holdout group
+	_, err := rc.Do("HSET", models.BroadcastVariantsKey(bcast1), testdata.Cathy.ID, "A", testdata.Bob.ID, "B", testdata.George.ID, models.VariantHoldout)
+	require.NoError(t, err)
+
+	// and Cathy has since replied
+	testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "hi", models.MsgStatusHandled)
+
+	web.RunWebTests(t, ctx, rt, "testdata/compare.json", map[string]string{
+		"bcast1_id": fmt.Sprintf("%d", bcast1),
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/web"
@@ -18,6 +19,11 @@ import (
 
 func init() {
 	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/preview_start", web.RequireAuthToken(handlePreviewStart))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/start/status", web.RequireAuthToken(handleStartStatus))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/start/cancel", web.RequireAuthToken(handleStartCancel))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/start/pause", web.RequireAuthToken(handleStartPause))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/start/resume", web.RequireAuthToken(handleStartResume))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow/start/compare", web.RequireAuthToken(handleStartCompare))
 }
 
 // Generates a preview of which contacts will be started in the given flow.
@@ -122,3 +128,209 @@ func handlePreviewStart(ctx context.Context, rt *runtime.Runtime, r *http.Reques
 		Metadata:  inspection,
 	}, http.StatusOK, nil
 }
+
+// Gets the status of a flow start and, once its batches have been queued, its progress. Starts which have been paused
+// include their control flag.
+//
+//   {
+//     "org_id": 1,
+//     "start_id": 123
+//   }
+//
+//   {
+//     "status": "S",
+//     "control": "pause",
+//     "progress": {
+//       "queued": 5000,
+//       "handled": 1200,
+//       "throttled_until": "2021-11-10T15:10:49.123456Z"
+//     }
+//   }
+//
+type startStatusRequest struct {
+	OrgID   models.OrgID   `json:"org_id"    validate:"required"`
+	StartID models.StartID `json:"start_id"  validate:"required"`
+}
+
+type startStatusResponse struct {
+	Status   models.StartStatus   `json:"status"`
+	Control  models.SendControl   `json:"control,omitempty"`
+	Progress *models.SendProgress `json:"progress"`
+}
+
+func handleStartStatus(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &startStatusRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetStartStatus(ctx, rt.DB, request.OrgID, request.StartID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such flow start: %d", request.StartID), http.StatusNotFound, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	control, err := models.GetSendControl(rc, models.StartControlKey(request.StartID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	progress, err := models.GetSendProgress(rc, models.StartProgressKey(request.StartID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &startStatusResponse{Status: status, Control: control, Progress: progress}, http.StatusOK, nil
+}
+
+// Cancels, pauses or resumes an in-progress flow start. Batches of the start which haven't yet been handled check for
+// this before doing any work, so cancelled batches are dropped and paused batches are parked until the start is resumed.
+// Cancelling also sets the status of the start to I (interrupted).
+//
+//   {
+//     "org_id": 1,
+//     "start_id": 123
+//   }
+//
+//   {
+//     "status": "I",
+//     "control": "cancel"
+//   }
+//
+type startControlRequest struct {
+	OrgID   models.OrgID   `json:"org_id"    validate:"required"`
+	StartID models.StartID `json:"start_id"  validate:"required"`
+}
+
+type startControlResponse struct {
+	Status  models.StartStatus `json:"status"`
+	Control models.SendControl `json:"control,omitempty"`
+}
+
+func handleStartCancel(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlStart(ctx, rt, r, "cancel", models.SendControlCancel)
+}
+
+func handleStartPause(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlStart(ctx, rt, r, "pause", models.SendControlPause)
+}
+
+func handleStartResume(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	return controlStart(ctx, rt, r, "resume", models.SendControlNone)
+}
+
+func controlStart(ctx context.Context, rt *runtime.Runtime, r *http.Request, action string, control models.SendControl) (interface{}, int, error) {
+	request := &startControlRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetStartStatus(ctx, rt.DB, request.OrgID, request.StartID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such flow start: %d", request.StartID), http.StatusNotFound, nil
+	}
+	if status != models.StartStatusPending && status != models.StartStatusStarting {
+		return errors.Errorf("can't %s flow start with status %s", action, status), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	// set our flag first so that batches stop as soon as possible, and resuming re-queues the batches which were
+	// parked while paused
+	if control == models.SendControlNone {
+		if _, err := msgio.ResumeSend(rc, models.StartControlKey(request.StartID)); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	} else if err := models.SetSendControl(rc, models.StartControlKey(request.StartID), control); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if control == models.SendControlCancel {
+		if err := models.MarkStartInterrupted(ctx, rt.DB, request.StartID); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		status = models.StartStatusInterrupted
+	}
+
+	return &startControlResponse{Status: status, Control: control}, http.StatusOK, nil
+}
+
+// Compares the variants of a flow start which was split into variants, including its holdout group. Response rates are
+// based on contacts sending a message after the start was created, and run statistics are of the runs created by the
+// start for each variant's contacts. Comparison is best effort: variant assignments are only kept in redis for 90 days
+// so older starts have no variants to compare, and large starts are compared using a sample of 50,000 contacts.
+//
+//   {
+//     "org_id": 1,
+//     "start_id": 123
+//   }
+//
+//   {
+//     "variants": [
+//       {
+//         "name": "A",
+//         "contacts": 450,
+//         "responded": 210,
+//         "response_rate": 0.4666666666666667,
+//         "runs": {
+//           "total": 450,
+//           "active": 100,
+//           "completed": 300,
+//           "interrupted": 0,
+//           "expired": 50,
+//           "failed": 0,
+//           "results": {"color": {"Red": 120, "Blue": 90}}
+//         }
+//       },
+//       {
+//         "name": "holdout",
+//         "contacts": 100,
+//         "responded": 12,
+//         "response_rate": 0.12,
+//         "runs": {"total": 0, "active": 0, "completed": 0, "interrupted": 0, "expired": 0, "failed": 0, "results": {}}
+//       }
+//     ]
+//   }
+//
+type startCompareRequest struct {
+	OrgID   models.OrgID   `json:"org_id"    validate:"required"`
+	StartID models.StartID `json:"start_id"  validate:"required"`
+}
+
+type startCompareResponse struct {
+	Variants []*models.VariantStats `json:"variants"`
+}
+
+func handleStartCompare(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &startCompareRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	status, err := models.GetStartStatus(ctx, rt.DB, request.OrgID, request.StartID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status == "" {
+		return errors.Errorf("no such flow start: %d", request.StartID), http.StatusNotFound, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	variants, err := models.GetStartVariantStats(ctx, rt.DB, rc, request.OrgID, request.StartID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &startCompareResponse{Variants: variants}, http.StatusOK, nil
+}
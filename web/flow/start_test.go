@@ -1,11 +1,16 @@
 package flow_test
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/nyaruka/mailroom/web"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestPreviewStart(t *testing.T) {
@@ -23,3 +28,64 @@ func TestPreviewStart(t *testing.T) {
 
 	web.RunWebTests(t, ctx, rt, "testdata/preview_start.json", nil)
 }
+
+func TestStartStatus(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	start1 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Cathy})
+	start2 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Bob})
+
+	// second start has had all its batches queued and handled (web tests run at 2018-07-06 12:30)
+	require.NoError(t, models.RecordBatchQueued(rc, models.StartProgressKey(start2), 100, time.Date(2018, 7, 6, 12, 0, 0, 0, time.UTC)))
+	require.NoError(t, models.RecordBatchHandled(rc, models.StartProgressKey(start2), 100))
+
+	web.RunWebTests(t, ctx, rt, "testdata/start_status.json", map[string]string{
+		"start1_id": fmt.Sprintf("%d", start1),
+		"start2_id": fmt.Sprintf("%d", start2),
+	})
+}
+
+func TestStartControl(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	start1 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Cathy})
+	start2 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Bob})
+	db.MustExec(`UPDATE flows_flowstart SET status = 'C' WHERE id = $1`, start2)
+
+	web.RunWebTests(t, ctx, rt, "testdata/start_control.json", map[string]string{
+		"start1_id": fmt.Sprintf("%d", start1),
+		"start2_id": fmt.Sprintf("%d", start2),
+	})
+}
+
+func TestStartCompare(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	start1 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, nil)
+	start2 := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, nil)
+
+	// second start was split with Cathy getting variant A and Bob being in the holdout group
+	_, err := rc.Do("HSET", models.StartVariantsKey(start2), testdata.Cathy.ID, "A", testdata.Bob.ID, models.VariantHoldout)
+	require.NoError(t, err)
+
+	// Cathy was started in the flow of variant A, which has a completed run counted
+	runID := testdata.InsertFlowRun(db, testdata.Org1, models.SessionID(0), testdata.Cathy, testdata.Favorites, models.RunStatusCompleted)
+	db.MustExec(`UPDATE flows_flowrun SET start_id = $2 WHERE id = $1`, runID, start2)
+	db.MustExec(`INSERT INTO flows_flowruncount(is_squashed, exit_type, count, flow_id) VALUES(FALSE, 'C', 1, $1)`, testdata.Favorites.ID)
+	db.MustExec(`INSERT INTO flows_flowcategorycount(is_squashed, node_uuid, result_key, result_name, category_name, count, flow_id) VALUES(FALSE, '10c9c241-777f-4010-a841-6e87abed8520', 'color', 'Color', 'Red', 1, $1)`, testdata.Favorites.ID)
+
+	web.RunWebTests(t, ctx, rt, "testdata/start_compare.json", map[string]string{
+		"start1_id": fmt.Sprintf("%d", start1),
+		"start2_id": fmt.Sprintf("%d", start2),
+	})
+}
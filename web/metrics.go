@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/metrics"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Exposes process level metrics for this mailroom instance in the prometheus text format, e.g. task and event
+// handling times, webhook outcomes, cron durations and pool stats. If an auth token is configured then requests must
+// include it as an authorization header.
+func handleMetrics(ctx context.Context, rt *runtime.Runtime, r *http.Request, w http.ResponseWriter) error {
+	if rt.Config.AuthToken != "" && fmt.Sprintf("Token %s", rt.Config.AuthToken) != r.Header.Get("authorization") {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid or missing authorization header"}`))
+		return nil
+	}
+
+	families := metrics.Gather()
+
+	poolFamilies, err := gatherPoolMetrics(rt)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	w.WriteHeader(http.StatusOK)
+
+	return metrics.Write(w, append(families, poolFamilies...))
+}
+
+// gathers the current state of our DB and redis pools, and the sizes of our queues
+func gatherPoolMetrics(rt *runtime.Runtime) ([]*dto.MetricFamily, error) {
+	dbStats := rt.DB.Stats()
+	redisStats := rt.RP.Stats()
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	batchSize, err := queue.Size(rc, queue.BatchQueue)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calculating batch queue size")
+	}
+	handlerSize, err := queue.Size(rc, queue.HandlerQueue)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calculating handler queue size")
+	}
+
+	return []*dto.MetricFamily{
+		metrics.NewGaugeFamily("mailroom_db_open_connections", "the number of open connections in the DB pool", float64(dbStats.OpenConnections)),
+		metrics.NewGaugeFamily("mailroom_db_busy_connections", "the number of DB connections in use", float64(dbStats.InUse)),
+		metrics.NewGaugeFamily("mailroom_db_idle_connections", "the number of idle DB connections", float64(dbStats.Idle)),
+		metrics.NewCounterFamily("mailroom_db_wait_total", "the number of times we've waited for a DB connection", float64(dbStats.WaitCount)),
+		metrics.NewCounterFamily("mailroom_db_wait_seconds_total", "the total time spent waiting for DB connections", dbStats.WaitDuration.Seconds()),
+		metrics.NewGaugeFamily("mailroom_redis_active_connections", "the number of active connections in the redis pool", float64(redisStats.ActiveCount)),
+		metrics.NewGaugeFamily("mailroom_redis_idle_connections", "the number of idle redis connections", float64(redisStats.IdleCount)),
+		metrics.NewCounterFamily("mailroom_redis_wait_total", "the number of times we've waited for a redis connection", float64(redisStats.WaitCount)),
+		metrics.NewCounterFamily("mailroom_redis_wait_seconds_total", "the total time spent waiting for redis connections", redisStats.WaitDuration.Seconds()),
+		metrics.NewGaugeFamily("mailroom_batch_queue_size", "the number of tasks in the batch queue", float64(batchSize)),
+		metrics.NewGaugeFamily("mailroom_handler_queue_size", "the number of tasks in the handler queue", float64(handlerSize)),
+	}, nil
+}
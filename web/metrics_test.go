@@ -0,0 +1,56 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	defer func() { rt.Config.AuthToken = "" }()
+
+	wg := &sync.WaitGroup{}
+	server := NewServer(ctx, rt, wg)
+	server.Start()
+
+	// wait for the server to start
+	time.Sleep(time.Second)
+	defer server.Stop()
+
+	fetch := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8090/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := fetch("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "# TYPE mailroom_db_busy_connections gauge")
+	assert.Contains(t, body, "mailroom_redis_wait_total ")
+	assert.Contains(t, body, "mailroom_handler_queue_size 0")
+
+	// if we have an auth token, it must be provided
+	rt.Config.AuthToken = "sesame"
+
+	status, body = fetch("")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, `{"error": "invalid or missing authorization header"}`, body)
+
+	status, _ = fetch("sesame")
+	assert.Equal(t, http.StatusOK, status)
+}
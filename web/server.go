@@ -10,11 +10,15 @@ import (
 
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/tracing"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -74,6 +78,7 @@ func NewServer(ctx context.Context, rt *runtime.Runtime, wg *sync.WaitGroup) *Se
 	router.MethodNotAllowed(s.WrapJSONHandler(handle405))
 	router.Get("/", s.WrapJSONHandler(handleIndex))
 	router.Get("/mr/", s.WrapJSONHandler(handleIndex))
+	router.Get("/metrics", s.WrapHandler(handleMetrics))
 
 	// add any registered json routes
 	for _, route := range jsonRoutes {
@@ -99,9 +104,13 @@ func NewServer(ctx context.Context, rt *runtime.Runtime, wg *sync.WaitGroup) *Se
 // WrapJSONHandler wraps a simple JSONHandler
 func (s *Server) WrapJSONHandler(handler JSONHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := startRequestSpan(r)
+		defer span.End()
+
 		w.Header().Set("Content-type", "application/json")
 
-		value, status, err := handler(r.Context(), s.rt, r)
+		value, status, err := handler(ctx, s.rt, r)
+		endRequestSpan(span, status, err)
 
 		// handler errored (a hard error)
 		if err != nil {
@@ -137,10 +146,14 @@ func (s *Server) WrapJSONHandler(handler JSONHandler) http.HandlerFunc {
 // WrapHandler wraps a simple Handler, taking care of passing down server and handling errors
 func (s *Server) WrapHandler(handler Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := handler(r.Context(), s.rt, r, w)
+		ctx, span := startRequestSpan(r)
+		defer span.End()
+
+		err := handler(ctx, s.rt, r.WithContext(ctx), w)
 		if err == nil {
 			return
 		}
+		endRequestSpan(span, http.StatusInternalServerError, err)
 
 		logrus.WithError(err).WithField("http_request", r).Error("error handling request")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -149,6 +162,31 @@ func (s *Server) WrapHandler(handler Handler) http.HandlerFunc {
 	}
 }
 
+// starts a span for the given request, continuing the trace of the caller if it sent one
+func startRequestSpan(r *http.Request) (context.Context, trace.Span) {
+	name := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		name = rctx.RoutePattern()
+	}
+
+	return tracing.Tracer().Start(tracing.ExtractHTTP(r), r.Method+" "+name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", r.Method), attribute.String("http.target", r.URL.Path)),
+	)
+}
+
+// records the outcome of a request on its span
+func endRequestSpan(span trace.Span, status int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		status = http.StatusInternalServerError
+	} else if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.SetAttributes(attribute.Int("http.status_code", status))
+}
+
 // Start starts our web server, listening for new requests
 func (s *Server) Start() {
 	// start serving HTTP
@@ -6,6 +6,7 @@ import (
 
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/web"
 	"github.com/pkg/errors"
@@ -37,6 +38,11 @@ func handleAddNote(ctx context.Context, rt *runtime.Runtime, r *http.Request) (i
 		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
 	}
 
+	// if we've been given a filter, tickets are selected and updated by a batch task
+	if request.Filter != nil {
+		return queueBulkTask(ctx, rt, &request.bulkTicketRequest, &tickets.BulkTicketTask{Action: tickets.BulkTicketActionAddNote, Note: request.Note})
+	}
+
 	// grab our org assets
 	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
 	if err != nil {
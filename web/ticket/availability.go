@@ -0,0 +1,47 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/ticket/availability", web.RequireAuthToken(handleAvailability))
+}
+
+type availabilityRequest struct {
+	OrgID     models.OrgID  `json:"org_id"    validate:"required"`
+	UserID    models.UserID `json:"user_id"   validate:"required"`
+	Available bool          `json:"available"`
+}
+
+// Marks a user as available or unavailable for automatic ticket assignment
+//
+//   {
+//     "org_id": 123,
+//     "user_id": 234,
+//     "available": false
+//   }
+//
+func handleAvailability(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &availabilityRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := models.SetUserAvailable(rc, request.OrgID, request.UserID, request.Available); err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error setting user availability")
+	}
+
+	return map[string]interface{}{"user_id": request.UserID, "available": request.Available}, http.StatusOK, nil
+}
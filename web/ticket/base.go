@@ -1,16 +1,27 @@
 package ticket
 
 import (
+	"context"
+	"net/http"
 	"sort"
 
+	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
 )
 
+// bulkTicketRequest is the common base of requests which operate on multiple tickets. Tickets are given either as
+// explicit ids or as a filter, e.g. {"filter": {"status": "O", "topic_id": 12}}, in which case the operation is
+// performed by a batch task whose progress can be fetched from /mr/ticket/bulk_status.
 type bulkTicketRequest struct {
-	OrgID     models.OrgID      `json:"org_id"      validate:"required"`
-	UserID    models.UserID     `json:"user_id"      validate:"required"`
-	TicketIDs []models.TicketID `json:"ticket_ids"`
-	Force     bool              `json:"force"`
+	OrgID     models.OrgID         `json:"org_id"      validate:"required"`
+	UserID    models.UserID        `json:"user_id"      validate:"required"`
+	TicketIDs []models.TicketID    `json:"ticket_ids"`
+	Filter    *models.TicketFilter `json:"filter"`
+	Force     bool                 `json:"force"`
 }
 
 type bulkTicketResponse struct {
@@ -27,3 +38,30 @@ func newBulkResponse(changed map[*models.Ticket]*models.TicketEvent) *bulkTicket
 
 	return &bulkTicketResponse{ChangedIDs: ids}
 }
+
+type bulkTaskResponse struct {
+	UUID   uuids.UUID               `json:"uuid"`
+	Status tickets.BulkTicketStatus `json:"status"`
+}
+
+// requests with a filter instead of ticket ids are performed as a batch task, and callers can poll for its progress
+func queueBulkTask(ctx context.Context, rt *runtime.Runtime, request *bulkTicketRequest, task *tickets.BulkTicketTask) (interface{}, int, error) {
+	// an empty filter would match every ticket in the org
+	if request.Filter.IsEmpty() {
+		return errors.New("filter must set at least one condition"), http.StatusBadRequest, nil
+	}
+
+	task.UUID = uuids.New()
+	task.UserID = request.UserID
+	task.Filter = request.Filter
+	task.Force = request.Force
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := tickets.QueueBulkTicketTask(ctx, rc, request.OrgID, task); err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "error queuing bulk ticket task")
+	}
+
+	return &bulkTaskResponse{UUID: task.UUID, Status: tickets.BulkTicketStatusQueued}, http.StatusOK, nil
+}
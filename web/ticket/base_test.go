@@ -4,11 +4,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nyaruka/mailroom/core/models"
 	_ "github.com/nyaruka/mailroom/services/tickets/mailgun"
 	_ "github.com/nyaruka/mailroom/services/tickets/zendesk"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/nyaruka/mailroom/web"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTicketAssign(t *testing.T) {
@@ -74,3 +78,63 @@ func TestTicketReopen(t *testing.T) {
 
 	web.RunWebTests(t, ctx, rt, "testdata/reopen.json", nil)
 }
+
+func TestTicketBulk(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Have you seen my cookies?", "", time.Now(), testdata.Admin)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.SupportTopic, "Have you seen my cookies?", "", time.Now(), nil)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Alexandria, testdata.Internal, testdata.SalesTopic, "Have you seen my cookies?", "", time.Now(), nil)
+
+	web.RunWebTests(t, ctx, rt, "testdata/bulk.json", nil)
+
+	// check the filtered requests were queued as tasks
+	tasks := testsuite.CurrentOrgTasks(t, rp)[testdata.Org1.ID]
+	require.Equal(t, 2, len(tasks))
+	assert.Equal(t, "bulk_ticket", tasks[0].Type)
+	assert.Equal(t, "bulk_ticket", tasks[1].Type)
+}
+
+func TestTicketExport(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Have you seen my cookies?", "", time.Date(2021, 9, 15, 10, 0, 0, 0, time.UTC), nil)
+
+	web.RunWebTests(t, ctx, rt, "testdata/export.json", nil)
+
+	// check the export was queued as a task
+	tasks := testsuite.CurrentOrgTasks(t, rp)[testdata.Org1.ID]
+	require.Equal(t, 1, len(tasks))
+	assert.Equal(t, "export_tickets", tasks[0].Type)
+}
+
+func TestTicketMerge(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.DefaultTopic, "Have you seen my cookies?", "", time.Now(), nil)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.SupportTopic, "Have you seen my cookies?", "", time.Now(), nil)
+	bobTicket := testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.DefaultTopic, "Have you seen my shoes?", "", time.Now(), nil)
+
+	web.RunWebTests(t, ctx, rt, "testdata/merge.json", map[string]string{"bob_ticket_uuid": string(bobTicket.UUID)})
+}
+
+func TestTicketAvailability(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	web.RunWebTests(t, ctx, rt, "testdata/availability.json", nil)
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	unavailable, err := models.GetUnavailableUsers(rc, testdata.Org1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[models.UserID]bool{testdata.Editor.ID: true}, unavailable)
+}
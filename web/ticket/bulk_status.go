@@ -0,0 +1,51 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/ticket/bulk_status", web.RequireAuthToken(handleBulkStatus))
+}
+
+type bulkStatusRequest struct {
+	OrgID models.OrgID `json:"org_id" validate:"required"`
+	UUID  uuids.UUID   `json:"uuid"   validate:"required"`
+}
+
+// Gets the progress of a bulk ticket operation that was given a filter
+//
+//   {
+//     "org_id": 123,
+//     "uuid": "f0a26027-0d12-4c2d-8f3d-b4f5d7c6a1e4"
+//   }
+//
+func handleBulkStatus(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &bulkStatusRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	progress, err := tickets.GetBulkTicketProgress(rc, request.OrgID, request.UUID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "error getting bulk ticket progress")
+	}
+	if progress == nil {
+		return errors.Errorf("no such bulk ticket operation: %s", request.UUID), http.StatusNotFound, nil
+	}
+
+	return progress, http.StatusOK, nil
+}
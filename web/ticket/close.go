@@ -7,6 +7,7 @@ import (
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/tasks/handler"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/web"
 	"github.com/pkg/errors"
@@ -32,6 +33,11 @@ func handleClose(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *m
 		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
 	}
 
+	// if we've been given a filter, tickets are selected and updated by a batch task
+	if request.Filter != nil {
+		return queueBulkTask(ctx, rt, request, &tickets.BulkTicketTask{Action: tickets.BulkTicketActionClose})
+	}
+
 	// grab our org assets
 	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
 	if err != nil {
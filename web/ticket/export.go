@@ -0,0 +1,103 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/ticket/export", web.RequireAuthToken(handleExport))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/ticket/export_status", web.RequireAuthToken(handleExportStatus))
+}
+
+type exportRequest struct {
+	OrgID  models.OrgID               `json:"org_id"  validate:"required"`
+	UserID models.UserID              `json:"user_id" validate:"required"`
+	Format tickets.TicketExportFormat `json:"format"  validate:"required,eq=csv|eq=jsonl"`
+	Filter *models.TicketFilter       `json:"filter"`
+}
+
+type exportResponse struct {
+	UUID   uuids.UUID                 `json:"uuid"`
+	Status tickets.TicketExportStatus `json:"status"`
+}
+
+// Queues an export of the tickets matching the given filter, or all tickets if no filter is given. The user who
+// requested the export is notified when it's finished, and its progress and file URL can be fetched from
+// /mr/ticket/export_status.
+//
+//   {
+//     "org_id": 123,
+//     "user_id": 234,
+//     "format": "csv",
+//     "filter": {"opened_after": "2021-09-01T00:00:00Z", "opened_before": "2021-10-01T00:00:00Z"}
+//   }
+//
+func handleExport(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &exportRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	filter := request.Filter
+	if filter == nil {
+		filter = &models.TicketFilter{}
+	}
+
+	task := &tickets.ExportTicketsTask{
+		UUID:   uuids.New(),
+		UserID: request.UserID,
+		Format: request.Format,
+		Filter: filter,
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := tickets.QueueExportTicketsTask(ctx, rc, request.OrgID, task); err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "error queuing ticket export task")
+	}
+
+	return &exportResponse{UUID: task.UUID, Status: tickets.TicketExportStatusQueued}, http.StatusOK, nil
+}
+
+type exportStatusRequest struct {
+	OrgID models.OrgID `json:"org_id" validate:"required"`
+	UUID  uuids.UUID   `json:"uuid"   validate:"required"`
+}
+
+// Gets the progress of a ticket export, including the URL of the file once it's completed
+//
+//   {
+//     "org_id": 123,
+//     "uuid": "f0a26027-0d12-4c2d-8f3d-b4f5d7c6a1e4"
+//   }
+//
+func handleExportStatus(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &exportStatusRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	progress, err := tickets.GetTicketExportProgress(rc, request.OrgID, request.UUID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "error getting ticket export progress")
+	}
+	if progress == nil {
+		return errors.Errorf("no such ticket export: %s", request.UUID), http.StatusNotFound, nil
+	}
+
+	return progress, http.StatusOK, nil
+}
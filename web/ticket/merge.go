@@ -0,0 +1,72 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/ticket/merge", web.RequireAuthToken(web.WithHTTPLogs(handleMerge)))
+}
+
+type mergeRequest struct {
+	OrgID     models.OrgID      `json:"org_id"     validate:"required"`
+	UserID    models.UserID     `json:"user_id"    validate:"required"`
+	TicketID  models.TicketID   `json:"ticket_id"  validate:"required"`
+	TicketIDs []models.TicketID `json:"ticket_ids" validate:"required"`
+}
+
+// Merges the tickets with the given ids into the primary ticket with the given id. The merged tickets must belong to
+// the same contact, and their notes and events are moved to the primary ticket before they are closed.
+//
+//   {
+//     "org_id": 123,
+//     "user_id": 234,
+//     "ticket_id": 1234,
+//     "ticket_ids": [2345, 3456]
+//   }
+//
+func handleMerge(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *models.HTTPLogger) (interface{}, int, error) {
+	request := &mergeRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	// grab our org assets
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	primaries, err := models.LoadTickets(ctx, rt.DB, []models.TicketID{request.TicketID})
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "error loading tickets for org: %d", request.OrgID)
+	}
+	if len(primaries) != 1 || primaries[0].OrgID() != request.OrgID {
+		return errors.Errorf("no such ticket: %d", request.TicketID), http.StatusNotFound, nil
+	}
+	primary := primaries[0]
+
+	tickets, err := models.LoadTickets(ctx, rt.DB, request.TicketIDs)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "error loading tickets for org: %d", request.OrgID)
+	}
+
+	if err := models.ValidateTicketsMerge(primary, tickets); err != nil {
+		return err, http.StatusBadRequest, nil
+	}
+
+	evts, err := models.TicketsMerge(ctx, rt, oa, request.UserID, primary, tickets, l)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "error merging tickets")
+	}
+
+	return newBulkResponse(evts), http.StatusOK, nil
+}
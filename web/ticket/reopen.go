@@ -6,6 +6,7 @@ import (
 
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/web"
 
@@ -30,6 +31,11 @@ func handleReopen(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *
 		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
 	}
 
+	// if we've been given a filter, tickets are selected and updated by a batch task
+	if request.Filter != nil {
+		return queueBulkTask(ctx, rt, request, &tickets.BulkTicketTask{Action: tickets.BulkTicketActionReopen})
+	}
+
 	// grab our org assets
 	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
 	if err != nil {
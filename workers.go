@@ -8,8 +8,19 @@ import (
 
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/metrics"
+	"github.com/nyaruka/mailroom/utils/tracing"
 
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	taskDuration = metrics.NewHistogram("mailroom_task_duration_seconds", "the time taken to handle tasks", metrics.DefaultBuckets, "queue", "type")
+	taskErrors   = metrics.NewCounter("mailroom_task_errors_total", "the number of tasks which errored or panicked", "queue", "type")
 )
 
 // Foreman takes care of managing our set of workers and assigns msgs for each to send
@@ -20,18 +31,28 @@ type Foreman struct {
 	workers          []*Worker
 	availableWorkers chan *Worker
 	quit             chan bool
+
+	// context for running tasks, cancelled if they don't finish within the drain timeout on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// whether failed tasks of retryable types are retried, and failed tasks are moved to the dead letter queue
+	retryFailed bool
 }
 
-// NewForeman creates a new Foreman for the passed in server with the number of max workers
-func NewForeman(rt *runtime.Runtime, wg *sync.WaitGroup, queue string, maxWorkers int) *Foreman {
+// NewForeman creates a new Foreman for the passed in server with the number of max workers. If retryFailed is
+// true then tasks which fail will be dead lettered, after being retried with backoff if they are of a retryable type.
+func NewForeman(rt *runtime.Runtime, wg *sync.WaitGroup, queue string, maxWorkers int, retryFailed bool) *Foreman {
 	foreman := &Foreman{
 		rt:               rt,
 		wg:               wg,
 		queue:            queue,
+		retryFailed:      retryFailed,
 		workers:          make([]*Worker, maxWorkers),
 		availableWorkers: make(chan *Worker, maxWorkers),
 		quit:             make(chan bool),
 	}
+	foreman.ctx, foreman.cancel = context.WithCancel(context.Background())
 
 	for i := 0; i < maxWorkers; i++ {
 		foreman.workers[i] = NewWorker(foreman, i)
@@ -48,13 +69,18 @@ func (f *Foreman) Start() {
 	go f.Assign()
 }
 
-// Stop stops the foreman and all its workers, the wait group of the worker can be used to track progress
+// Stop stops the foreman and all its workers, the wait group of the worker can be used to track progress. Workers
+// finish their current tasks but if those don't finish within the drain timeout, they are cancelled and requeued.
 func (f *Foreman) Stop() {
 	for _, worker := range f.workers {
 		worker.Stop()
 	}
 	close(f.quit)
-	logrus.WithField("comp", "foreman").WithField("queue", f.queue).WithField("state", "stopping").Info("foreman stopping")
+
+	drainTimeout := time.Millisecond * time.Duration(f.rt.Config.ShutdownDrainTimeout)
+	time.AfterFunc(drainTimeout, f.cancel)
+
+	logrus.WithField("comp", "foreman").WithField("queue", f.queue).WithField("state", "stopping").WithField("drain_timeout", drainTimeout).Info("foreman stopping")
 }
 
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing task from our
@@ -161,21 +187,51 @@ func (w *Worker) Stop() {
 func (w *Worker) handleTask(task *queue.Task) {
 	log := logrus.WithField("queue", w.foreman.queue).WithField("worker_id", w.id).WithField("task_type", task.Type).WithField("org_id", task.OrgID)
 
+	var taskErr error
+
+	// handling of the task continues the trace of whatever queued it
+	ctx, span := tracing.Tracer().Start(task.Context(w.foreman.ctx), "task "+task.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("queue", w.foreman.queue), attribute.Int("org_id", task.OrgID), attribute.Int("error_count", task.ErrorCount)),
+	)
+
 	defer func() {
 		// catch any panics and recover
 		panicLog := recover()
 		if panicLog != nil {
 			debug.PrintStack()
 			log.WithField("task", string(task.Task)).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Errorf("panic handling task: %s", panicLog)
+			taskErr = errors.Errorf("panic handling task: %s", panicLog)
 		}
 
-		// mark our task as complete
+		if taskErr != nil {
+			taskErrors.Inc(w.foreman.queue, task.Type)
+		}
+		tracing.EndSpan(span, taskErr)
+
 		rc := w.foreman.rt.RP.Get()
+		defer rc.Close()
+
+		// if task was interrupted by shutdown, put it back on the queue as it was, even if it didn't return an error as
+		// tasks can't be relied on to report their cancellation
+		if w.foreman.ctx.Err() != nil {
+			log.Warn("task interrupted by shutdown, requeuing")
+
+			if err := queue.RequeueTask(rc, w.foreman.queue, task); err != nil {
+				log.WithError(err).WithField("task", string(task.Task)).Error("error requeuing interrupted task")
+			}
+		} else if taskErr != nil && w.foreman.retryFailed {
+			// if task failed, retry it or move it to the dead letter queue
+			if err := w.foreman.retryOrDeadLetter(rc, task, taskErr); err != nil {
+				log.WithError(err).Error("error requeuing failed task")
+			}
+		}
+
+		// mark our task as complete
 		err := queue.MarkTaskComplete(rc, w.foreman.queue, task.OrgID)
 		if err != nil {
-			log.WithError(err)
+			log.WithError(err).Error("error marking task complete")
 		}
-		rc.Close()
 	}()
 
 	log.Info("starting handling of task")
@@ -183,15 +239,16 @@ func (w *Worker) handleTask(task *queue.Task) {
 
 	taskFunc, found := taskFunctions[task.Type]
 	if found {
-		err := taskFunc(context.Background(), w.foreman.rt, task)
-		if err != nil {
-			log.WithError(err).WithField("task", string(task.Task)).Error("error running task")
+		taskErr = taskFunc(ctx, w.foreman.rt, task)
+		if taskErr != nil {
+			log.WithError(taskErr).WithField("task", string(task.Task)).WithField("error_count", task.ErrorCount).Error("error running task")
 		}
 	} else {
 		log.Error("unable to find function for task type")
 	}
 
 	elapsed := time.Since(start)
+	taskDuration.ObserveDuration(elapsed, w.foreman.queue, task.Type)
 
 	log.WithField("elapsed", elapsed).Info("task complete")
 
@@ -200,3 +257,23 @@ func (w *Worker) handleTask(task *queue.Task) {
 		log.WithField("task", string(task.Task)).WithField("elapsed", elapsed).Warn("long running task")
 	}
 }
+
+// retryOrDeadLetter re-queues a failed task with exponential backoff, or if it isn't of a retryable type or has already
+// been retried the configured number of times, moves it to the dead letter queue
+func (f *Foreman) retryOrDeadLetter(rc redis.Conn, task *queue.Task, taskErr error) error {
+	log := logrus.WithField("queue", f.queue).WithField("task_type", task.Type).WithField("org_id", task.OrgID)
+
+	task.ErrorCount++
+
+	if retryableTasks[task.Type] && task.ErrorCount <= f.rt.Config.BatchTaskRetries {
+		delay := queue.RetryDelay(time.Millisecond*time.Duration(f.rt.Config.BatchTaskBackoff), task.ErrorCount)
+
+		log.WithField("error_count", task.ErrorCount).WithField("delay", delay).Info("retrying failed task")
+
+		return queue.RetryTask(rc, f.queue, task, delay)
+	}
+
+	log.WithError(taskErr).WithField("task", string(task.Task)).WithField("error_count", task.ErrorCount).Error("task failed permanently, moving to dead letter queue")
+
+	return queue.DeadLetterTask(rc, f.queue, task, taskErr)
+}
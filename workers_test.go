@@ -0,0 +1,140 @@
+package mailroom
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryOrDeadLetter(t *testing.T) {
+	_, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	noop := func(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error { return nil }
+	AddRetryableTaskFunction("test_idempotent", noop)
+	AddTaskFunction("test_not_idempotent", noop)
+	defer func() {
+		delete(taskFunctions, "test_idempotent")
+		delete(taskFunctions, "test_not_idempotent")
+		delete(retryableTasks, "test_idempotent")
+	}()
+
+	foreman := NewForeman(rt, &sync.WaitGroup{}, queue.BatchQueue, 1, true)
+	taskErr := errors.New("boom")
+
+	assertQueued := func(delayed, dead int) {
+		actualDelayed, err := queue.DelayedSize(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		actualDead, err := queue.DeadLetterSize(rc, queue.BatchQueue)
+		require.NoError(t, err)
+
+		assert.Equal(t, delayed, actualDelayed, "delayed tasks mismatch")
+		assert.Equal(t, dead, actualDead, "dead tasks mismatch")
+	}
+
+	// tasks of retryable types are retried until they've failed too many times
+	task := &queue.Task{Type: "test_idempotent", OrgID: 1, Task: json.RawMessage(`{}`)}
+	for i := 1; i <= rt.Config.BatchTaskRetries; i++ {
+		require.NoError(t, foreman.retryOrDeadLetter(rc, task, taskErr))
+		assert.Equal(t, i, task.ErrorCount)
+		assertQueued(i, 0)
+	}
+
+	require.NoError(t, foreman.retryOrDeadLetter(rc, task, taskErr))
+	assertQueued(rt.Config.BatchTaskRetries, 1)
+
+	// tasks of other types go straight to the dead letter queue
+	task = &queue.Task{Type: "test_not_idempotent", OrgID: 1, Task: json.RawMessage(`{}`)}
+	require.NoError(t, foreman.retryOrDeadLetter(rc, task, taskErr))
+	assert.Equal(t, 1, task.ErrorCount)
+	assertQueued(rt.Config.BatchTaskRetries, 2)
+}
+
+func TestStopRequeuesInterruptedTasks(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	// tasks which run until they're cancelled, one reporting the cancellation and one swallowing it
+	started := make(chan bool, 1)
+	blockUntilCancelled := func(swallow bool) func(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error {
+		return func(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error {
+			started <- true
+			<-ctx.Done()
+			if swallow {
+				return nil
+			}
+			return ctx.Err()
+		}
+	}
+	AddRetryableTaskFunction("test_blocking", blockUntilCancelled(false))
+	AddRetryableTaskFunction("test_blocking_swallowing", blockUntilCancelled(true))
+	defer func() {
+		delete(taskFunctions, "test_blocking")
+		delete(taskFunctions, "test_blocking_swallowing")
+		delete(retryableTasks, "test_blocking")
+		delete(retryableTasks, "test_blocking_swallowing")
+	}()
+
+	defer func(timeout int) { rt.Config.ShutdownDrainTimeout = timeout }(rt.Config.ShutdownDrainTimeout)
+	rt.Config.ShutdownDrainTimeout = 100
+
+	for _, taskType := range []string{"test_blocking", "test_blocking_swallowing"} {
+		err := queue.AddTask(ctx, rc, queue.BatchQueue, taskType, 1, map[string]int{"foo": 1}, queue.HighPriority)
+		require.NoError(t, err)
+
+		wg := &sync.WaitGroup{}
+		foreman := NewForeman(rt, wg, queue.BatchQueue, 1, true)
+		foreman.Start()
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "task wasn't started", taskType)
+		}
+
+		// stopping the foreman cancels the task after the drain timeout and waits for it to return
+		start := time.Now()
+		foreman.Stop()
+		wg.Wait()
+
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+		// and the interrupted task is requeued as it was, rather than being retried or dead lettered
+		size, err := queue.Size(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		assert.Equal(t, 1, size, "queued tasks mismatch for %s", taskType)
+
+		delayed, err := queue.DelayedSize(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		assert.Equal(t, 0, delayed, "delayed tasks mismatch for %s", taskType)
+
+		dead, err := queue.DeadLetterSize(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		assert.Equal(t, 0, dead, "dead tasks mismatch for %s", taskType)
+
+		task, err := queue.PopNextTask(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		assert.Equal(t, taskType, task.Type)
+		assert.Equal(t, 0, task.ErrorCount)
+		assert.Equal(t, queue.HighPriority, task.Priority)
+		assert.JSONEq(t, `{"foo": 1}`, string(task.Task))
+
+		require.NoError(t, queue.MarkTaskComplete(rc, queue.BatchQueue, 1))
+	}
+}